@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AnthropicDefaultEndpoint  = "https://api.anthropic.com/v1/messages"
+	AnthropicVersion          = "2023-06-01"
+	AnthropicDefaultMaxTokens = 4096
+)
+
+// AnthropicProvider talks to the Anthropic Messages API.
+type AnthropicProvider struct {
+	cfg AIProvider
+}
+
+// anthropicMessage content is a list of anthropicText, anthropicImage,
+// anthropicToolUse and anthropicToolResult blocks
+type anthropicMessage struct {
+	Role    string        `json:"role"`
+	Content []interface{} `json:"content"`
+}
+
+type anthropicText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type anthropicImage struct {
+	Type   string `json:"type"`
+	Source struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	} `json:"source"`
+}
+
+type anthropicToolUse struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type anthropicToolResult struct {
+	Type      string `json:"type"`
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+}
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type anthropicResponse struct {
+	Type    string `json:"type"`
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		ID    string          `json:"id"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *anthropicError `json:"error"`
+}
+
+// anthropicEvent covers every event type of the Messages streaming API.
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Usage *anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *anthropicError `json:"error"`
+}
+
+func newAnthropicProvider(cfg AIProvider) Provider {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = AnthropicDefaultEndpoint
+	}
+	return &AnthropicProvider{cfg: cfg}
+}
+
+func (p *AnthropicProvider) Name() string {
+	return p.cfg.Name
+}
+
+// toAnthropicRequest keeps system prompts out of the message list and merges
+// consecutive turns of the same role, which the API rejects. Tool calls
+// become tool_use blocks and tool results tool_result blocks in a user turn.
+// The API has no seed parameter, so a requested seed is dropped.
+func (p *AnthropicProvider) toAnthropicRequest(req Request) anthropicRequest {
+	out := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     AnthropicDefaultMaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		Stream:        req.Stream,
+	}
+	if out.Model == "" {
+		out.Model = p.cfg.Model
+	}
+	if req.MaxTokens != nil {
+		out.MaxTokens = *req.MaxTokens
+	}
+
+	var system []string
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+
+		role := "user"
+		var blocks []interface{}
+		switch msg.Role {
+		case "assistant":
+			role = "assistant"
+			// An empty text block is rejected, e.g. from an interrupted reply
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicText{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicToolUse{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+		case "tool":
+			blocks = append(blocks, anthropicToolResult{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		default:
+			// Anthropic recommends images before the question about them
+			for _, img := range msg.images() {
+				block := anthropicImage{Type: "image"}
+				block.Source.Type = "base64"
+				block.Source.MediaType = img.MimeType
+				block.Source.Data = img.Data
+				blocks = append(blocks, block)
+			}
+			if msg.Content != "" || len(blocks) == 0 {
+				blocks = append(blocks, anthropicText{Type: "text", Text: msg.Content})
+			}
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+			out.Messages[n-1].Content = append(out.Messages[n-1].Content, blocks...)
+			continue
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	if req.ResponseFormat != nil {
+		system = append(system, req.ResponseFormat.instruction())
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+	if req.ToolChoice == "none" && len(out.Tools) > 0 {
+		out.ToolChoice = &anthropicToolChoice{Type: "none"}
+	}
+
+	return out
+}
+
+func (p *AnthropicProvider) newRequest(ctx context.Context, method, url string, payload interface{}) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.cfg.APIKey)
+	httpReq.Header.Set("anthropic-version", AnthropicVersion)
+	return httpReq, nil
+}
+
+func toUsage(u *anthropicUsage) *Usage {
+	if u == nil {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+func (p *AnthropicProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	req.Stream = false
+
+	httpReq, err := p.newRequest(ctx, "POST", p.cfg.Endpoint, p.toAnthropicRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var anthropicResp anthropicResponse
+	if err := json.Unmarshal(body, &anthropicResp); err != nil {
+		return nil, fmt.Errorf("failed to decode Anthropic response: %w", err)
+	}
+
+	response := &Response{Usage: toUsage(anthropicResp.Usage)}
+	if anthropicResp.Error != nil {
+		response.Error = &APIError{Message: anthropicResp.Error.Message, Type: anthropicResp.Error.Type}
+		return response, nil
+	}
+
+	var sb strings.Builder
+	var toolCalls []ToolCall
+	for _, block := range anthropicResp.Content {
+		switch block.Type {
+		case "text":
+			sb.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: ToolCallFunction{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	response.Choices = []Choice{
+		{Message: Message{Role: "assistant", Content: sb.String(), ToolCalls: toolCalls}},
+	}
+
+	return response, nil
+}
+
+func (p *AnthropicProvider) ChatStream(ctx context.Context, req Request, onDelta func(string)) (*Response, error) {
+	req.Stream = true
+
+	httpReq, err := p.newRequest(ctx, "POST", p.cfg.Endpoint, p.toAnthropicRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 300 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	var fullContent strings.Builder
+	var toolCalls []ToolCall
+	toolIndex := map[int]int{} // content block index -> position in toolCalls
+	usage := &Usage{}
+
+	err = readSSE(resp.Body, func(data string) error {
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil && event.Message.Usage != nil {
+				usage.PromptTokens = event.Message.Usage.InputTokens
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+				toolIndex[event.Index] = len(toolCalls)
+				toolCalls = append(toolCalls, ToolCall{
+					ID:       event.ContentBlock.ID,
+					Type:     "function",
+					Function: ToolCallFunction{Name: event.ContentBlock.Name},
+				})
+			}
+		case "content_block_delta":
+			if event.Delta == nil {
+				break
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				if event.Delta.Text != "" {
+					fullContent.WriteString(event.Delta.Text)
+					if onDelta != nil {
+						onDelta(event.Delta.Text)
+					}
+				}
+			case "input_json_delta":
+				if i, ok := toolIndex[event.Index]; ok {
+					toolCalls[i].Function.Arguments += event.Delta.PartialJSON
+				}
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			if event.Error != nil {
+				return fmt.Errorf("%s: %s", event.Error.Type, event.Error.Message)
+			}
+			return fmt.Errorf("unknown stream error")
+		}
+		return nil
+	})
+
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	// A tool call without arguments streams no input_json_delta at all
+	for i := range toolCalls {
+		if toolCalls[i].Function.Arguments == "" {
+			toolCalls[i].Function.Arguments = "{}"
+		}
+	}
+
+	response := &Response{
+		Choices: []Choice{
+			{Message: Message{Role: "assistant", Content: fullContent.String(), ToolCalls: toolCalls}},
+		},
+		Usage: usage,
+	}
+	return response, err
+}
+
+func (p *AnthropicProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := p.newRequest(ctx, "GET", replaceEndpointPath(p.cfg.Endpoint, "/messages", "/models?limit=1000"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(result.Data))
+	for _, m := range result.Data {
+		models = append(models, ModelInfo{ID: m.ID, Name: m.DisplayName})
+	}
+
+	return models, nil
+}
+
+func (p *AnthropicProvider) Embed(ctx context.Context, model, text string) ([]float32, error) {
+	return nil, fmt.Errorf("anthropic does not provide an embeddings API")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAnthropic serves the given SSE events and records the request body
+func fakeAnthropic(t *testing.T, events []string, got *anthropicRequest) *AnthropicProvider {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != AnthropicVersion {
+			t.Errorf("missing auth headers: %v", r.Header)
+		}
+		if got != nil {
+			if err := json.NewDecoder(r.Body).Decode(got); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return newAnthropicProvider(AIProvider{Name: "anthropic", Endpoint: server.URL, APIKey: "test-key", Model: "claude-test"}).(*AnthropicProvider)
+}
+
+func TestAnthropicStreamUsage(t *testing.T) {
+	var got anthropicRequest
+	p := fakeAnthropic(t, []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text"}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}`,
+		`{"type":"message_stop"}`,
+	}, &got)
+
+	var deltas []string
+	resp, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(s string) {
+		deltas = append(deltas, s)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if !got.Stream || got.Model != "claude-test" {
+		t.Errorf("request stream=%v model=%q", got.Stream, got.Model)
+	}
+	if text := resp.Choices[0].Message.Content; text != "Hello world" {
+		t.Errorf("content = %q", text)
+	}
+	if strings.Join(deltas, "|") != "Hello| world" {
+		t.Errorf("deltas = %q", deltas)
+	}
+	want := Usage{PromptTokens: 12, CompletionTokens: 7, TotalTokens: 19}
+	if resp.Usage == nil || *resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+}
+
+func TestAnthropicStreamToolUse(t *testing.T) {
+	p := fakeAnthropic(t, []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":3}}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"rag_search"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"query\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"go\"}"}}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"memory_recall"}}`,
+		`{"type":"message_stop"}`,
+	}, nil)
+
+	resp, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, nil)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	calls := resp.Choices[0].Message.ToolCalls
+	if len(calls) != 2 {
+		t.Fatalf("got %d tool calls", len(calls))
+	}
+	if calls[0].ID != "toolu_1" || calls[0].Function.Name != "rag_search" || calls[0].Function.Arguments != `{"query":"go"}` {
+		t.Errorf("first call = %+v", calls[0])
+	}
+	if calls[1].Function.Arguments != "{}" {
+		t.Errorf("call without input has arguments %q", calls[1].Function.Arguments)
+	}
+}
+
+func TestAnthropicStreamErrorEvent(t *testing.T) {
+	p := fakeAnthropic(t, []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":3}}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"partial"}}`,
+		`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	}, nil)
+
+	resp, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error: Overloaded") {
+		t.Fatalf("err = %v, want overloaded_error", err)
+	}
+	if resp.Choices[0].Message.Content != "partial" {
+		t.Errorf("partial content = %q", resp.Choices[0].Message.Content)
+	}
+}
+
+func TestAnthropicStreamCutOff(t *testing.T) {
+	p := fakeAnthropic(t, []string{
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"half"}}`,
+	}, nil)
+
+	if _, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, nil); err == nil {
+		t.Fatal("a stream without message_stop should fail")
+	}
+}
+
+func TestAnthropicHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`)
+	}))
+	defer server.Close()
+	p := newAnthropicProvider(AIProvider{Name: "anthropic", Endpoint: server.URL})
+
+	_, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "slow down") {
+		t.Fatalf("err = %v, want the API message", err)
+	}
+}
+
+func TestToAnthropicRequest(t *testing.T) {
+	p := &AnthropicProvider{cfg: AIProvider{Model: "claude-default"}}
+	maxTokens := 100
+	req := Request{
+		Messages: []Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "first"},
+			{Role: "user", Content: "second"},
+			{Role: "system", Content: "Answer in Malay."},
+			{Role: "assistant", Content: "", ToolCalls: []ToolCall{{ID: "c1", Type: "function", Function: ToolCallFunction{Name: "rag_search", Arguments: "not json"}}}},
+			{Role: "tool", ToolCallID: "c1", Content: "result"},
+			{Role: "user", Content: "thanks"},
+		},
+		GenerationParams: GenerationParams{MaxTokens: &maxTokens},
+	}
+
+	out := p.toAnthropicRequest(req)
+	if out.System != "Be brief.\n\nAnswer in Malay." {
+		t.Errorf("system = %q", out.System)
+	}
+	if out.Model != "claude-default" || out.MaxTokens != 100 {
+		t.Errorf("model=%q max_tokens=%d", out.Model, out.MaxTokens)
+	}
+
+	var roles []string
+	for _, msg := range out.Messages {
+		roles = append(roles, fmt.Sprintf("%s:%d", msg.Role, len(msg.Content)))
+	}
+	// consecutive user turns are merged; the tool result and the next
+	// question share one user turn
+	if got := strings.Join(roles, " "); got != "user:2 assistant:1 user:2" {
+		t.Errorf("messages = %s", got)
+	}
+
+	use, ok := out.Messages[1].Content[0].(anthropicToolUse)
+	if !ok || use.ID != "c1" || string(use.Input) != "{}" {
+		t.Errorf("tool_use block = %#v", out.Messages[1].Content[0])
+	}
+	result, ok := out.Messages[2].Content[0].(anthropicToolResult)
+	if !ok || result.ToolUseID != "c1" || result.Content != "result" {
+		t.Errorf("tool_result block = %#v", out.Messages[2].Content[0])
+	}
+}
+
+func TestToAnthropicRequestDropsEmptyAssistant(t *testing.T) {
+	p := &AnthropicProvider{cfg: AIProvider{Model: "claude-default"}}
+	out := p.toAnthropicRequest(Request{Messages: []Message{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: ""},
+		{Role: "user", Content: "continue"},
+		{Role: "assistant", Content: "answer"},
+	}})
+
+	var roles []string
+	for _, msg := range out.Messages {
+		roles = append(roles, fmt.Sprintf("%s:%d", msg.Role, len(msg.Content)))
+		for _, block := range msg.Content {
+			if text, ok := block.(anthropicText); ok && text.Text == "" {
+				t.Errorf("empty text block in %s turn", msg.Role)
+			}
+		}
+	}
+	// the empty reply is dropped and the user turns around it merged
+	if got := strings.Join(roles, " "); got != "user:2 assistant:1" {
+		t.Errorf("messages = %s", got)
+	}
+}
+
+func TestToAnthropicRequestDefaults(t *testing.T) {
+	p := &AnthropicProvider{cfg: AIProvider{Model: "claude-default"}}
+	out := p.toAnthropicRequest(Request{Model: "claude-other", Messages: []Message{{Role: "user", Content: "hi"}}})
+	if out.MaxTokens != AnthropicDefaultMaxTokens || out.Model != "claude-other" || out.System != "" {
+		t.Errorf("defaults = %+v", out)
+	}
+}
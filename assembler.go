@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultInjectTokens   = 2000
+	DefaultMinRAGScore    = 0.2
+	DefaultMinMemoryScore = 0.4
+	DefaultContextResults = 3
+	maxSnippetChars       = 1200
+)
+
+// ContextSource is one skill, document or memory injected into a request.
+// The list is stored on the reply so it is clear what the model was shown.
+type ContextSource struct {
+	Kind      string  `json:"kind"` // skill, document or memory
+	Name      string  `json:"name"`
+	Score     float64 `json:"score,omitempty"`
+	Tokens    int     `json:"tokens"`
+	Truncated bool    `json:"truncated,omitempty"`
+}
+
+// ContextAssembler selects the skills, RAG documents and memories relevant
+// to a message. User and Visibility scope the document search as in
+// searchRAGWithFilters; the CLI leaves both empty to search the whole local
+// index. Memory enables the personal memory search, which belongs to the CLI
+// owner and is left off for web users. Only memories with the assembler's
+// User are searched, so what web users save with memory_save never reaches
+// the owner's prompts.
+type ContextAssembler struct {
+	User       string
+	Visibility string
+	Memory     bool
+	Model      string
+}
+
+// AssembledContext is sent as a system message right before the user's
+// message, so the user's text is never changed
+type AssembledContext struct {
+	Skills  []Skill
+	Content string
+	Sources []ContextSource
+	Tokens  int
+}
+
+const injectedContextHeader = "Context for the user's next message, gathered automatically. Use what is relevant; it was not written by the user."
+
+// assemble applies matching skills first, then memories and documents above
+// their relevance thresholds until inject_tokens is used up. An item that
+// only partly fits is cut short.
+func (a ContextAssembler) assemble(ctx context.Context, message string) AssembledContext {
+	cfg := contextConfig()
+	var out AssembledContext
+	var sections []string
+	remaining := cfg.InjectTokens - estimateTokens(a.Model, injectedContextHeader)
+
+	add := func(kind, name string, score float64, title, text string) {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return
+		}
+		tokens := estimateTokens(a.Model, title+"\n"+text)
+		truncated := false
+		if tokens > remaining {
+			if remaining < 50 {
+				return
+			}
+			runes := []rune(text)
+			text = string(runes[:len(runes)*remaining/tokens]) + "…"
+			tokens = estimateTokens(a.Model, title+"\n"+text)
+			truncated = true
+		}
+		remaining -= tokens
+		sections = append(sections, title+"\n"+text)
+		out.Sources = append(out.Sources, ContextSource{Kind: kind, Name: name, Score: score, Tokens: tokens, Truncated: truncated})
+	}
+
+	for _, skill := range findMatchingSkills(message) {
+		out.Skills = append(out.Skills, skill)
+		add("skill", skill.Name, 0, "### Skill: "+skill.Name, skill.Template)
+	}
+
+	if a.Memory {
+		if mgr := GetEncryptedMemoryManager(); mgr != nil {
+			results, _ := mgr.SearchUserAndDecrypt(ctx, message, a.User, cfg.MemoryResults)
+			for _, result := range results {
+				if float64(result.Similarity) >= cfg.MinMemoryScore {
+					add("memory", result.Memory.ID, float64(result.Similarity), "### Memory", result.Memory.Content)
+				}
+			}
+		}
+	}
+
+	count := 0
+	for _, match := range rankRAGDocuments(message, a.User, a.Visibility) {
+		if count == cfg.RAGResults {
+			break
+		}
+		if match.Score < cfg.MinRAGScore {
+			continue
+		}
+		snippet := match.Doc.Content
+		if runes := []rune(snippet); len(runes) > maxSnippetChars {
+			snippet = string(runes[:maxSnippetChars]) + "…"
+		}
+		add("document", match.Doc.Path, match.Score, "### Document: "+match.Doc.Path, snippet)
+		count++
+	}
+
+	if len(sections) > 0 {
+		out.Content = injectedContextHeader + "\n\n" + strings.Join(sections, "\n\n")
+		out.Tokens = estimateMessageTokens(a.Model, Message{Role: "system", Content: out.Content})
+	}
+	return out
+}
+
+// inject inserts the context before the last message
+func (c AssembledContext) inject(messages []Message) []Message {
+	if c.Content == "" || len(messages) == 0 {
+		return messages
+	}
+	last := len(messages) - 1
+	out := append([]Message{}, messages[:last]...)
+	out = append(out, Message{Role: "system", Content: c.Content})
+	return append(out, messages[last])
+}
+
+// String lists the sources for the CLI, e.g. "skill code-review, 2 documents"
+func (c AssembledContext) String() string {
+	var skills []string
+	documents, memories := 0, 0
+	for _, source := range c.Sources {
+		switch source.Kind {
+		case "skill":
+			skills = append(skills, source.Name)
+		case "document":
+			documents++
+		case "memory":
+			memories++
+		}
+	}
+	var parts []string
+	if len(skills) > 0 {
+		parts = append(parts, "skill "+strings.Join(skills, ", "))
+	}
+	if documents > 0 {
+		parts = append(parts, fmt.Sprintf("%d document(s)", documents))
+	}
+	if memories > 0 {
+		parts = append(parts, fmt.Sprintf("%d memory(ies)", memories))
+	}
+	return fmt.Sprintf("%s (~%d tokens)", strings.Join(parts, ", "), c.Tokens)
+}
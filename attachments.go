@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	MaxAttachmentSize   = 20 * 1024 * 1024
+	MaxAttachments      = 10
+	maxUploadFormMemory = 32 * 1024 * 1024
+)
+
+// Attachment is an image sent with a message. Data is held in memory until
+// the message is saved to history; from then on Path points to the stored
+// copy under the attachments directory.
+type Attachment struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int    `json:"size"`
+	Path     string `json:"path,omitempty"`
+	Data     []byte `json:"-"`
+}
+
+// ContentPart is one element of an OpenAI-style content array
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+// inlineImage is an attachment ready for a provider payload
+type inlineImage struct {
+	MimeType string
+	Data     string // base64
+}
+
+// cliImages come from --image and go with the first message
+var cliImages []Attachment
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func getAttachmentsDir() string {
+	return filepath.Join(getDataDir(), "attachments")
+}
+
+func newAttachment(name string, data []byte) (Attachment, error) {
+	if len(data) == 0 {
+		return Attachment{}, fmt.Errorf("%s is empty", name)
+	}
+	if len(data) > MaxAttachmentSize {
+		return Attachment{}, fmt.Errorf("%s is larger than %d MB", name, MaxAttachmentSize/1024/1024)
+	}
+	mimeType := http.DetectContentType(data)
+	if _, ok := imageExtensions[mimeType]; !ok {
+		return Attachment{}, fmt.Errorf("%s is not a supported image (%s), use PNG, JPEG, GIF or WebP", name, mimeType)
+	}
+	return Attachment{Name: filepath.Base(name), MimeType: mimeType, Size: len(data), Data: data}, nil
+}
+
+func loadAttachmentFile(path string) (Attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	return newAttachment(path, data)
+}
+
+// attachmentFromDataURL decodes "data:image/png;base64,..." or plain base64
+func attachmentFromDataURL(name, value string) (Attachment, error) {
+	payload := value
+	if rest, ok := strings.CutPrefix(value, "data:"); ok {
+		meta, encoded, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(meta, ";base64") {
+			return Attachment{}, fmt.Errorf("%s must be a base64 data URL", name)
+		}
+		payload = encoded
+	} else if strings.Contains(value, "://") {
+		return Attachment{}, fmt.Errorf("%s must be a data URL, remote images are not fetched", name)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("%s is not valid base64: %w", name, err)
+	}
+	return newAttachment(name, data)
+}
+
+func (a Attachment) bytes() ([]byte, error) {
+	if a.Data != nil {
+		return a.Data, nil
+	}
+	if a.Path == "" {
+		return nil, fmt.Errorf("no data")
+	}
+	return os.ReadFile(a.Path)
+}
+
+func (a Attachment) String() string {
+	return fmt.Sprintf("%s (%s, %.1f KB)", a.Name, a.MimeType, float64(a.Size)/1024)
+}
+
+// storeAttachments writes in-memory attachments to the attachments
+// directory, named by content hash so a re-sent image is stored once.
+func storeAttachments(attachments []Attachment) ([]Attachment, error) {
+	stored := make([]Attachment, len(attachments))
+	for i, a := range attachments {
+		if a.Path == "" {
+			sum := sha256.Sum256(a.Data)
+			path := filepath.Join(getAttachmentsDir(), hex.EncodeToString(sum[:])+imageExtensions[a.MimeType])
+			if _, err := os.Stat(path); err != nil {
+				if err := os.MkdirAll(getAttachmentsDir(), 0755); err != nil {
+					return nil, err
+				}
+				if err := os.WriteFile(path, a.Data, 0644); err != nil {
+					return nil, fmt.Errorf("failed to store %s: %w", a.Name, err)
+				}
+			}
+			a.Path = path
+		}
+		stored[i] = a
+	}
+	return stored, nil
+}
+
+// images returns the message's attachments base64 encoded. An attachment
+// whose stored copy is gone is left out with a warning rather than failing
+// the whole conversation.
+func (m Message) images() []inlineImage {
+	var out []inlineImage
+	for _, a := range m.Attachments {
+		data, err := a.bytes()
+		if err != nil {
+			fmt.Fprintf(statusOut, "⚠️  Attachment %s unavailable: %v\n", a.Name, err)
+			continue
+		}
+		out = append(out, inlineImage{MimeType: a.MimeType, Data: base64.StdEncoding.EncodeToString(data)})
+	}
+	return out
+}
+
+// MarshalJSON sends a message with attachments as an OpenAI content array,
+// text first and then one image_url part per image.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	if len(m.Attachments) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	var parts []ContentPart
+	if m.Content != "" {
+		parts = append(parts, ContentPart{Type: "text", Text: m.Content})
+	}
+	for _, img := range m.images() {
+		parts = append(parts, ContentPart{
+			Type:     "image_url",
+			ImageURL: &ImageURL{URL: "data:" + img.MimeType + ";base64," + img.Data},
+		})
+	}
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), parts})
+}
+
+// UnmarshalJSON accepts content as a string or as a content array. Text
+// parts are joined and image_url data URLs become attachments.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type plain Message
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Message(raw.plain)
+
+	content := strings.TrimSpace(string(raw.Content))
+	if !strings.HasPrefix(content, "[") {
+		if content == "" || content == "null" {
+			return nil
+		}
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return err
+	}
+	var texts []string
+	for i, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			if part.ImageURL == nil {
+				return fmt.Errorf("content part %d has no image_url", i)
+			}
+			attachment, err := attachmentFromDataURL(fmt.Sprintf("image-%d", len(m.Attachments)+1), part.ImageURL.URL)
+			if err != nil {
+				return err
+			}
+			m.Attachments = append(m.Attachments, attachment)
+		default:
+			return fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// extractImageRefs attaches every "@path" word of a CLI message that names
+// an image file, e.g. "what is wrong in @screenshot.png". The "@" is dropped
+// from the text so the model still sees which file was meant.
+func extractImageRefs(message string) (string, []Attachment, error) {
+	var attachments []Attachment
+	for _, word := range strings.Fields(message) {
+		path, ok := strings.CutPrefix(word, "@")
+		if !ok || !isImagePath(path) {
+			continue
+		}
+		attachment, err := loadAttachmentFile(expandHome(path))
+		if err != nil {
+			return message, nil, err
+		}
+		attachments = append(attachments, attachment)
+		message = strings.Replace(message, word, path, 1)
+	}
+	return message, attachments, nil
+}
+
+func isImagePath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// withImageRefs adds the images a CLI message refers to. It reports a
+// missing or unsupported image and returns false.
+func withImageRefs(message string, attachments []Attachment) (string, []Attachment, bool) {
+	message, refs, err := extractImageRefs(message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return message, nil, false
+	}
+	return message, append(attachments, refs...), true
+}
+
+func printAttachments(attachments []Attachment) {
+	for _, a := range attachments {
+		fmt.Fprintf(statusOut, "📎 Attached: %s\n", a)
+	}
+}
+
+// ImageOptions is embedded in web requests that accept images as base64
+// data URLs in JSON
+type ImageOptions struct {
+	Images []string `json:"images,omitempty"`
+}
+
+// decodeWithUploads reads a JSON body, or a multipart form with the JSON in
+// a "request" field (or plain message/provider/system/session_id fields)
+// and image files under "image". It returns all images from either source.
+func decodeWithUploads(w http.ResponseWriter, r *http.Request, body interface{}, opts *ImageOptions) ([]Attachment, error) {
+	var attachments []Attachment
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxAttachments*MaxAttachmentSize)
+		if err := r.ParseMultipartForm(maxUploadFormMemory); err != nil {
+			return nil, fmt.Errorf("invalid upload: %w", err)
+		}
+
+		payload := r.FormValue("request")
+		if payload == "" {
+			fields := map[string]string{}
+			for _, key := range []string{"message", "provider", "system", "session_id"} {
+				if value := r.FormValue(key); value != "" {
+					fields[key] = value
+				}
+			}
+			data, _ := json.Marshal(fields)
+			payload = string(data)
+		}
+		if err := json.Unmarshal([]byte(payload), body); err != nil {
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+
+		for _, header := range r.MultipartForm.File["image"] {
+			file, err := header.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(io.LimitReader(file, MaxAttachmentSize+1))
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			attachment, err := newAttachment(header.Filename, data)
+			if err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, attachment)
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	for i, image := range opts.Images {
+		attachment, err := attachmentFromDataURL(fmt.Sprintf("image-%d", i+1), image)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if len(attachments) > MaxAttachments {
+		return nil, fmt.Errorf("at most %d images per message", MaxAttachments)
+	}
+	return attachments, nil
+}
+
+// loadImageFlags removes --image from os.Args, it can be given more than once
+func loadImageFlags() {
+	var rest []string
+	args := os.Args
+	for i := 0; i < len(args); i++ {
+		path, isImage := "", false
+		if value, ok := strings.CutPrefix(args[i], "--image="); ok {
+			path, isImage = value, true
+		} else if args[i] == "--image" {
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "❌ --image needs a file path")
+				os.Exit(1)
+			}
+			path, isImage = args[i+1], true
+			i++
+		}
+		if !isImage {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		attachment, err := loadAttachmentFile(expandHome(path))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		cliImages = append(cliImages, attachment)
+	}
+	os.Args = rest
+
+	if len(cliImages) > MaxAttachments {
+		fmt.Fprintf(os.Stderr, "❌ At most %d images per message\n", MaxAttachments)
+		os.Exit(1)
+	}
+}
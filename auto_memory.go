@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 )
 
@@ -34,8 +33,6 @@ func (e *AutoMemoryExtractor) ExtractFromConversation(ctx context.Context, conve
 		return nil, fmt.Errorf("memory manager not initialized")
 	}
 
-	fmt.Fprintf(os.Stderr, "[DEBUG] Calling AI for extraction...\n")
-
 	prompt := fmt.Sprintf(`Extract important facts, preferences, and information from this conversation that should be remembered long-term. 
 
 Rules:
@@ -68,8 +65,6 @@ Extracted memories:`, conversation)
 		return nil, fmt.Errorf("no API key configured")
 	}
 
-	fmt.Fprintf(os.Stderr, "[DEBUG] Using provider: %s\n", provider.Name)
-
 	req := Request{
 		Model: provider.Model,
 		Messages: []Message{
@@ -78,20 +73,16 @@ Extracted memories:`, conversation)
 		Stream: false,
 	}
 
-	fmt.Fprintf(os.Stderr, "[DEBUG] Sending request to %s...\n", provider.Endpoint)
-	response, err := makeRequest(provider.Endpoint, provider.APIKey, req, provider.Name)
+	response, err := makeRequest(ctx, provider.Name, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract memories: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "[DEBUG] Response received, checking choices...\n")
 	if len(response.Choices) == 0 {
 		return nil, fmt.Errorf("no response from AI")
 	}
 
 	content := response.Choices[0].Message.Content
-	fmt.Fprintf(os.Stderr, "[DEBUG] AI response content length: %d\n", len(content))
-	fmt.Fprintf(os.Stderr, "[DEBUG] AI response: %s\n", content)
 
 	lines := strings.Split(content, "\n")
 	var memories []string
@@ -106,7 +97,6 @@ Extracted memories:`, conversation)
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "[DEBUG] Parsed %d memories\n", len(memories))
 	return memories, nil
 }
 
@@ -142,17 +132,13 @@ func (e *AutoMemoryExtractor) SaveExtractedMemories(ctx context.Context, memorie
 }
 
 func (e *AutoMemoryExtractor) ProcessConversation(ctx context.Context, conversation string, sessionID string) (int, error) {
-	fmt.Fprintf(os.Stderr, "[DEBUG] Starting extraction...\n")
 	memories, err := e.ExtractFromConversation(ctx, conversation, sessionID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[DEBUG] ExtractFromConversation failed: %v\n", err)
 		return 0, err
 	}
-	fmt.Fprintf(os.Stderr, "[DEBUG] Got %d memories from AI\n", len(memories))
 
 	count, saveErr := e.SaveExtractedMemories(ctx, memories, sessionID)
 	if saveErr != nil {
-		fmt.Fprintf(os.Stderr, "[DEBUG] SaveExtractedMemories failed: %v\n", saveErr)
 		return count, saveErr
 	}
 
@@ -191,19 +177,17 @@ func InitAutoMemoryExtractor() {
 }
 
 func ExtractAndSaveMemories(conversation string, sessionID string) int {
-	ctx := context.Background()
+	ctx, stop := interruptibleContext()
+	defer stop()
 	extractor := GetAutoMemoryExtractor()
 	if extractor == nil {
-		fmt.Fprintf(os.Stderr, "[DEBUG] No extractor available\n")
 		return 0
 	}
 
 	count, err := extractor.ProcessConversation(ctx, conversation, sessionID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Extraction failed: %v\n", err)
 		return 0
 	}
 
-	fmt.Fprintf(os.Stderr, "[DEBUG] Processed %d memories\n", count)
 	return count
 }
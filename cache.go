@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCacheTTLSeconds = 24 * 60 * 60
+	DefaultCacheMaxSizeMB  = 50
+)
+
+// CacheConfig lives in providers.json. The cache is off unless enabled there
+// or with --cache.
+type CacheConfig struct {
+	Enabled    bool `json:"enabled"`
+	TTLSeconds int  `json:"ttl_seconds,omitempty"`
+	MaxSizeMB  int  `json:"max_size_mb,omitempty"`
+}
+
+// CacheEntry is one cached answer, stored as cache/<key>.json. The file's
+// modification time is its last use; hits are appended to cache/hits.log so
+// a hit does not rewrite the entry.
+type CacheEntry struct {
+	Key       string  `json:"key"`
+	Provider  string  `json:"provider"`
+	Model     string  `json:"model"`
+	Content   string  `json:"content"`
+	Cost      float64 `json:"cost,omitempty"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// cacheOverride is set by --cache / --no-cache
+var cacheOverride *bool
+var cacheMu sync.Mutex
+
+// cacheIndex tracks the size and last use of every entry so a store does not
+// read the whole cache. Other processes share the directory, so the index is
+// only an estimate; it is rebuilt from disk whenever it goes over the cap.
+var (
+	cacheIndex    map[string]cacheIndexEntry
+	cacheIndexDir string
+	cacheSize     int64
+)
+
+type cacheIndexEntry struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// Compact hits.log once it grows past this
+const cacheHitsLogLimit = 256 * 1024
+
+type cacheBypassKey struct{}
+
+// withoutCache marks a request context so the cache is neither read nor written
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheConfig() CacheConfig {
+	cfg := CacheConfig{TTLSeconds: DefaultCacheTTLSeconds, MaxSizeMB: DefaultCacheMaxSizeMB}
+	if custom := providerConfig.Cache; custom != nil {
+		cfg.Enabled = custom.Enabled
+		if custom.TTLSeconds > 0 {
+			cfg.TTLSeconds = custom.TTLSeconds
+		}
+		if custom.MaxSizeMB > 0 {
+			cfg.MaxSizeMB = custom.MaxSizeMB
+		}
+	}
+	if cacheOverride != nil {
+		cfg.Enabled = *cacheOverride
+	}
+	return cfg
+}
+
+func cacheEnabled(ctx context.Context) bool {
+	if bypass, _ := ctx.Value(cacheBypassKey{}).(bool); bypass {
+		return false
+	}
+	return cacheConfig().Enabled
+}
+
+func getCacheDir() string {
+	return filepath.Join(getDataDir(), "cache")
+}
+
+// cacheKey hashes everything that changes the answer. The stream flag is
+// left out so streaming and non-streaming calls share entries.
+func cacheKey(provider, model string, req Request) string {
+	data, _ := json.Marshal(struct {
+		Provider   string           `json:"provider"`
+		Model      string           `json:"model"`
+		Messages   []Message        `json:"messages"`
+		Params     GenerationParams `json:"params"`
+		Tools      []Tool           `json:"tools,omitempty"`
+		ToolChoice string           `json:"tool_choice,omitempty"`
+		Format     *ResponseFormat  `json:"response_format,omitempty"`
+	}{provider, model, req.Messages, req.GenerationParams, req.Tools, req.ToolChoice, req.ResponseFormat})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheModel resolves the model a request will use on a provider
+func cacheModel(provider string, req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return providers[provider].Model
+}
+
+// lookupCache returns a fresh entry for the request, or nil. req must
+// already carry the provider's default parameters.
+func lookupCache(ctx context.Context, provider string, req Request) *CacheEntry {
+	if !cacheEnabled(ctx) {
+		return nil
+	}
+	key := cacheKey(provider, cacheModel(provider, req), req)
+	path := filepath.Join(getCacheDir(), key+".json")
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		os.Remove(path)
+		return nil
+	}
+
+	created, err := time.Parse(time.RFC3339, entry.CreatedAt)
+	if err != nil || time.Since(created) > time.Duration(cacheConfig().TTLSeconds)*time.Second {
+		os.Remove(path)
+		if cacheIndex != nil {
+			cacheSize -= cacheIndex[key].size
+			delete(cacheIndex, key)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	if indexed, exists := cacheIndex[key]; exists {
+		indexed.lastUsed = now
+		cacheIndex[key] = indexed
+	}
+	recordCacheHit(key)
+
+	fmt.Fprintf(statusOut, "💾 Cached response from %s (%s old)\n", entry.Provider, time.Since(created).Round(time.Second))
+	return &entry
+}
+
+// storeCache saves a complete answer and evicts the least recently used
+// entries once the cache is over its size cap. Tool calls are never cached,
+// the tools have to run again.
+func storeCache(ctx context.Context, provider string, req Request, response *Response) {
+	if !cacheEnabled(ctx) || response == nil || len(response.Choices) == 0 || len(toolCallsOf(response)) > 0 {
+		return
+	}
+	content := response.Choices[0].Message.Content
+	if content == "" {
+		return
+	}
+
+	model := cacheModel(provider, req)
+	entry := CacheEntry{
+		Key:       cacheKey(provider, model, req),
+		Provider:  provider,
+		Model:     model,
+		Content:   content,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if response.Usage != nil {
+		entry.Cost = response.Usage.Cost
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	os.MkdirAll(getCacheDir(), 0755)
+	if err := os.WriteFile(filepath.Join(getCacheDir(), entry.Key+".json"), data, 0644); err != nil {
+		return
+	}
+
+	loadCacheIndex()
+	cacheSize += int64(len(data)) - cacheIndex[entry.Key].size
+	cacheIndex[entry.Key] = cacheIndexEntry{size: int64(len(data)), lastUsed: time.Now()}
+	if cacheSize > int64(cacheConfig().MaxSizeMB)*1024*1024 {
+		pruneCache()
+	}
+}
+
+func (e *CacheEntry) response() *Response {
+	return &Response{
+		Choices: []Choice{{Message: Message{Role: "assistant", Content: e.Content}}},
+		Cached:  true,
+	}
+}
+
+// replay feeds the cached answer to onDelta in word-sized chunks, the way a
+// live stream would arrive.
+func (e *CacheEntry) replay(onDelta func(string)) {
+	if onDelta == nil {
+		return
+	}
+	rest := e.Content
+	for rest != "" {
+		end := strings.IndexAny(rest[1:], " \n")
+		if end < 0 {
+			onDelta(rest)
+			return
+		}
+		onDelta(rest[:end+1])
+		rest = rest[end+1:]
+	}
+}
+
+type cacheFile struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+	entry    CacheEntry
+}
+
+// loadCacheIndex builds the index from the directory listing the first time
+// it is needed. It must be called with cacheMu held.
+func loadCacheIndex() {
+	if cacheIndex != nil && cacheIndexDir == getCacheDir() {
+		return
+	}
+	cacheIndex = map[string]cacheIndexEntry{}
+	cacheIndexDir = getCacheDir()
+	cacheSize = 0
+
+	entries, err := os.ReadDir(cacheIndexDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		cacheIndex[strings.TrimSuffix(e.Name(), ".json")] = cacheIndexEntry{size: info.Size(), lastUsed: info.ModTime()}
+		cacheSize += info.Size()
+	}
+}
+
+// readCacheFiles must be called with cacheMu held
+func readCacheFiles() []cacheFile {
+	entries, err := os.ReadDir(getCacheDir())
+	if err != nil {
+		return nil
+	}
+
+	var files []cacheFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(getCacheDir(), e.Name())
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cacheFile{path: path, size: int64(len(data)), lastUsed: info.ModTime(), entry: entry})
+	}
+	return files
+}
+
+// pruneCache reads the whole cache, drops expired entries, then the least
+// recently used ones until the cache fits its size cap, and rebuilds the
+// index from what is left. It must be called with cacheMu held.
+func pruneCache() {
+	cfg := cacheConfig()
+	ttl := time.Duration(cfg.TTLSeconds) * time.Second
+	limit := int64(cfg.MaxSizeMB) * 1024 * 1024
+
+	var live []cacheFile
+	var total int64
+	for _, f := range readCacheFiles() {
+		created, err := time.Parse(time.RFC3339, f.entry.CreatedAt)
+		if err != nil || time.Since(created) > ttl {
+			os.Remove(f.path)
+			continue
+		}
+		live = append(live, f)
+		total += f.size
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].lastUsed.Before(live[j].lastUsed)
+	})
+	for len(live) > 0 && total > limit {
+		os.Remove(live[0].path)
+		total -= live[0].size
+		live = live[1:]
+	}
+
+	cacheIndex = map[string]cacheIndexEntry{}
+	cacheIndexDir = getCacheDir()
+	cacheSize = total
+	for _, f := range live {
+		cacheIndex[f.entry.Key] = cacheIndexEntry{size: f.size, lastUsed: f.lastUsed}
+	}
+	compactCacheHits()
+}
+
+func getCacheHitsPath() string {
+	return filepath.Join(getCacheDir(), "hits.log")
+}
+
+// recordCacheHit appends the key to hits.log. It must be called with cacheMu
+// held.
+func recordCacheHit(key string) {
+	f, err := os.OpenFile(getCacheHitsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, key)
+	info, err := f.Stat()
+	f.Close()
+	if err == nil && info.Size() > cacheHitsLogLimit {
+		compactCacheHits()
+	}
+}
+
+// cacheHits counts hits.log lines, which are either "<key>" for one hit or
+// "<key> <count>" after compaction
+func cacheHits() map[string]int {
+	hits := map[string]int{}
+	data, err := os.ReadFile(getCacheHitsPath())
+	if err != nil {
+		return hits
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			hits[fields[0]]++
+		case 2:
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				hits[fields[0]] += n
+			}
+		}
+	}
+	return hits
+}
+
+// compactCacheHits rewrites hits.log as one count per entry that still
+// exists. It must be called with cacheMu held.
+func compactCacheHits() {
+	var sb strings.Builder
+	for key, n := range cacheHits() {
+		if _, err := os.Stat(filepath.Join(getCacheDir(), key+".json")); err == nil {
+			fmt.Fprintf(&sb, "%s %d\n", key, n)
+		}
+	}
+	os.WriteFile(getCacheHitsPath(), []byte(sb.String()), 0644)
+}
+
+func clearCache() (int, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	files := readCacheFiles()
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil {
+			return 0, err
+		}
+	}
+	os.Remove(getCacheHitsPath())
+	cacheIndex = nil
+	return len(files), nil
+}
+
+func handleCacheCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: terminal-ai cache stats | cache clear")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "stats":
+		cacheMu.Lock()
+		pruneCache()
+		files := readCacheFiles()
+		entryHits := cacheHits()
+		cacheMu.Unlock()
+
+		cfg := cacheConfig()
+		var size int64
+		hits := 0
+		saved := 0.0
+		for _, f := range files {
+			size += f.size
+			hits += entryHits[f.entry.Key]
+			saved += float64(entryHits[f.entry.Key]) * f.entry.Cost
+		}
+
+		status := "disabled"
+		if cfg.Enabled {
+			status = "enabled"
+		}
+		fmt.Printf("💾 Response cache (%s)\n", status)
+		fmt.Printf("   Location: %s\n", getCacheDir())
+		fmt.Printf("   Entries: %d\n", len(files))
+		fmt.Printf("   Size: %.1f KB / %d MB\n", float64(size)/1024, cfg.MaxSizeMB)
+		fmt.Printf("   TTL: %s\n", time.Duration(cfg.TTLSeconds)*time.Second)
+		fmt.Printf("   Hits: %d\n", hits)
+		if saved > 0 {
+			fmt.Printf("   Saved: %s\n", formatCost(saved))
+		}
+	case "clear":
+		count, err := clearCache()
+		if err != nil {
+			fmt.Printf("❌ Failed to clear cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Removed %d cached responses\n", count)
+	default:
+		fmt.Println("Unknown cache command. Use: stats | clear")
+	}
+}
+
+// loadCacheFlags removes --cache and --no-cache from os.Args
+func loadCacheFlags() {
+	var rest []string
+	for _, arg := range os.Args {
+		switch arg {
+		case "--cache", "--no-cache":
+			enabled := arg == "--cache"
+			cacheOverride = &enabled
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	os.Args = rest
+}
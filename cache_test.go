@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCache enables the cache in a temp data dir
+func withCache(t *testing.T, cfg CacheConfig) {
+	t.Helper()
+	isolateDataDir(t)
+	cfg.Enabled = true
+	swapGlobal(t, &providerConfig, ProviderGlobalConfig{Cache: &cfg})
+	swapGlobal(t, &cacheIndex, nil)
+}
+
+func cacheRequest(prompt string) Request {
+	return Request{Model: "m", Messages: []Message{{Role: "user", Content: prompt}}}
+}
+
+func answer(content string) *Response {
+	return &Response{Choices: []Choice{{Message: Message{Role: "assistant", Content: content}}}, Usage: &Usage{Cost: 0.25}}
+}
+
+// touchEntry sets the last use of a stored entry
+func touchEntry(t *testing.T, req Request, at time.Time) {
+	t.Helper()
+	path := filepath.Join(getCacheDir(), cacheKey("p", "m", req)+".json")
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+	cacheIndex = nil
+}
+
+func TestCacheStoreAndLookup(t *testing.T) {
+	withCache(t, CacheConfig{TTLSeconds: 60, MaxSizeMB: 1})
+	ctx := context.Background()
+
+	if entry := lookupCache(ctx, "p", cacheRequest("hi")); entry != nil {
+		t.Fatal("empty cache returned an entry")
+	}
+	storeCache(ctx, "p", cacheRequest("hi"), answer("Hello"))
+
+	entry := lookupCache(ctx, "p", cacheRequest("hi"))
+	if entry == nil || entry.Content != "Hello" || !entry.response().Cached {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if lookupCache(ctx, "p", cacheRequest("other")) != nil || lookupCache(ctx, "q", cacheRequest("hi")) != nil {
+		t.Error("a different prompt or provider hit the cache")
+	}
+	if lookupCache(withoutCache(ctx), "p", cacheRequest("hi")) != nil {
+		t.Error("withoutCache still read the cache")
+	}
+
+	lookupCache(ctx, "p", cacheRequest("hi"))
+	if hits := cacheHits()[cacheKey("p", "m", cacheRequest("hi"))]; hits != 2 {
+		t.Errorf("hits = %d, want 2", hits)
+	}
+}
+
+func TestCacheSkipsToolCallsAndEmptyAnswers(t *testing.T) {
+	withCache(t, CacheConfig{TTLSeconds: 60, MaxSizeMB: 1})
+	ctx := context.Background()
+
+	calls := answer("")
+	calls.Choices[0].Message.ToolCalls = []ToolCall{{ID: "1", Type: "function"}}
+	storeCache(ctx, "p", cacheRequest("tools"), calls)
+	storeCache(ctx, "p", cacheRequest("empty"), answer(""))
+
+	if entries, _ := os.ReadDir(getCacheDir()); len(entries) != 0 {
+		t.Errorf("cached %d entries", len(entries))
+	}
+}
+
+func TestCacheExpiredEntry(t *testing.T) {
+	withCache(t, CacheConfig{TTLSeconds: 60, MaxSizeMB: 1})
+	ctx := context.Background()
+	storeCache(ctx, "p", cacheRequest("hi"), answer("Hello"))
+
+	providerConfig.Cache.TTLSeconds = 1
+	path := filepath.Join(getCacheDir(), cacheKey("p", "m", cacheRequest("hi"))+".json")
+	data, _ := os.ReadFile(path)
+	old := strings.Replace(string(data), time.Now().Format(time.RFC3339), time.Now().Add(-time.Hour).Format(time.RFC3339), 1)
+	os.WriteFile(path, []byte(old), 0644)
+
+	if lookupCache(ctx, "p", cacheRequest("hi")) != nil {
+		t.Error("expired entry returned")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expired entry not removed")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	withCache(t, CacheConfig{TTLSeconds: 3600, MaxSizeMB: 1})
+	ctx := context.Background()
+	big := strings.Repeat("x", 400*1024)
+
+	storeCache(ctx, "p", cacheRequest("a"), answer(big))
+	storeCache(ctx, "p", cacheRequest("b"), answer(big))
+	now := time.Now()
+	touchEntry(t, cacheRequest("a"), now.Add(-time.Minute))
+	touchEntry(t, cacheRequest("b"), now.Add(-2*time.Minute))
+
+	// Over the cap: b was used longest ago
+	storeCache(ctx, "p", cacheRequest("c"), answer(big))
+
+	for prompt, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		path := filepath.Join(getCacheDir(), cacheKey("p", "m", cacheRequest(prompt))+".json")
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("entry %s kept = %v, want %v", prompt, err == nil, want)
+		}
+	}
+	if cacheSize > 1024*1024 || len(cacheIndex) != 2 {
+		t.Errorf("index after prune: size %d, %d entries", cacheSize, len(cacheIndex))
+	}
+}
+
+func TestCacheStoreDoesNotRescan(t *testing.T) {
+	withCache(t, CacheConfig{TTLSeconds: 3600, MaxSizeMB: 1})
+	ctx := context.Background()
+	storeCache(ctx, "p", cacheRequest("a"), answer("A"))
+
+	// A file the index does not know about is left alone until the cap is hit
+	stray := filepath.Join(getCacheDir(), "stray.json")
+	os.WriteFile(stray, []byte("not json"), 0644)
+	storeCache(ctx, "p", cacheRequest("b"), answer("B"))
+	if _, err := os.Stat(stray); err != nil {
+		t.Error("a store below the cap read the whole cache")
+	}
+
+	storeCache(ctx, "p", cacheRequest("c"), answer(strings.Repeat("x", 1100*1024)))
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Error("pruning over the cap should drop unreadable entries")
+	}
+}
+
+func TestCacheHitsCompaction(t *testing.T) {
+	withCache(t, CacheConfig{TTLSeconds: 3600, MaxSizeMB: 1})
+	ctx := context.Background()
+	storeCache(ctx, "p", cacheRequest("a"), answer("A"))
+	key := cacheKey("p", "m", cacheRequest("a"))
+
+	for i := 0; i < 3; i++ {
+		lookupCache(ctx, "p", cacheRequest("a"))
+	}
+	os.WriteFile(getCacheHitsPath(), append(mustRead(t, getCacheHitsPath()), "gone\ngone 4\n"...), 0644)
+
+	cacheMu.Lock()
+	compactCacheHits()
+	cacheMu.Unlock()
+
+	if got := strings.TrimSpace(string(mustRead(t, getCacheHitsPath()))); got != key+" 3" {
+		t.Errorf("compacted log = %q", got)
+	}
+	if n, err := clearCache(); err != nil || n != 1 {
+		t.Errorf("clearCache = %d, %v", n, err)
+	}
+	if len(cacheHits()) != 0 {
+		t.Error("clear kept the hits")
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCacheReplay(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"", nil},
+		{"Hello", []string{"Hello"}},
+		{"Hello big world", []string{"Hello", " big", " world"}},
+		{"line one\nline two", []string{"line", " one", "\nline", " two"}},
+		{" leading", []string{" leading"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		(&CacheEntry{Content: tt.content}).replay(func(s string) { got = append(got, s) })
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("replay(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+		if strings.Join(got, "") != tt.content {
+			t.Errorf("replay(%q) lost text", tt.content)
+		}
+	}
+
+	(&CacheEntry{Content: "no callback"}).replay(nil)
+}
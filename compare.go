@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CompareResult is the answer of one provider to a compared prompt
+type CompareResult struct {
+	Provider  string `json:"provider"`
+	Model     string `json:"model"`
+	Response  string `json:"response,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Usage     *Usage `json:"usage,omitempty"`
+}
+
+// compareProviders sends the same request to every provider at once, without
+// fallback, and returns the results in the order the providers were given.
+func compareProviders(ctx context.Context, providerNames []string, req Request) []CompareResult {
+	results := make([]CompareResult, len(providerNames))
+
+	var wg sync.WaitGroup
+	for i, name := range providerNames {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			result := CompareResult{Provider: name}
+			provider, exists := providers[name]
+			switch {
+			case !exists:
+				result.Error = "unknown provider"
+			case !providerReady(provider):
+				result.Error = "API key not configured"
+			}
+			if result.Error != "" {
+				results[i] = result
+				return
+			}
+
+			attempt := req
+			attempt.Model = provider.Model
+			if model := personaModel(name); model != "" {
+				attempt.Model = model
+			}
+			result.Model = attempt.Model
+
+			start := time.Now()
+			response, err := makeRequest(ctx, name, attempt)
+			result.LatencyMs = time.Since(start).Milliseconds()
+
+			switch {
+			case err != nil:
+				result.Error = err.Error()
+			case response.Error != nil:
+				result.Error = response.Error.Message
+			case len(response.Choices) == 0:
+				result.Error = "no response generated"
+			default:
+				result.Response = response.Choices[0].Message.Content
+				result.Usage = response.Usage
+			}
+			results[i] = result
+		}(i, name)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// saveCompareSession stores the prompt and every successful answer as one
+// session, each answer tagged with its provider.
+func saveCompareSession(user, message, system string, params GenerationParams, results []CompareResult) *ChatSession {
+	primary := ""
+	for _, result := range results {
+		if result.Error == "" {
+			primary = result.Provider
+			break
+		}
+	}
+
+	session := createSession(truncateTitle("Compare: "+message), primary, user)
+	session.System = system
+	saveSessionSettings(session)
+	updateSession(session.ID, "user", message)
+
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		appendSessionMessage(session.ID, ChatMessage{
+			Role:     "assistant",
+			Content:  result.Response,
+			Provider: result.Provider,
+			Params:   providerParams(result.Provider).merge(params).record(),
+		})
+	}
+	return session
+}
+
+// splitProviderList parses "a,b,c", dropping empty entries and duplicates
+func splitProviderList(value string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func handleCompareCommand() {
+	var providerNames []string
+	var words []string
+	save := false
+
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--providers" && i+1 < len(args):
+			providerNames = splitProviderList(args[i+1])
+			i++
+		case strings.HasPrefix(args[i], "--providers="):
+			providerNames = splitProviderList(strings.TrimPrefix(args[i], "--providers="))
+		case args[i] == "--save":
+			save = true
+		default:
+			words = append(words, args[i])
+		}
+	}
+
+	message := strings.Join(words, " ")
+	if message == "" {
+		fmt.Println("Usage: terminal-ai compare --providers openrouter,groq,gemini [--save] \"prompt\"")
+		os.Exit(1)
+	}
+
+	if len(providerNames) == 0 {
+		for _, name := range getOrderedProviders() {
+			if providerReady(providers[name]) {
+				providerNames = append(providerNames, name)
+			}
+		}
+	}
+	if len(providerNames) < 2 {
+		fmt.Println("❌ Need at least two providers to compare, use --providers a,b")
+		os.Exit(1)
+	}
+
+	ctx, stop := interruptibleContext()
+	defer stop()
+
+	req := Request{
+		Messages:         withSystemMessage(systemPrompt(), []Message{{Role: "user", Content: message}}),
+		GenerationParams: cliParams,
+	}
+
+	fmt.Printf("⚖️  Comparing %s...\n\n", strings.Join(providerNames, ", "))
+	results := compareProviders(ctx, providerNames, req)
+
+	fmt.Printf("%-16s %-36s %10s %8s %10s  %s\n", "PROVIDER", "MODEL", "LATENCY", "TOKENS", "COST", "STATUS")
+	for _, result := range results {
+		tokens, cost := "-", "-"
+		if result.Usage != nil {
+			tokens = fmt.Sprintf("%d", result.Usage.TotalTokens)
+			cost = formatCost(result.Usage.Cost)
+		}
+		status := "✅"
+		if result.Error != "" {
+			status = "❌ " + truncate(result.Error, 60)
+		}
+		fmt.Printf("%-16s %-36s %9dms %8s %10s  %s\n",
+			result.Provider, truncate(result.Model, 36), result.LatencyMs, tokens, cost, status)
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		fmt.Printf("\n━━━━━━━━━━ 🤖 %s (%s) ━━━━━━━━━━\n", result.Provider, result.Model)
+		fmt.Println(result.Response)
+	}
+
+	if save {
+		session := saveCompareSession("user", message, systemPrompt(), cliParams, results)
+		fmt.Printf("\n💾 Saved as session %s\n", session.ID)
+	}
+}
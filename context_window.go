@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultContextMaxTokens = 16000
+	DefaultContextReserve   = 1024
+	DefaultSummaryMaxTokens = 512
+	// MinContextBudget is the least prompt budget left when max_tokens takes
+	// up (nearly) all of a model's context window
+	MinContextBudget = 1024
+)
+
+// ContextConfig lives in providers.json under "context" and limits how much
+// of a session is sent with each request. Budgets overrides max_tokens by
+// "provider", "model" or "provider:model", the most specific key winning.
+// A model's context window, when known from `provider models`, always caps
+// the budget. Turns that no longer fit are folded into a running summary
+// unless summarize is false, in which case they are only left out.
+type ContextConfig struct {
+	MaxTokens        int            `json:"max_tokens,omitempty"`
+	ReserveTokens    int            `json:"reserve_tokens,omitempty"`
+	Summarize        *bool          `json:"summarize,omitempty"`
+	SummaryProvider  string         `json:"summary_provider,omitempty"`
+	SummaryModel     string         `json:"summary_model,omitempty"`
+	SummaryMaxTokens int            `json:"summary_max_tokens,omitempty"`
+	Budgets          map[string]int `json:"budgets,omitempty"`
+	// Injected skills, documents and memories, see ContextAssembler
+	InjectTokens   int     `json:"inject_tokens,omitempty"`
+	MinRAGScore    float64 `json:"min_rag_score,omitempty"`
+	MinMemoryScore float64 `json:"min_memory_score,omitempty"`
+	RAGResults     int     `json:"rag_results,omitempty"`
+	MemoryResults  int     `json:"memory_results,omitempty"`
+}
+
+func contextConfig() ContextConfig {
+	summarize := true
+	cfg := ContextConfig{
+		MaxTokens:        DefaultContextMaxTokens,
+		ReserveTokens:    DefaultContextReserve,
+		Summarize:        &summarize,
+		SummaryMaxTokens: DefaultSummaryMaxTokens,
+		InjectTokens:     DefaultInjectTokens,
+		MinRAGScore:      DefaultMinRAGScore,
+		MinMemoryScore:   DefaultMinMemoryScore,
+		RAGResults:       DefaultContextResults,
+		MemoryResults:    DefaultContextResults,
+	}
+	if custom := providerConfig.Context; custom != nil {
+		if custom.MaxTokens > 0 {
+			cfg.MaxTokens = custom.MaxTokens
+		}
+		if custom.ReserveTokens > 0 {
+			cfg.ReserveTokens = custom.ReserveTokens
+		}
+		if custom.Summarize != nil {
+			cfg.Summarize = custom.Summarize
+		}
+		if custom.SummaryMaxTokens > 0 {
+			cfg.SummaryMaxTokens = custom.SummaryMaxTokens
+		}
+		if custom.InjectTokens > 0 {
+			cfg.InjectTokens = custom.InjectTokens
+		}
+		if custom.MinRAGScore > 0 {
+			cfg.MinRAGScore = custom.MinRAGScore
+		}
+		if custom.MinMemoryScore > 0 {
+			cfg.MinMemoryScore = custom.MinMemoryScore
+		}
+		if custom.RAGResults > 0 {
+			cfg.RAGResults = custom.RAGResults
+		}
+		if custom.MemoryResults > 0 {
+			cfg.MemoryResults = custom.MemoryResults
+		}
+		cfg.SummaryProvider = custom.SummaryProvider
+		cfg.SummaryModel = custom.SummaryModel
+		cfg.Budgets = custom.Budgets
+	}
+	return cfg
+}
+
+// contextBudget is the number of prompt tokens a request to the model may
+// use. The reply's max_tokens, or reserve_tokens, is kept free of the
+// model's context window. When that leaves less than MinContextBudget the
+// budget is clamped to it and clamped is set, so the caller can warn that
+// the reply may be cut short or refused.
+func contextBudget(providerName, model string, params GenerationParams) (budget int, clamped bool) {
+	cfg := contextConfig()
+	budget = cfg.MaxTokens
+	for _, key := range []string{providerName, model, providerName + ":" + model} {
+		if b := cfg.Budgets[key]; b > 0 {
+			budget = b
+		}
+	}
+
+	reserve := cfg.ReserveTokens
+	if params.MaxTokens != nil {
+		reserve = *params.MaxTokens
+	}
+	window := cachedContextLength(providerName, model)
+	if window <= 0 || window-reserve >= budget {
+		return budget, false
+	}
+	floor := MinContextBudget
+	if floor > window/2 {
+		floor = window / 2
+	}
+	if floor > budget {
+		floor = budget
+	}
+	if window-reserve < floor {
+		return floor, true
+	}
+	return window - reserve, false
+}
+
+// ContextPlan is what a request carries from a session: the system prompt
+// with the running summary, then the newest turns that fit the budget.
+type ContextPlan struct {
+	Provider      string
+	Model         string
+	Tokenizer     string
+	Budget        int
+	Messages      []Message
+	Tokens        int
+	SystemTokens  int
+	SummaryTokens int
+	// InjectedTokens are the skills, documents and memories of the turn
+	InjectedTokens int
+	Sent           int
+	// Summarized messages are covered by the session summary, Omitted ones
+	// are over budget and not summarized yet
+	Summarized int
+	Omitted    int
+	// NewlySummarized and SummaryErr report what buildContext did
+	NewlySummarized int
+	SummaryErr      error
+	// BudgetClamped is set when max_tokens left too little of the model's
+	// context window and Budget was raised to MinContextBudget
+	BudgetClamped bool
+
+	keepFrom int // index in session.Messages of the first message sent
+}
+
+// contextSystemPrompt appends the running summary to the system prompt
+func contextSystemPrompt(system, summary string) string {
+	if summary == "" {
+		return system
+	}
+	block := "Summary of the earlier conversation:\n" + summary
+	if strings.TrimSpace(system) == "" {
+		return block
+	}
+	return system + "\n\n" + block
+}
+
+// planContext picks the messages to send without changing the session. The
+// injected context goes right before the newest message and counts against
+// the budget.
+func planContext(session *ChatSession, providerName, model string, params GenerationParams, injected AssembledContext) ContextPlan {
+	plan := ContextPlan{
+		Provider:  providerName,
+		Model:     model,
+		Tokenizer: tokenizerFor(model).Name,
+	}
+	plan.Budget, plan.BudgetClamped = contextBudget(providerName, model, params)
+
+	start := session.SummarizedCount
+	if start > len(session.Messages) {
+		start = len(session.Messages)
+	}
+	summary := ""
+	if start > 0 {
+		summary = session.Summary
+		plan.Summarized = start
+	}
+
+	var turns []Message
+	var index []int
+	for i := start; i < len(session.Messages); i++ {
+		msg := session.Messages[i]
+		if msg.Role == "user" || msg.Role == "assistant" {
+			turns = append(turns, Message{Role: msg.Role, Content: msg.Content, Attachments: msg.Attachments})
+			index = append(index, i)
+		}
+	}
+	system := contextSystemPrompt(session.System, summary)
+	if system != "" {
+		plan.SystemTokens = estimateMessageTokens(model, Message{Role: "system", Content: system})
+	}
+	if summary != "" {
+		plan.SummaryTokens = estimateTokens(model, summary)
+	}
+
+	// Newest first; the last message is sent even when it alone is over
+	plan.InjectedTokens = injected.Tokens
+	plan.Tokens = plan.SystemTokens + plan.InjectedTokens
+	first := len(turns)
+	for first > 0 {
+		tokens := estimateMessageTokens(model, turns[first-1])
+		if first < len(turns) && plan.Tokens+tokens > plan.Budget {
+			break
+		}
+		plan.Tokens += tokens
+		first--
+	}
+	// Start on a user turn, some providers reject history that does not
+	for first < len(turns)-1 && turns[first].Role != "user" {
+		plan.Tokens -= estimateMessageTokens(model, turns[first])
+		first++
+	}
+
+	plan.Omitted = first
+	plan.Sent = len(turns) - first
+	plan.keepFrom = len(session.Messages)
+	if first < len(index) {
+		plan.keepFrom = index[first]
+	}
+	plan.Messages = withSystemMessage(system, injected.inject(turns[first:]))
+	return plan
+}
+
+// buildContext plans the request and, when older turns are over budget,
+// folds them into the session's running summary first. If summarizing
+// fails the turns are only left out and the plan says why.
+func buildContext(ctx context.Context, session *ChatSession, providerName, model string, params GenerationParams, injected AssembledContext) ContextPlan {
+	plan := planContext(session, providerName, model, params, injected)
+	if plan.Omitted == 0 || !*contextConfig().Summarize {
+		return plan
+	}
+
+	start := plan.Summarized
+	summary, err := summarizeMessages(ctx, providerName, model, session.Summary, session.Messages[start:plan.keepFrom])
+	if err != nil {
+		plan.SummaryErr = err
+		return plan
+	}
+	if err := saveSessionSummary(session.ID, summary, plan.keepFrom); err != nil {
+		plan.SummaryErr = err
+		return plan
+	}
+	session.Summary = summary
+	session.SummarizedCount = plan.keepFrom
+
+	folded := plan.Omitted
+	plan = planContext(session, providerName, model, params, injected)
+	plan.NewlySummarized = folded
+	return plan
+}
+
+const summaryInstruction = `You maintain a running summary of a conversation so it can continue after older messages are dropped. Merge the summary so far with the new messages. Keep facts, decisions, names, numbers, code identifiers, user preferences and open questions; drop greetings and filler. Write in the language of the conversation, in at most %d words, as plain notes without preamble.`
+
+// summarizeMessages asks the model, or summary_provider/summary_model, to
+// fold messages into the previous summary
+func summarizeMessages(ctx context.Context, providerName, model, previous string, messages []ChatMessage) (string, error) {
+	cfg := contextConfig()
+	if cfg.SummaryProvider != "" {
+		providerName = cfg.SummaryProvider
+		model = providers[providerName].Model
+	}
+	if cfg.SummaryModel != "" {
+		model = cfg.SummaryModel
+	}
+
+	var sb strings.Builder
+	if previous != "" {
+		sb.WriteString("Summary so far:\n" + previous + "\n\n")
+	}
+	sb.WriteString("New messages:\n")
+	for _, msg := range messages {
+		fmt.Fprintf(&sb, "%s: %s\n", msg.Role, msg.Content)
+		if len(msg.Attachments) > 0 {
+			fmt.Fprintf(&sb, "(%d image(s) attached)\n", len(msg.Attachments))
+		}
+	}
+
+	maxTokens := cfg.SummaryMaxTokens
+	req := Request{
+		Model: model,
+		Messages: []Message{
+			{Role: "system", Content: fmt.Sprintf(summaryInstruction, maxTokens*3/4)},
+			{Role: "user", Content: sb.String()},
+		},
+		GenerationParams: GenerationParams{MaxTokens: &maxTokens},
+	}
+	response, _, err := makeRequestWithFallback(ctx, req, providerName)
+	if err != nil {
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+	if response.Error != nil {
+		return "", fmt.Errorf("summary request failed: %s", response.Error.Message)
+	}
+	if len(response.Choices) == 0 || strings.TrimSpace(response.Choices[0].Message.Content) == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
+
+// printContextNotes tells the CLI user when history was summarized or cut
+func printContextNotes(plan ContextPlan) {
+	if plan.BudgetClamped {
+		fmt.Fprintf(statusOut, "⚠️  max_tokens leaves almost none of %s's context window for the conversation; sending ~%d tokens anyway, lower max_tokens if the reply is cut short\n", plan.Model, plan.Budget)
+	}
+	if plan.NewlySummarized > 0 {
+		fmt.Fprintf(statusOut, "🗜️  Summarized %d older messages to stay within ~%d tokens\n", plan.NewlySummarized, plan.Budget)
+	}
+	if plan.SummaryErr != nil {
+		fmt.Fprintf(statusOut, "⚠️  Could not summarize older messages: %v\n", plan.SummaryErr)
+	}
+	if plan.Omitted > 0 {
+		fmt.Fprintf(statusOut, "✂️  %d older messages left out to stay within ~%d tokens\n", plan.Omitted, plan.Budget)
+	}
+	if plan.Tokens > plan.Budget {
+		fmt.Fprintf(statusOut, "⚠️  The message alone is ~%d tokens, over the ~%d token budget\n", plan.Tokens, plan.Budget)
+	}
+}
+
+func printContextPlan(plan ContextPlan) {
+	fmt.Printf("🔢 Context for %s (%s, %s tokenizer)\n", plan.Provider, plan.Model, plan.Tokenizer)
+	fmt.Printf("   Budget:   ~%d tokens\n", plan.Budget)
+	if plan.BudgetClamped {
+		fmt.Println("   ⚠️  max_tokens leaves almost none of the context window, budget raised to the minimum")
+	}
+	fmt.Printf("   System:   ~%d tokens\n", plan.SystemTokens-plan.SummaryTokens)
+	if plan.Summarized > 0 {
+		fmt.Printf("   Summary:  ~%d tokens covering %d earlier messages\n", plan.SummaryTokens, plan.Summarized)
+	}
+	if plan.InjectedTokens > 0 {
+		fmt.Printf("   Injected: ~%d tokens of skills, documents and memories\n", plan.InjectedTokens)
+	}
+	fmt.Printf("   Messages: %d (~%d tokens)\n", plan.Sent, plan.Tokens-plan.SystemTokens-plan.InjectedTokens)
+	if plan.Omitted > 0 {
+		action := "summarized"
+		if !*contextConfig().Summarize {
+			action = "left out"
+		}
+		fmt.Printf("   Over budget: %d older messages, %s on the next turn\n", plan.Omitted, action)
+	}
+	fmt.Printf("   Total:    ~%d tokens to send\n", plan.Tokens)
+}
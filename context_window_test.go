@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withContextConfig sets the context settings and a cached model list with
+// the given context window for provider "p"
+func withContextConfig(t *testing.T, cfg *ContextConfig, window int) {
+	t.Helper()
+	isolateDataDir(t)
+	swapGlobal(t, &providerConfig, ProviderGlobalConfig{Context: cfg})
+
+	if window > 0 {
+		data, _ := json.Marshal(ModelList{Provider: "p", Models: []ModelInfo{{ID: "gpt-test", ContextLength: window}}})
+		os.MkdirAll(getModelCacheDir(), 0755)
+		if err := os.WriteFile(modelCachePath("p"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func maxTokens(n int) GenerationParams {
+	return GenerationParams{MaxTokens: &n}
+}
+
+func TestContextBudget(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         *ContextConfig
+		window      int
+		params      GenerationParams
+		want        int
+		wantClamped bool
+	}{
+		{"default", nil, 0, GenerationParams{}, DefaultContextMaxTokens, false},
+		{"budget by model", &ContextConfig{Budgets: map[string]int{"p": 3000, "gpt-test": 2000}}, 0, GenerationParams{}, 2000, false},
+		{"provider:model wins", &ContextConfig{Budgets: map[string]int{"gpt-test": 2000, "p:gpt-test": 2500}}, 0, GenerationParams{}, 2500, false},
+		{"window caps", nil, 8192, GenerationParams{}, 8192 - DefaultContextReserve, false},
+		{"max_tokens reserved", nil, 8192, maxTokens(4096), 4096, false},
+		{"max_tokens fills window", nil, 8192, maxTokens(8192), MinContextBudget, true},
+		{"max_tokens over window", nil, 8192, maxTokens(100000), MinContextBudget, true},
+		{"tiny window", nil, 1000, maxTokens(1000), 500, true},
+		{"small budget kept", &ContextConfig{MaxTokens: 300}, 8192, maxTokens(8192), 300, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withContextConfig(t, tt.cfg, tt.window)
+			got, clamped := contextBudget("p", "gpt-test", tt.params)
+			if got != tt.want || clamped != tt.wantClamped {
+				t.Errorf("contextBudget = %d, %v, want %d, %v", got, clamped, tt.want, tt.wantClamped)
+			}
+		})
+	}
+}
+
+func chatTurns(n int, words int) []ChatMessage {
+	var messages []ChatMessage
+	for i := 0; i < n; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages = append(messages, ChatMessage{Role: role, Content: strings.TrimSpace(strings.Repeat("word ", words))})
+	}
+	return messages
+}
+
+func TestPlanContext(t *testing.T) {
+	withContextConfig(t, &ContextConfig{MaxTokens: 100}, 0)
+
+	// Each turn is 4 framing + 20 word tokens
+	session := &ChatSession{System: "Be brief.", Messages: chatTurns(8, 20)}
+	plan := planContext(session, "p", "gpt-test", GenerationParams{}, AssembledContext{})
+
+	if plan.Budget != 100 || plan.Tokenizer != "gpt" {
+		t.Errorf("plan = %+v", plan)
+	}
+	// System is 4 + 4 tokens, so three turns fit, trimmed to start on a user turn
+	if plan.Sent != 2 || plan.Omitted != 6 || plan.Tokens != 8+48 {
+		t.Errorf("sent %d, omitted %d, tokens %d", plan.Sent, plan.Omitted, plan.Tokens)
+	}
+	if len(plan.Messages) != 3 || plan.Messages[0].Role != "system" || plan.Messages[1].Role != "user" {
+		t.Errorf("messages = %+v", plan.Messages)
+	}
+	if plan.keepFrom != 6 {
+		t.Errorf("keepFrom = %d", plan.keepFrom)
+	}
+}
+
+func TestPlanContextSummaryAndInjected(t *testing.T) {
+	withContextConfig(t, &ContextConfig{MaxTokens: 1000}, 0)
+
+	session := &ChatSession{Summary: "Earlier talk", SummarizedCount: 4, Messages: chatTurns(6, 5)}
+	injected := AssembledContext{Content: "Memory: likes Go", Tokens: 10}
+	plan := planContext(session, "p", "gpt-test", GenerationParams{}, injected)
+
+	if plan.Summarized != 4 || plan.Sent != 2 || plan.Omitted != 0 || plan.InjectedTokens != 10 {
+		t.Errorf("plan = %+v", plan)
+	}
+	if !strings.Contains(plan.Messages[0].Content, "Summary of the earlier conversation:\nEarlier talk") {
+		t.Errorf("system = %q", plan.Messages[0].Content)
+	}
+	// The injected context goes right before the newest message
+	if n := len(plan.Messages); n != 4 || plan.Messages[n-2].Content != injected.Content {
+		t.Errorf("messages = %+v", plan.Messages)
+	}
+}
+
+func TestPlanContextSendsLastMessageOverBudget(t *testing.T) {
+	withContextConfig(t, &ContextConfig{MaxTokens: 10}, 0)
+
+	session := &ChatSession{Messages: chatTurns(3, 50)}
+	plan := planContext(session, "p", "gpt-test", GenerationParams{}, AssembledContext{})
+	if plan.Sent != 1 || plan.Omitted != 2 || plan.Tokens <= plan.Budget {
+		t.Errorf("plan = %+v", plan)
+	}
+}
+
+func TestPlanContextClampedBudget(t *testing.T) {
+	withContextConfig(t, nil, 8192)
+
+	plan := planContext(&ChatSession{Messages: chatTurns(1, 5)}, "p", "gpt-test", maxTokens(8192), AssembledContext{})
+	if !plan.BudgetClamped || plan.Budget != MinContextBudget || plan.Sent != 1 {
+		t.Errorf("plan = %+v", plan)
+	}
+}
@@ -46,9 +46,22 @@ func (em *EncryptedMemoryManager) SearchAndDecrypt(ctx context.Context, query st
 	if err != nil {
 		return nil, err
 	}
+	return decryptResults(results), nil
+}
 
+// SearchUserAndDecrypt searches only the memories of user, "" being the
+// command line owner
+func (em *EncryptedMemoryManager) SearchUserAndDecrypt(ctx context.Context, query, user string, topK int) ([]MemorySearchResult, error) {
+	results, err := em.base.SearchUserMemories(ctx, query, user, topK)
+	if err != nil {
+		return nil, err
+	}
+	return decryptResults(results), nil
+}
+
+func decryptResults(results []MemorySearchResult) []MemorySearchResult {
 	if securityMgr == nil {
-		return results, nil
+		return results
 	}
 
 	for i := range results {
@@ -62,7 +75,7 @@ func (em *EncryptedMemoryManager) SearchAndDecrypt(ctx context.Context, query st
 		}
 	}
 
-	return results, nil
+	return results
 }
 
 func (em *EncryptedMemoryManager) GetAndDecrypt(ctx context.Context, id string) (*Memory, error) {
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GeminiProvider speaks the native generateContent API. Endpoints pointing at
+// Gemini's OpenAI compatibility layer (".../openai/...") keep using the
+// OpenAI-compatible client instead.
+type GeminiProvider struct {
+	cfg AIProvider
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string `json:"name"`
+	Response struct {
+		Content string `json:"content"`
+	} `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []ToolFunction `json:"functionDeclarations"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode string `json:"mode"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	MaxOutputTokens  *int     `json:"maxOutputTokens,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func newGeminiProvider(cfg AIProvider) Provider {
+	if strings.Contains(cfg.Endpoint, "/openai/") {
+		return newGeminiCompatProvider(cfg)
+	}
+	return &GeminiProvider{cfg: cfg}
+}
+
+func (p *GeminiProvider) Name() string {
+	return p.cfg.Name
+}
+
+// baseURL strips "/models/<model>:<method>" from the configured endpoint,
+// leaving e.g. "https://generativelanguage.googleapis.com/v1beta".
+func (p *GeminiProvider) baseURL() string {
+	endpoint := strings.TrimRight(p.cfg.Endpoint, "/")
+	if endpoint == "" {
+		return "https://generativelanguage.googleapis.com/v1beta"
+	}
+	if idx := strings.Index(endpoint, "/models"); idx >= 0 {
+		return endpoint[:idx]
+	}
+	return endpoint
+}
+
+// model returns the requested model, the configured default, or the model
+// embedded in the endpoint URL, in that order.
+func (p *GeminiProvider) model(requested string) string {
+	model := requested
+	if model == "" {
+		model = p.cfg.Model
+	}
+	if model == "" {
+		if idx := strings.Index(p.cfg.Endpoint, "/models/"); idx >= 0 {
+			model = p.cfg.Endpoint[idx+len("/models/"):]
+			if colon := strings.Index(model, ":"); colon >= 0 {
+				model = model[:colon]
+			}
+		}
+	}
+	return strings.TrimPrefix(model, "models/")
+}
+
+func (p *GeminiProvider) methodURL(model, method string) string {
+	return fmt.Sprintf("%s/models/%s:%s", p.baseURL(), model, method)
+}
+
+// toGeminiRequest converts chat messages into contents/parts. System messages
+// become the systemInstruction and assistant turns use Gemini's "model" role.
+// Tool calls and results become functionCall and functionResponse parts.
+func toGeminiRequest(in Request) geminiRequest {
+	var req geminiRequest
+	var system []string
+
+	if params := in.GenerationParams; !params.isZero() {
+		req.GenerationConfig = &geminiGenerationConfig{
+			Temperature:     params.Temperature,
+			MaxOutputTokens: params.MaxTokens,
+			TopP:            params.TopP,
+			StopSequences:   params.Stop,
+			Seed:            params.Seed,
+		}
+	}
+
+	for _, msg := range in.Messages {
+		parts := []geminiPart{{Text: msg.Content}}
+		switch msg.Role {
+		case "system":
+			system = append(system, msg.Content)
+			continue
+		case "assistant":
+			msg.Role = "model"
+			if msg.Content == "" {
+				parts = nil
+			}
+			for _, call := range msg.ToolCalls {
+				args := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Function.Name, Args: args}})
+			}
+			// an empty reply, e.g. an interrupted stream, has nothing to send
+			if len(parts) == 0 {
+				continue
+			}
+		case "tool":
+			msg.Role = "user"
+			result := &geminiFunctionResponse{Name: msg.Name}
+			result.Response.Content = msg.Content
+			parts = []geminiPart{{FunctionResponse: result}}
+		default:
+			msg.Role = "user"
+			for _, img := range msg.images() {
+				parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: img.MimeType, Data: img.Data}})
+			}
+			if msg.Content == "" && len(parts) > 1 {
+				parts = parts[1:]
+			}
+		}
+
+		// Gemini expects alternating turns, merge consecutive ones
+		if n := len(req.Contents); n > 0 && req.Contents[n-1].Role == msg.Role {
+			req.Contents[n-1].Parts = append(req.Contents[n-1].Parts, parts...)
+			continue
+		}
+		req.Contents = append(req.Contents, geminiContent{
+			Role:  msg.Role,
+			Parts: parts,
+		})
+	}
+
+	if len(in.Tools) > 0 {
+		var declarations []ToolFunction
+		for _, tool := range in.Tools {
+			declarations = append(declarations, tool.Function)
+		}
+		req.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+		if in.ToolChoice == "none" {
+			req.ToolConfig = &geminiToolConfig{}
+			req.ToolConfig.FunctionCallingConfig.Mode = "NONE"
+		}
+	}
+
+	// Gemini rejects a JSON mime type together with function calling, the
+	// instruction alone has to do then
+	if in.ResponseFormat != nil {
+		system = append(system, in.ResponseFormat.instruction())
+		if len(in.Tools) == 0 {
+			if req.GenerationConfig == nil {
+				req.GenerationConfig = &geminiGenerationConfig{}
+			}
+			req.GenerationConfig.ResponseMimeType = "application/json"
+		}
+	}
+
+	if len(system) > 0 {
+		req.SystemInstruction = &geminiContent{
+			Parts: []geminiPart{{Text: strings.Join(system, "\n\n")}},
+		}
+	}
+
+	return req
+}
+
+// text joins the parts of the first candidate
+func (r *geminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+// toolCalls returns the functionCall parts of the first candidate. Gemini
+// has no call ids, runAgent assigns them.
+func (r *geminiResponse) toolCalls() []ToolCall {
+	if len(r.Candidates) == 0 {
+		return nil
+	}
+	var calls []ToolCall
+	for _, part := range r.Candidates[0].Content.Parts {
+		if part.FunctionCall == nil {
+			continue
+		}
+		args := string(part.FunctionCall.Args)
+		if args == "" {
+			args = "{}"
+		}
+		calls = append(calls, ToolCall{
+			Type:     "function",
+			Function: ToolCallFunction{Name: part.FunctionCall.Name, Arguments: args},
+		})
+	}
+	return calls
+}
+
+func (r *geminiResponse) usage() *Usage {
+	if r.UsageMetadata == nil {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:     r.UsageMetadata.PromptTokenCount,
+		CompletionTokens: r.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:      r.UsageMetadata.TotalTokenCount,
+	}
+}
+
+func (r *geminiResponse) apiError() *APIError {
+	if r.Error != nil {
+		return &APIError{Message: r.Error.Message, Type: r.Error.Status}
+	}
+	if r.PromptFeedback != nil && r.PromptFeedback.BlockReason != "" {
+		return &APIError{Message: "prompt blocked: " + r.PromptFeedback.BlockReason, Type: "blocked"}
+	}
+	return nil
+}
+
+func (p *GeminiProvider) newRequest(ctx context.Context, method, url string, payload interface{}) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", p.cfg.APIKey)
+	return httpReq, nil
+}
+
+func (p *GeminiProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	httpReq, err := p.newRequest(ctx, "POST", p.methodURL(p.model(req.Model), "generateContent"), toGeminiRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var geminiResp geminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode Gemini response: %w", err)
+	}
+
+	response := &Response{
+		Error: geminiResp.apiError(),
+		Usage: geminiResp.usage(),
+	}
+	if len(geminiResp.Candidates) > 0 {
+		response.Choices = []Choice{
+			{Message: Message{Role: "assistant", Content: geminiResp.text(), ToolCalls: geminiResp.toolCalls()}},
+		}
+	}
+
+	return response, nil
+}
+
+func (p *GeminiProvider) ChatStream(ctx context.Context, req Request, onDelta func(string)) (*Response, error) {
+	url := p.methodURL(p.model(req.Model), "streamGenerateContent") + "?alt=sse"
+	httpReq, err := p.newRequest(ctx, "POST", url, toGeminiRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 300 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	var fullContent strings.Builder
+	var toolCalls []ToolCall
+	var usage *Usage
+	finished := false
+
+	err = readSSE(resp.Body, func(data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil
+		}
+
+		if apiErr := chunk.apiError(); apiErr != nil {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+
+		if text := chunk.text(); text != "" {
+			fullContent.WriteString(text)
+			if onDelta != nil {
+				onDelta(text)
+			}
+		}
+		toolCalls = append(toolCalls, chunk.toolCalls()...)
+		if u := chunk.usage(); u != nil {
+			usage = u
+		}
+		if len(chunk.Candidates) > 0 && chunk.Candidates[0].FinishReason != "" {
+			finished = true
+		}
+		return nil
+	})
+	// Gemini has no [DONE] marker, the last chunk carries finishReason
+	if err == io.ErrUnexpectedEOF && finished {
+		err = nil
+	}
+
+	response := &Response{
+		Choices: []Choice{
+			{Message: Message{Role: "assistant", Content: fullContent.String(), ToolCalls: toolCalls}},
+		},
+		Usage: usage,
+	}
+	return response, err
+}
+
+func (p *GeminiProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := p.newRequest(ctx, "GET", p.baseURL()+"/models?pageSize=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Models []struct {
+			Name                       string   `json:"name"`
+			DisplayName                string   `json:"displayName"`
+			InputTokenLimit            int      `json:"inputTokenLimit"`
+			SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(result.Models))
+	for _, m := range result.Models {
+		models = append(models, ModelInfo{
+			ID:            strings.TrimPrefix(m.Name, "models/"),
+			Name:          m.DisplayName,
+			ContextLength: m.InputTokenLimit,
+		})
+	}
+
+	return models, nil
+}
+
+func (p *GeminiProvider) Embed(ctx context.Context, model, text string) ([]float32, error) {
+	if model == "" {
+		model = "text-embedding-004"
+	}
+	model = strings.TrimPrefix(model, "models/")
+
+	payload := map[string]interface{}{
+		"content": geminiContent{Parts: []geminiPart{{Text: text}}},
+	}
+
+	httpReq, err := p.newRequest(ctx, "POST", p.methodURL(model, "embedContent"), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Gemini embedding API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Gemini embedding API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Embedding struct {
+			Values []float32 `json:"values"`
+		} `json:"embedding"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+
+	if len(result.Embedding.Values) == 0 {
+		return nil, fmt.Errorf("no embeddings returned from Gemini")
+	}
+
+	return result.Embedding.Values, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// describeContents renders contents as e.g. "user[text:hi image:image/png] model[call:f]"
+func describeContents(contents []geminiContent) string {
+	var turns []string
+	for _, c := range contents {
+		var parts []string
+		for _, part := range c.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				parts = append(parts, fmt.Sprintf("call:%s%s", part.FunctionCall.Name, part.FunctionCall.Args))
+			case part.FunctionResponse != nil:
+				parts = append(parts, fmt.Sprintf("result:%s=%s", part.FunctionResponse.Name, part.FunctionResponse.Response.Content))
+			case part.InlineData != nil:
+				parts = append(parts, "image:"+part.InlineData.MimeType)
+			default:
+				parts = append(parts, "text:"+part.Text)
+			}
+		}
+		turns = append(turns, c.Role+"["+strings.Join(parts, " ")+"]")
+	}
+	return strings.Join(turns, " ")
+}
+
+func TestToGeminiRequest(t *testing.T) {
+	call := ToolCall{ID: "c1", Type: "function", Function: ToolCallFunction{Name: "rag_search", Arguments: `{"query":"go"}`}}
+	badCall := ToolCall{ID: "c2", Type: "function", Function: ToolCallFunction{Name: "memory_recall", Arguments: "not json"}}
+	png := Attachment{Name: "a.png", MimeType: "image/png", Data: []byte("png")}
+
+	tests := []struct {
+		name     string
+		messages []Message
+		want     string
+		system   string
+	}{
+		{
+			name:     "consecutive user turns merge",
+			messages: []Message{{Role: "user", Content: "a"}, {Role: "user", Content: "b"}, {Role: "assistant", Content: "c"}},
+			want:     "user[text:a text:b] model[text:c]",
+		},
+		{
+			name: "system messages become the instruction",
+			messages: []Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "hi"},
+				{Role: "system", Content: "Answer in Malay."},
+			},
+			want:   "user[text:hi]",
+			system: "Be brief.\n\nAnswer in Malay.",
+		},
+		{
+			name: "function calls and responses",
+			messages: []Message{
+				{Role: "user", Content: "find it"},
+				{Role: "assistant", ToolCalls: []ToolCall{call, badCall}},
+				{Role: "tool", ToolCallID: "c1", Name: "rag_search", Content: "found"},
+				{Role: "tool", ToolCallID: "c2", Name: "memory_recall", Content: "nothing"},
+				{Role: "user", Content: "thanks"},
+			},
+			want: `user[text:find it] model[call:rag_search{"query":"go"} call:memory_recall{}] user[result:rag_search=found result:memory_recall=nothing text:thanks]`,
+		},
+		{
+			name:     "text before a function call is kept",
+			messages: []Message{{Role: "user", Content: "q"}, {Role: "assistant", Content: "looking", ToolCalls: []ToolCall{call}}},
+			want:     `user[text:q] model[text:looking call:rag_search{"query":"go"}]`,
+		},
+		{
+			name:     "inline images",
+			messages: []Message{{Role: "user", Content: "what is this", Attachments: []Attachment{png}}},
+			want:     "user[text:what is this image:image/png]",
+		},
+		{
+			name:     "image without text has no empty text part",
+			messages: []Message{{Role: "user", Attachments: []Attachment{png}}},
+			want:     "user[image:image/png]",
+		},
+		{
+			name:     "empty model reply is dropped",
+			messages: []Message{{Role: "user", Content: "a"}, {Role: "assistant"}, {Role: "user", Content: "b"}},
+			want:     "user[text:a text:b]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := toGeminiRequest(Request{Messages: tt.messages})
+			if got := describeContents(out.Contents); got != tt.want {
+				t.Errorf("contents = %s\nwant       %s", got, tt.want)
+			}
+			system := ""
+			if out.SystemInstruction != nil {
+				system = describeContents([]geminiContent{*out.SystemInstruction})
+				system = strings.TrimSuffix(strings.TrimPrefix(system, "[text:"), "]")
+			}
+			if system != tt.system {
+				t.Errorf("systemInstruction = %q, want %q", system, tt.system)
+			}
+		})
+	}
+}
+
+func TestToGeminiRequestResponseFormat(t *testing.T) {
+	format := &ResponseFormat{Type: "json_object"}
+	out := toGeminiRequest(Request{Messages: []Message{{Role: "user", Content: "hi"}}, ResponseFormat: format})
+	if out.GenerationConfig == nil || out.GenerationConfig.ResponseMimeType != "application/json" {
+		t.Errorf("generationConfig = %+v", out.GenerationConfig)
+	}
+
+	// a JSON mime type cannot be combined with function calling
+	tools := []Tool{{Type: "function", Function: ToolFunction{Name: "rag_search"}}}
+	out = toGeminiRequest(Request{Messages: []Message{{Role: "user", Content: "hi"}}, ResponseFormat: format, Tools: tools, ToolChoice: "none"})
+	if out.GenerationConfig != nil {
+		t.Errorf("generationConfig with tools = %+v", out.GenerationConfig)
+	}
+	if out.SystemInstruction == nil {
+		t.Error("the JSON instruction should go into systemInstruction")
+	}
+	if len(out.Tools) != 1 || out.ToolConfig == nil || out.ToolConfig.FunctionCallingConfig.Mode != "NONE" {
+		t.Errorf("tools = %+v, toolConfig = %+v", out.Tools, out.ToolConfig)
+	}
+}
+
+// fakeGemini serves the given SSE chunks and records the request path and body
+func fakeGemini(t *testing.T, chunks []string, path *string, got *geminiRequest) *GeminiProvider {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("missing api key header: %v", r.Header)
+		}
+		if path != nil {
+			*path = r.URL.RequestURI()
+		}
+		if got != nil {
+			if err := json.NewDecoder(r.Body).Decode(got); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", chunk)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return newGeminiProvider(AIProvider{Name: "gemini", Endpoint: server.URL + "/v1beta/models/gemini-test:generateContent", APIKey: "test-key"}).(*GeminiProvider)
+}
+
+func TestGeminiStream(t *testing.T) {
+	var path string
+	var got geminiRequest
+	p := fakeGemini(t, []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":" world"}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"rag_search","args":{"query":"go"}}},{"functionCall":{"name":"memory_recall"}}]},"finishReason":"STOP"}],` +
+			`"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":6,"totalTokenCount":10}}`,
+	}, &path, &got)
+
+	var deltas []string
+	resp, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(s string) {
+		deltas = append(deltas, s)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if path != "/v1beta/models/gemini-test:streamGenerateContent?alt=sse" {
+		t.Errorf("path = %s", path)
+	}
+	if describeContents(got.Contents) != "user[text:hi]" {
+		t.Errorf("request contents = %s", describeContents(got.Contents))
+	}
+
+	msg := resp.Choices[0].Message
+	if msg.Content != "Hello world" || strings.Join(deltas, "|") != "Hello| world" {
+		t.Errorf("content = %q, deltas = %q", msg.Content, deltas)
+	}
+	if len(msg.ToolCalls) != 2 || msg.ToolCalls[0].Function.Arguments != `{"query":"go"}` || msg.ToolCalls[1].Function.Arguments != "{}" {
+		t.Errorf("tool calls = %+v", msg.ToolCalls)
+	}
+	want := Usage{PromptTokens: 4, CompletionTokens: 6, TotalTokens: 10}
+	if resp.Usage == nil || *resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", resp.Usage, want)
+	}
+}
+
+func TestGeminiStreamCutOff(t *testing.T) {
+	p := fakeGemini(t, []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"half"}]}}]}`,
+	}, nil, nil)
+
+	if _, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, nil); err == nil {
+		t.Fatal("a stream without finishReason should fail")
+	}
+}
+
+func TestGeminiStreamBlocked(t *testing.T) {
+	p := fakeGemini(t, []string{
+		`{"promptFeedback":{"blockReason":"SAFETY"}}`,
+	}, nil, nil)
+
+	_, err := p.ChatStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "prompt blocked: SAFETY") {
+		t.Fatalf("err = %v, want the block reason", err)
+	}
+}
+
+func TestGeminiChat(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hi"},{"text":" there"}]},"finishReason":"STOP"}]}`)
+	}))
+	defer server.Close()
+	p := newGeminiProvider(AIProvider{Name: "gemini", Endpoint: server.URL, Model: "models/gemini-default"})
+
+	resp, err := p.Chat(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if path != "/models/gemini-default:generateContent" {
+		t.Errorf("path = %s", path)
+	}
+	if resp.Choices[0].Message.Content != "Hi there" {
+		t.Errorf("content = %q", resp.Choices[0].Message.Content)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+const (
+	DefaultFailureThreshold = 3
+	DefaultCooldownSeconds  = 60
+	MaxCooldownSeconds      = 1800
+	// A half-open probe that never reports back frees the breaker after this
+	ProbeTimeoutSeconds = 120
+	// Stats are written at most this often; breaker changes are written at once
+	HealthSaveIntervalSeconds = 10
+)
+
+// CircuitBreakerConfig lives in providers.json. Zero values use the defaults.
+type CircuitBreakerConfig struct {
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	CooldownSeconds  int `json:"cooldown_seconds,omitempty"`
+}
+
+// ProviderHealth holds the rolling stats and breaker state for one
+// provider/model pair.
+type ProviderHealth struct {
+	Provider            string  `json:"provider"`
+	Model               string  `json:"model"`
+	Successes           int     `json:"successes"`
+	Failures            int     `json:"failures"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	AvgLatencyMs        float64 `json:"avg_latency_ms"`
+	LastError           string  `json:"last_error,omitempty"`
+	LastErrorType       string  `json:"last_error_type,omitempty"`
+	LastSuccess         string  `json:"last_success,omitempty"`
+	LastFailure         string  `json:"last_failure,omitempty"`
+	State               string  `json:"state"`
+	Trips               int     `json:"trips,omitempty"`
+	OpenUntil           string  `json:"open_until,omitempty"`
+	ProbeStarted        string  `json:"probe_started,omitempty"`
+}
+
+type HealthStore struct {
+	Entries map[string]*ProviderHealth `json:"entries"`
+}
+
+var (
+	providerHealth   HealthStore
+	providerHealthMu sync.Mutex
+	// modification time of the file as last read or written, so changes made
+	// by another process (the CLI next to the web server) are picked up
+	providerHealthModTime time.Time
+	providerHealthSaved   time.Time
+)
+
+func getHealthPath() string {
+	return filepath.Join(getDataDir(), "provider-health.json")
+}
+
+func healthKey(provider, model string) string {
+	return provider + "/" + model
+}
+
+// loadProviderHealth must be called with providerHealthMu held. It reads the
+// store again whenever the file has changed since it was last read or written.
+func loadProviderHealth() {
+	info, statErr := os.Stat(getHealthPath())
+	if providerHealth.Entries != nil && (statErr != nil || info.ModTime().Equal(providerHealthModTime)) {
+		return
+	}
+
+	providerHealth = HealthStore{Entries: map[string]*ProviderHealth{}}
+	if statErr != nil {
+		return
+	}
+	providerHealthModTime = info.ModTime()
+	data, err := os.ReadFile(getHealthPath())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &providerHealth); err != nil || providerHealth.Entries == nil {
+		providerHealth.Entries = map[string]*ProviderHealth{}
+	}
+}
+
+// saveProviderHealth must be called with providerHealthMu held
+func saveProviderHealth() error {
+	os.MkdirAll(getDataDir(), 0755)
+
+	data, err := json.MarshalIndent(providerHealth, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(getHealthPath(), data, 0644); err != nil {
+		return err
+	}
+	providerHealthSaved = time.Now()
+	if info, err := os.Stat(getHealthPath()); err == nil {
+		providerHealthModTime = info.ModTime()
+	}
+	return nil
+}
+
+func breakerConfig() CircuitBreakerConfig {
+	cfg := CircuitBreakerConfig{
+		FailureThreshold: DefaultFailureThreshold,
+		CooldownSeconds:  DefaultCooldownSeconds,
+	}
+	if custom := providerConfig.CircuitBreaker; custom != nil {
+		if custom.FailureThreshold > 0 {
+			cfg.FailureThreshold = custom.FailureThreshold
+		}
+		if custom.CooldownSeconds > 0 {
+			cfg.CooldownSeconds = custom.CooldownSeconds
+		}
+	}
+	return cfg
+}
+
+// countsAgainstHealth filters out failures caused by the request itself
+// rather than the provider.
+func countsAgainstHealth(perr *ProviderError) bool {
+	return perr.Type != ErrTypeBadRequest && perr.Type != ErrTypeCanceled && perr.Type != ErrTypeCircuitOpen
+}
+
+// recordProviderResult updates stats and the circuit breaker after a request.
+// perr is nil on success.
+func recordProviderResult(provider, model string, latency time.Duration, perr *ProviderError) {
+	providerHealthMu.Lock()
+	defer providerHealthMu.Unlock()
+	loadProviderHealth()
+
+	if perr != nil && !countsAgainstHealth(perr) {
+		// The probe told nothing about the provider, let the next request probe
+		releaseProbeLocked(provider, model)
+		return
+	}
+	key := healthKey(provider, model)
+	h, exists := providerHealth.Entries[key]
+	if !exists {
+		h = &ProviderHealth{Provider: provider, Model: model, State: CircuitClosed}
+		providerHealth.Entries[key] = h
+	}
+
+	now := time.Now()
+	prevState := h.State
+	h.ProbeStarted = ""
+	if perr == nil {
+		h.Successes++
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = now.Format(time.RFC3339)
+		h.State = CircuitClosed
+		h.Trips = 0
+		h.OpenUntil = ""
+
+		// Exponential moving average keeps the latency responsive to change
+		ms := float64(latency.Milliseconds())
+		if h.AvgLatencyMs == 0 {
+			h.AvgLatencyMs = ms
+		} else {
+			h.AvgLatencyMs = 0.8*h.AvgLatencyMs + 0.2*ms
+		}
+	} else {
+		h.Failures++
+		h.ConsecutiveFailures++
+		h.LastFailure = now.Format(time.RFC3339)
+		h.LastError = perr.Message
+		if h.LastError == "" && perr.Err != nil {
+			h.LastError = perr.Err.Error()
+		}
+		h.LastErrorType = perr.Type
+
+		cfg := breakerConfig()
+		// A failed half-open probe reopens at once with a longer cooldown
+		if h.State == CircuitHalfOpen || (h.State == CircuitClosed && h.ConsecutiveFailures >= cfg.FailureThreshold) {
+			h.Trips++
+			cooldown := time.Duration(cfg.CooldownSeconds) * time.Second
+			for i := 1; i < h.Trips && cooldown < MaxCooldownSeconds*time.Second; i++ {
+				cooldown *= 2
+			}
+			if cooldown > MaxCooldownSeconds*time.Second {
+				cooldown = MaxCooldownSeconds * time.Second
+			}
+			// Honour a long Retry-After from a rate limit
+			if perr.RetryAfter > cooldown {
+				cooldown = perr.RetryAfter
+			}
+			h.State = CircuitOpen
+			h.OpenUntil = now.Add(cooldown).Format(time.RFC3339)
+		}
+	}
+
+	if !exists || h.State != prevState || now.Sub(providerHealthSaved) >= HealthSaveIntervalSeconds*time.Second {
+		saveProviderHealth()
+	}
+}
+
+// circuitState returns the current breaker state of a provider/model pair
+// without changing it. Once the cooldown of an open breaker has passed it is
+// half_open, and open again while another request holds the probe, until the
+// probe reports back through recordProviderResult or ProbeTimeoutSeconds pass.
+func circuitState(provider, model string) string {
+	providerHealthMu.Lock()
+	defer providerHealthMu.Unlock()
+	loadProviderHealth()
+
+	h, exists := providerHealth.Entries[healthKey(provider, model)]
+	if !exists {
+		return CircuitClosed
+	}
+	return h.currentState(time.Now())
+}
+
+func (h *ProviderHealth) currentState(now time.Time) string {
+	switch h.State {
+	case CircuitOpen:
+		if until, err := time.Parse(time.RFC3339, h.OpenUntil); err == nil && now.Before(until) {
+			return CircuitOpen
+		}
+	case CircuitHalfOpen:
+	default:
+		return h.State
+	}
+	if started, err := time.Parse(time.RFC3339, h.ProbeStarted); err == nil && now.Sub(started) < ProbeTimeoutSeconds*time.Second {
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
+
+// claimProbe is called right before a request is sent. When the breaker is
+// half_open the request becomes its single probe. It returns false when
+// another request holds the probe and this one should not be sent. An open
+// breaker that is still cooling down is only reached when every provider is
+// tripped, and is tried anyway.
+func claimProbe(provider, model string) bool {
+	providerHealthMu.Lock()
+	defer providerHealthMu.Unlock()
+	loadProviderHealth()
+
+	h, exists := providerHealth.Entries[healthKey(provider, model)]
+	if !exists || h.State == CircuitClosed {
+		return true
+	}
+	now := time.Now()
+	if h.currentState(now) != CircuitHalfOpen {
+		return h.ProbeStarted == ""
+	}
+	h.State = CircuitHalfOpen
+	h.ProbeStarted = now.Format(time.RFC3339)
+	saveProviderHealth()
+	return true
+}
+
+// releaseProbe frees the probe of a request that was not sent or whose
+// failure says nothing about the provider, so the next request probes
+func releaseProbe(provider, model string) {
+	providerHealthMu.Lock()
+	defer providerHealthMu.Unlock()
+	loadProviderHealth()
+	releaseProbeLocked(provider, model)
+}
+
+// releaseProbeLocked must be called with providerHealthMu held
+func releaseProbeLocked(provider, model string) {
+	if h, exists := providerHealth.Entries[healthKey(provider, model)]; exists && h.ProbeStarted != "" {
+		h.ProbeStarted = ""
+		saveProviderHealth()
+	}
+}
+
+// getProviderHealth returns the health entries of a provider, one per model
+func getProviderHealth(provider string) []ProviderHealth {
+	providerHealthMu.Lock()
+	defer providerHealthMu.Unlock()
+	loadProviderHealth()
+
+	var entries []ProviderHealth
+	for _, h := range providerHealth.Entries {
+		if h.Provider == provider {
+			entries = append(entries, *h)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Model < entries[j].Model
+	})
+	return entries
+}
+
+// resetProviderHealth clears the stats and closes the breakers of a provider
+func resetProviderHealth(provider string) error {
+	providerHealthMu.Lock()
+	defer providerHealthMu.Unlock()
+	loadProviderHealth()
+
+	for key, h := range providerHealth.Entries {
+		if h.Provider == provider {
+			delete(providerHealth.Entries, key)
+		}
+	}
+	return saveProviderHealth()
+}
+
+// formatHealth renders one entry for `provider list`
+func formatHealth(h ProviderHealth) string {
+	icon := "🟢"
+	state := h.State
+	switch h.State {
+	case CircuitOpen:
+		icon = "🔴"
+		if until, err := time.Parse(time.RFC3339, h.OpenUntil); err == nil {
+			if remaining := time.Until(until); remaining > 0 {
+				state = fmt.Sprintf("open, retry in %s", remaining.Round(time.Second))
+			} else {
+				state = "half_open"
+				icon = "🟡"
+			}
+		}
+	case CircuitHalfOpen:
+		icon = "🟡"
+	}
+
+	parts := []string{
+		fmt.Sprintf("%s %s [%s]", icon, h.Model, state),
+		fmt.Sprintf("%d ok / %d failed", h.Successes, h.Failures),
+	}
+	if h.AvgLatencyMs > 0 {
+		parts = append(parts, fmt.Sprintf("avg %.0fms", h.AvgLatencyMs))
+	}
+	if h.ConsecutiveFailures > 0 && h.LastErrorType != "" {
+		parts = append(parts, "last error: "+h.LastErrorType)
+	}
+	return strings.Join(parts, " | ")
+}
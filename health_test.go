@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// withHealthStore gives the test an empty health store in a temp data dir
+func withHealthStore(t *testing.T) {
+	t.Helper()
+	isolateDataDir(t)
+	swapGlobal(t, &providerHealth, HealthStore{})
+	swapGlobal(t, &providerHealthModTime, time.Time{})
+	swapGlobal(t, &providerHealthSaved, time.Time{})
+}
+
+func serverError() *ProviderError {
+	return &ProviderError{Provider: "p", Type: ErrTypeServerError, Message: "boom"}
+}
+
+// expireCooldown moves the open breaker's cooldown into the past
+func expireCooldown(t *testing.T, provider, model string) {
+	t.Helper()
+	h := providerHealth.Entries[healthKey(provider, model)]
+	if h == nil || h.State != CircuitOpen {
+		t.Fatalf("breaker not open: %+v", h)
+	}
+	h.OpenUntil = time.Now().Add(-time.Second).Format(time.RFC3339)
+}
+
+func TestCircuitTripsAfterThreshold(t *testing.T) {
+	withHealthStore(t)
+
+	for i := 0; i < DefaultFailureThreshold-1; i++ {
+		recordProviderResult("p", "m", time.Millisecond, serverError())
+	}
+	if got := circuitState("p", "m"); got != CircuitClosed {
+		t.Fatalf("state after %d failures = %s", DefaultFailureThreshold-1, got)
+	}
+
+	recordProviderResult("p", "m", time.Millisecond, serverError())
+	if got := circuitState("p", "m"); got != CircuitOpen {
+		t.Fatalf("state after threshold = %s", got)
+	}
+	if got := circuitState("p", "other-model"); got != CircuitClosed {
+		t.Errorf("other model state = %s", got)
+	}
+}
+
+func TestCircuitIgnoresRequestErrors(t *testing.T) {
+	withHealthStore(t)
+
+	for i := 0; i < DefaultFailureThreshold*2; i++ {
+		recordProviderResult("p", "m", 0, &ProviderError{Type: ErrTypeBadRequest})
+		recordProviderResult("p", "m", 0, &ProviderError{Type: ErrTypeCanceled})
+	}
+	if got := circuitState("p", "m"); got != CircuitClosed {
+		t.Errorf("state = %s, bad requests and cancellations must not trip", got)
+	}
+}
+
+func TestCircuitSingleProbe(t *testing.T) {
+	withHealthStore(t)
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		recordProviderResult("p", "m", 0, serverError())
+	}
+	if !claimProbe("p", "m") {
+		t.Fatal("a tripped provider is still tried when it is the last resort")
+	}
+	expireCooldown(t, "p", "m")
+
+	for i := 0; i < 3; i++ {
+		if got := circuitState("p", "m"); got != CircuitHalfOpen {
+			t.Fatalf("state after cooldown = %s, want half_open", got)
+		}
+	}
+	if !claimProbe("p", "m") {
+		t.Fatal("first request after cooldown was not given the probe")
+	}
+	for i := 0; i < 3; i++ {
+		if got := circuitState("p", "m"); got != CircuitOpen {
+			t.Fatalf("state during probe = %s, want open", got)
+		}
+		if claimProbe("p", "m") {
+			t.Fatal("a second request got the probe")
+		}
+	}
+
+	recordProviderResult("p", "m", 10*time.Millisecond, nil)
+	if got := circuitState("p", "m"); got != CircuitClosed {
+		t.Errorf("state after successful probe = %s", got)
+	}
+}
+
+func TestFallbackOrderDoesNotClaimProbes(t *testing.T) {
+	withHealthStore(t)
+	swapGlobal(t, &providerConfig, ProviderGlobalConfig{Providers: map[string]AIProviderConfig{
+		"p": {Enabled: true, Priority: 1},
+		"q": {Enabled: true, Priority: 2},
+	}})
+	swapGlobal(t, &providers, map[string]AIProvider{"p": {Name: "p", Model: "m"}, "q": {Name: "q", Model: "m"}})
+
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		recordProviderResult("q", "m", 0, serverError())
+	}
+	expireCooldown(t, "q", "m")
+
+	// q is listed but never called: it must stay available to the next request
+	for i := 0; i < 2; i++ {
+		if order := fallbackOrder("p", ""); len(order) != 2 || order[1] != "q" {
+			t.Fatalf("order = %v", order)
+		}
+	}
+	if got := circuitState("q", "m"); got != CircuitHalfOpen {
+		t.Errorf("state after listing = %s, want half_open", got)
+	}
+}
+
+func TestCircuitFailedProbeReopensLonger(t *testing.T) {
+	withHealthStore(t)
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		recordProviderResult("p", "m", 0, serverError())
+	}
+	expireCooldown(t, "p", "m")
+	claimProbe("p", "m")
+
+	before := time.Now()
+	recordProviderResult("p", "m", 0, serverError())
+	h := providerHealth.Entries[healthKey("p", "m")]
+	if h.State != CircuitOpen || h.Trips != 2 {
+		t.Fatalf("after failed probe state=%s trips=%d", h.State, h.Trips)
+	}
+	until, _ := time.Parse(time.RFC3339, h.OpenUntil)
+	if cooldown := until.Sub(before); cooldown < 2*DefaultCooldownSeconds*time.Second-time.Second {
+		t.Errorf("second cooldown %v, want doubled", cooldown)
+	}
+}
+
+func TestCircuitProbeReleased(t *testing.T) {
+	withHealthStore(t)
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		recordProviderResult("p", "m", 0, serverError())
+	}
+	expireCooldown(t, "p", "m")
+	claimProbe("p", "m")
+
+	// A cancelled probe says nothing about the provider: the next caller probes
+	recordProviderResult("p", "m", 0, &ProviderError{Type: ErrTypeCanceled})
+	if !claimProbe("p", "m") {
+		t.Fatal("probe not released after a cancelled request")
+	}
+
+	releaseProbe("p", "m")
+	if got := circuitState("p", "m"); got != CircuitHalfOpen {
+		t.Fatalf("after release = %s, want half_open", got)
+	}
+
+	// A probe that never reports back times out
+	claimProbe("p", "m")
+	h := providerHealth.Entries[healthKey("p", "m")]
+	h.ProbeStarted = time.Now().Add(-(ProbeTimeoutSeconds + 1) * time.Second).Format(time.RFC3339)
+	if got := circuitState("p", "m"); got != CircuitHalfOpen {
+		t.Fatalf("after probe timeout = %s, want half_open", got)
+	}
+}
+
+func TestHealthReloadsChangedFile(t *testing.T) {
+	withHealthStore(t)
+	recordProviderResult("p", "m", 0, nil)
+
+	// Another process trips the breaker
+	store := HealthStore{Entries: map[string]*ProviderHealth{
+		healthKey("p", "m"): {
+			Provider:  "p",
+			Model:     "m",
+			State:     CircuitOpen,
+			OpenUntil: time.Now().Add(time.Hour).Format(time.RFC3339),
+		},
+	}}
+	data, _ := json.Marshal(store)
+	if err := os.WriteFile(getHealthPath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(getHealthPath(), later, later)
+
+	if got := circuitState("p", "m"); got != CircuitOpen {
+		t.Errorf("state = %s, the change on disk was not picked up", got)
+	}
+}
+
+func TestHealthSavesStatsLazily(t *testing.T) {
+	withHealthStore(t)
+	recordProviderResult("p", "m", 0, nil)
+	recordProviderResult("p", "m", 0, nil)
+
+	data, err := os.ReadFile(getHealthPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var store HealthStore
+	json.Unmarshal(data, &store)
+	if got := store.Entries[healthKey("p", "m")].Successes; got != 1 {
+		t.Errorf("saved successes = %d, want only the first write", got)
+	}
+	if got := getProviderHealth("p")[0].Successes; got != 2 {
+		t.Errorf("in-memory successes = %d", got)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+// isolateDataDir points the data dir and HOME, which holds the config dir,
+// at temp dirs so a test never touches the user's files. It returns the data
+// dir.
+func isolateDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dir)
+	t.Setenv("HOME", t.TempDir())
+	return dir
+}
+
+// swapGlobal sets a package variable for the rest of the test and restores
+// the old value afterwards
+func swapGlobal[T any](t *testing.T, global *T, value T) {
+	t.Helper()
+	saved := *global
+	t.Cleanup(func() { *global = saved })
+	*global = value
+}
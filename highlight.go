@@ -0,0 +1,284 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Colours used for code, as SGR codes
+const (
+	colorKeyword  = "35"
+	colorType     = "36"
+	colorString   = "32"
+	colorComment  = "90"
+	colorNumber   = "33"
+	colorFunction = "34"
+)
+
+// langSpec is the little a highlighter needs to know about a language
+type langSpec struct {
+	keywords     map[string]bool
+	types        map[string]bool // builtin types and constants
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	multiline    []string // delimiters of strings that may span lines
+	variable     rune     // prefix of variables, e.g. "$" in shell
+	keys         bool     // colour "key": as in JSON and YAML
+	ignoreCase   bool
+}
+
+func words(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+	return set
+}
+
+var cLikeComment = [2]string{"/*", "*/"}
+
+var languages = map[string]*langSpec{
+	"go": {
+		keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+		types:        words("any bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr true false nil iota append cap clear close copy delete len make max min new panic print println recover"),
+		lineComments: []string{"//"},
+		blockComment: cLikeComment,
+		quotes:       `"'`,
+		multiline:    []string{"`"},
+	},
+	"python": {
+		keywords:     words("and as assert async await break case class continue def del elif else except finally for from global if import in is lambda match nonlocal not or pass raise return try while with yield"),
+		types:        words("True False None self cls int str float bool bytes list dict set tuple object type len range print open isinstance super Exception"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		multiline:    []string{`"""`, "'''"},
+	},
+	"javascript": {
+		keywords:     words("abstract as async await break case catch class const continue debugger declare default delete do else enum export extends finally for from function get if implements import in instanceof interface let namespace new of private protected public readonly return set static super switch this throw try type typeof var void while with yield"),
+		types:        words("true false null undefined NaN Infinity any boolean never number object string unknown Array Object Promise Map Set Error JSON console"),
+		lineComments: []string{"//"},
+		blockComment: cLikeComment,
+		quotes:       `"'`,
+		multiline:    []string{"`"},
+	},
+	"rust": {
+		keywords:     words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+		types:        words("bool char str String i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64 Option Some None Result Ok Err Vec Box true false"),
+		lineComments: []string{"//"},
+		blockComment: cLikeComment,
+		quotes:       `"`,
+	},
+	"c": {
+		keywords:     words("auto break case catch class const constexpr continue default define delete do else endif enum extern for goto if ifdef ifndef include inline namespace new operator private protected public register return sizeof static struct switch template this throw try typedef typename union using virtual volatile while"),
+		types:        words("bool char double float int long short signed unsigned void size_t auto std string vector true false NULL nullptr"),
+		lineComments: []string{"//"},
+		blockComment: cLikeComment,
+		quotes:       `"'`,
+	},
+	"java": {
+		keywords:     words("abstract assert break case catch class const continue default do else enum extends final finally for if implements import instanceof interface native new package private protected public record return static super switch synchronized this throw throws try var volatile while"),
+		types:        words("boolean byte char double float int long short void String Object List Map true false null"),
+		lineComments: []string{"//"},
+		blockComment: cLikeComment,
+		quotes:       `"'`,
+	},
+	"sh": {
+		keywords:     words("if then else elif fi for while until do done case esac in function return local export readonly unset shift exit break continue select declare source alias"),
+		types:        words("echo cd printf read test true false"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		variable:     '$',
+	},
+	"sql": {
+		keywords:     words("add all alter and as asc begin between by case commit create default delete desc distinct drop else end exists foreign from group having in index inner insert into is join key left like limit not null offset on or order outer primary references returning right rollback select set table then union update values view when where with"),
+		types:        words("int integer bigint smallint text varchar char boolean date timestamp numeric decimal serial count sum avg min max true false"),
+		lineComments: []string{"--"},
+		blockComment: cLikeComment,
+		quotes:       `'"`,
+		ignoreCase:   true,
+	},
+	"json": {
+		types:  words("true false null"),
+		quotes: `"`,
+		keys:   true,
+	},
+	"yaml": {
+		types:        words("true false null yes no on off"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keys:         true,
+	},
+}
+
+var languageAliases = map[string]string{
+	"golang": "go", "py": "python", "python3": "python",
+	"js": "javascript", "jsx": "javascript", "ts": "javascript", "tsx": "javascript", "typescript": "javascript", "node": "javascript",
+	"rs": "rust", "cpp": "c", "c++": "c", "cc": "c", "h": "c", "hpp": "c",
+	"kotlin": "java", "kt": "java", "cs": "java", "csharp": "java",
+	"bash": "sh", "shell": "sh", "zsh": "sh", "console": "sh",
+	"postgres": "sql", "postgresql": "sql", "mysql": "sql", "sqlite": "sql",
+	"yml": "yaml", "jsonc": "json",
+}
+
+// highlighter colours the lines of one code block. Block comments and
+// multi-line strings carry over from line to line.
+type highlighter struct {
+	spec     *langSpec
+	blockEnd string // closing delimiter of the comment or string still open
+	color    string
+}
+
+// newHighlighter returns a highlighter for the language; unknown languages
+// are left uncoloured
+func newHighlighter(lang string) *highlighter {
+	lang = strings.ToLower(lang)
+	if alias, ok := languageAliases[lang]; ok {
+		lang = alias
+	}
+	return &highlighter{spec: languages[lang]}
+}
+
+func paint(color, text string) string {
+	if text == "" {
+		return ""
+	}
+	return "\x1b[" + color + "m" + text + "\x1b[0m"
+}
+
+func hasPrefixAt(r []rune, i int, prefix string) bool {
+	return prefix != "" && strings.HasPrefix(string(r[i:]), prefix)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (h *highlighter) line(line string) string {
+	spec := h.spec
+	if spec == nil {
+		return line
+	}
+	r := []rune(line)
+	var sb strings.Builder
+
+	i := 0
+	if h.blockEnd != "" {
+		i = h.closeBlock(r, 0, 0, &sb)
+	}
+
+scan:
+	for i < len(r) {
+		for _, prefix := range spec.lineComments {
+			if hasPrefixAt(r, i, prefix) {
+				sb.WriteString(paint(colorComment, string(r[i:])))
+				break scan
+			}
+		}
+		if hasPrefixAt(r, i, spec.blockComment[0]) {
+			h.blockEnd, h.color = spec.blockComment[1], colorComment
+			i = h.closeBlock(r, i, i+len([]rune(spec.blockComment[0])), &sb)
+			continue
+		}
+		for _, delim := range spec.multiline {
+			if hasPrefixAt(r, i, delim) {
+				h.blockEnd, h.color = delim, colorString
+				i = h.closeBlock(r, i, i+len([]rune(delim)), &sb)
+				continue scan
+			}
+		}
+
+		c := r[i]
+		switch {
+		case strings.ContainsRune(spec.quotes, c):
+			j := i + 1
+			for j < len(r) && r[j] != c {
+				if r[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(r))
+			color := colorString
+			if spec.keys && followedByColon(r, j) {
+				color = colorType
+			}
+			sb.WriteString(paint(color, string(r[i:j])))
+			i = j
+		case spec.variable != 0 && c == spec.variable && i+1 < len(r) && (isIdentRune(r[i+1]) || r[i+1] == '{'):
+			j := i + 1
+			if r[j] == '{' {
+				for j < len(r) && r[j] != '}' {
+					j++
+				}
+				j = min(j+1, len(r))
+			} else {
+				for j < len(r) && isIdentRune(r[j]) {
+					j++
+				}
+			}
+			sb.WriteString(paint(colorType, string(r[i:j])))
+			i = j
+		case unicode.IsDigit(c) && (i == 0 || !isIdentRune(r[i-1])):
+			j := i
+			for j < len(r) && (isIdentRune(r[j]) || r[j] == '.') {
+				j++
+			}
+			sb.WriteString(paint(colorNumber, string(r[i:j])))
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(r) && (isIdentRune(r[j]) || (spec.keys && r[j] == '-')) {
+				j++
+			}
+			sb.WriteString(h.word(string(r[i:j]), r, j))
+			i = j
+		default:
+			sb.WriteRune(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// closeBlock colours an open comment or string, which starts at start and
+// whose text starts at from, up to its closing delimiter or to the end of
+// the line when it continues on the next one
+func (h *highlighter) closeBlock(r []rune, start, from int, sb *strings.Builder) int {
+	rest := string(r[from:])
+	end := strings.Index(rest, h.blockEnd)
+	if end < 0 {
+		sb.WriteString(paint(h.color, string(r[start:])))
+		return len(r)
+	}
+	stop := from + len([]rune(rest[:end])) + len([]rune(h.blockEnd))
+	sb.WriteString(paint(h.color, string(r[start:stop])))
+	h.blockEnd = ""
+	return stop
+}
+
+func (h *highlighter) word(w string, r []rune, end int) string {
+	spec := h.spec
+	key := w
+	if spec.ignoreCase {
+		key = strings.ToLower(w)
+	}
+	switch {
+	case spec.keys && followedByColon(r, end):
+		return paint(colorType, w)
+	case spec.keywords[key]:
+		return paint(colorKeyword, w)
+	case spec.types[key]:
+		return paint(colorType, w)
+	case end < len(r) && r[end] == '(':
+		return paint(colorFunction, w)
+	}
+	return w
+}
+
+func followedByColon(r []rune, i int) bool {
+	for i < len(r) && r[i] == ' ' {
+		i++
+	}
+	return i < len(r) && r[i] == ':' && (i+1 == len(r) || r[i+1] == ' ')
+}
@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -21,19 +23,27 @@ import (
 
 type AIProvider struct {
 	Name     string
+	Type     string
 	APIKey   string
 	Endpoint string
 	Model    string
 }
 
 type AIProviderConfig struct {
-	Priority    int                   `json:"priority"`
-	Enabled     bool                  `json:"enabled"`
-	MaxRetries  int                   `json:"max_retries"`
-	GopassKey   string                `json:"gopass_key"`
-	EnvKey      string                `json:"env_key"`
-	EndpointKey string                `json:"endpoint_key"`
-	ModelKey    string                `json:"model_key"`
+	Type        string            `json:"type,omitempty"`
+	Priority    int               `json:"priority"`
+	Enabled     bool              `json:"enabled"`
+	MaxRetries  int               `json:"max_retries"`
+	Retry       *RetryPolicy      `json:"retry,omitempty"`
+	Params      *GenerationParams `json:"params,omitempty"`
+	GopassKey   string            `json:"gopass_key"`
+	EnvKey      string            `json:"env_key"`
+	EndpointKey string            `json:"endpoint_key"`
+	ModelKey    string            `json:"model_key"`
+	// Endpoint and Model are used when the EndpointKey/ModelKey variables
+	// are not set, custom providers keep theirs here
+	Endpoint    string                `json:"endpoint,omitempty"`
+	Model       string                `json:"model,omitempty"`
 	BYOK        bool                  `json:"byok"`
 	Description string                `json:"description"`
 	BYOKConfig  *OpenRouterBYOKConfig `json:"byok_config,omitempty"`
@@ -48,8 +58,6 @@ type OpenRouterBYOKConfig struct {
 
 type PromptsConfig struct {
 	InputMessage    string `json:"input_message"`
-	ContinuePrompt  string `json:"continue_prompt"`
-	ContinueYes     string `json:"continue_yes"`
 	MessageEmpty    string `json:"message_empty"`
 	ChatSaved       string `json:"chat_saved"`
 	LoadedSession   string `json:"loaded_session"`
@@ -60,24 +68,40 @@ type PromptsConfig struct {
 }
 
 type ProviderGlobalConfig struct {
-	DefaultProvider string                      `json:"default_provider"`
-	FallbackEnabled bool                        `json:"fallback_enabled"`
-	RetryAttempts   int                         `json:"retry_attempts"`
-	RetryDelayMs    int                         `json:"retry_delay_ms"`
-	Providers       map[string]AIProviderConfig `json:"providers"`
-	Prompts         PromptsConfig               `json:"prompts"`
+	DefaultProvider  string                      `json:"default_provider"`
+	FallbackEnabled  bool                        `json:"fallback_enabled"`
+	RetryAttempts    int                         `json:"retry_attempts"`
+	RetryDelayMs     int                         `json:"retry_delay_ms"`
+	CircuitBreaker   *CircuitBreakerConfig       `json:"circuit_breaker,omitempty"`
+	FallbackStrategy string                      `json:"fallback_strategy,omitempty"`
+	RaceProviders    int                         `json:"race_providers,omitempty"`
+	HedgeDelayMs     int                         `json:"hedge_delay_ms,omitempty"`
+	Cache            *CacheConfig                `json:"cache,omitempty"`
+	Context          *ContextConfig              `json:"context,omitempty"`
+	Providers        map[string]AIProviderConfig `json:"providers"`
+	Prompts          PromptsConfig               `json:"prompts"`
 }
 
-type ProviderError struct {
-	Provider string
-	Error    error
-	Type     string
+type Request struct {
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	Tools          []Tool          `json:"tools,omitempty"`
+	ToolChoice     string          `json:"tool_choice,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	MaxSteps       int             `json:"-"` // tool rounds allowed by runAgent
+	GenerationParams
 }
 
-type Request struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream,omitempty"`
+// StreamOptions asks OpenAI-compatible APIs to send usage in the final chunk
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// UsageAccounting asks OpenRouter to include the cost in the usage block
+type UsageAccounting struct {
+	Include bool `json:"include"`
 }
 
 type OpenRouterProvider struct {
@@ -86,20 +110,41 @@ type OpenRouterProvider struct {
 }
 
 type OpenRouterRequest struct {
-	Model    string              `json:"model"`
-	Messages []Message           `json:"messages"`
-	Stream   bool                `json:"stream,omitempty"`
-	Provider *OpenRouterProvider `json:"provider,omitempty"`
+	Model          string              `json:"model"`
+	Messages       []Message           `json:"messages"`
+	Stream         bool                `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions      `json:"stream_options,omitempty"`
+	Provider       *OpenRouterProvider `json:"provider,omitempty"`
+	Usage          *UsageAccounting    `json:"usage,omitempty"`
+	Tools          []Tool              `json:"tools,omitempty"`
+	ToolChoice     string              `json:"tool_choice,omitempty"`
+	ResponseFormat *ResponseFormat     `json:"response_format,omitempty"`
+	GenerationParams
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"-"` // tool name of a "tool" message, needed by Gemini and Ollama
+	// Attachments are sent as content parts, see Message.MarshalJSON
+	Attachments []Attachment `json:"-"`
 }
 
 type Response struct {
-	Choices []Choice  `json:"choices"`
-	Error   *APIError `json:"error,omitempty"`
+	Choices  []Choice  `json:"choices"`
+	Error    *APIError `json:"error,omitempty"`
+	Usage    *Usage    `json:"usage,omitempty"`
+	Cached   bool      `json:"-"`
+	ToolRuns []ToolRun `json:"-"`
+}
+
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost,omitempty"` // OpenRouter reports the charged amount in USD
 }
 
 type Choice struct {
@@ -112,16 +157,19 @@ type Delta struct {
 }
 
 type StreamingDelta struct {
-	Content string `json:"content"`
+	Content   string          `json:"content"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
 }
 
 type StreamingChoice struct {
-	Delta StreamingDelta `json:"delta"`
+	Delta        StreamingDelta `json:"delta"`
+	FinishReason string         `json:"finish_reason,omitempty"`
 }
 
 type StreamingResponse struct {
 	Choices []StreamingChoice `json:"choices"`
 	Error   *APIError         `json:"error,omitempty"`
+	Usage   *Usage            `json:"usage,omitempty"`
 }
 
 type APIError struct {
@@ -143,26 +191,41 @@ type RAGIndex struct {
 }
 
 type Skill struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Triggers    []string `json:"triggers"`
-	Template    string   `json:"template"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Triggers    []string          `json:"triggers"`
+	Template    string            `json:"template"`
+	Params      *GenerationParams `json:"params,omitempty"`
 }
 
 type ChatMessage struct {
-	Role      string `json:"role"`
-	Content   string `json:"content"`
-	Timestamp string `json:"timestamp"`
+	Role        string            `json:"role"`
+	Content     string            `json:"content"`
+	Timestamp   string            `json:"timestamp"`
+	Interrupted bool              `json:"interrupted,omitempty"`
+	Provider    string            `json:"provider,omitempty"`
+	Params      *GenerationParams `json:"params,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	// Context lists what was injected into the request that produced a reply
+	Context []ContextSource `json:"context,omitempty"`
 }
 
 type ChatSession struct {
-	ID        string        `json:"id"`
-	Title     string        `json:"title"`
-	Provider  string        `json:"provider"`
-	User      string        `json:"user"`
-	CreatedAt string        `json:"created_at"`
-	UpdatedAt string        `json:"updated_at"`
-	Messages  []ChatMessage `json:"messages"`
+	ID        string            `json:"id"`
+	Title     string            `json:"title"`
+	Provider  string            `json:"provider"`
+	User      string            `json:"user"`
+	CreatedAt string            `json:"created_at"`
+	UpdatedAt string            `json:"updated_at"`
+	System    string            `json:"system,omitempty"`
+	Persona   string            `json:"persona,omitempty"`
+	Model     string            `json:"model,omitempty"`
+	Params    *GenerationParams `json:"params,omitempty"`
+	// Summary is the running summary of the first SummarizedCount messages,
+	// sent in their place once they no longer fit the context budget
+	Summary         string        `json:"summary,omitempty"`
+	SummarizedCount int           `json:"summarized_count,omitempty"`
+	Messages        []ChatMessage `json:"messages"`
 }
 
 type ChatHistory struct {
@@ -225,6 +288,16 @@ func loadProviderConfig() error {
 		return err
 	}
 
+	// Built-in providers added after the config file was written
+	if providerConfig.Providers == nil {
+		providerConfig.Providers = map[string]AIProviderConfig{}
+	}
+	for name, config := range defaultProviderConfig().Providers {
+		if _, exists := providerConfig.Providers[name]; !exists {
+			providerConfig.Providers[name] = config
+		}
+	}
+
 	return nil
 }
 
@@ -233,7 +306,14 @@ func createDefaultProviderConfig(path string) error {
 	configPath := filepath.Join(homeDir, configDir)
 	os.MkdirAll(configPath, 0755)
 
-	defaultConfig := ProviderGlobalConfig{
+	providerConfig = defaultProviderConfig()
+
+	data, _ := json.MarshalIndent(providerConfig, "", "  ")
+	return os.WriteFile(path, data, 0644)
+}
+
+func defaultProviderConfig() ProviderGlobalConfig {
+	return ProviderGlobalConfig{
 		DefaultProvider: "openrouter",
 		FallbackEnabled: true,
 		RetryAttempts:   3,
@@ -278,11 +358,28 @@ func createDefaultProviderConfig(path string) error {
 				EndpointKey: "GROQ_ENDPOINT",
 				ModelKey:    "GROQ_MODEL",
 			},
+			"anthropic": {
+				Type:        "anthropic",
+				Priority:    4,
+				Enabled:     true,
+				MaxRetries:  2,
+				GopassKey:   "terminal-ai/anthropic_api_key",
+				EnvKey:      "ANTHROPIC_API_KEY",
+				EndpointKey: "ANTHROPIC_ENDPOINT",
+				ModelKey:    "ANTHROPIC_MODEL",
+			},
+			"ollama": {
+				Type:        "ollama",
+				Priority:    100,
+				Enabled:     false,
+				MaxRetries:  0,
+				EndpointKey: "OLLAMA_ENDPOINT",
+				ModelKey:    "OLLAMA_MODEL",
+				Description: "Local Ollama server, no API key needed (offline fallback)",
+			},
 		},
 		Prompts: PromptsConfig{
 			InputMessage:    "Your message: ",
-			ContinuePrompt:  "\nContinue? (y/n): ",
-			ContinueYes:     "y",
 			MessageEmpty:    "Message cannot be empty",
 			ChatSaved:       "\nChat saved with ID: %s\n",
 			LoadedSession:   "Loaded session: %s\n",
@@ -292,9 +389,6 @@ func createDefaultProviderConfig(path string) error {
 			FallbackPrompt:  "Fallback enabled: %v\n",
 		},
 	}
-
-	data, _ := json.MarshalIndent(defaultConfig, "", "  ")
-	return os.WriteFile(path, data, 0644)
 }
 
 func getOrderedProviders() []string {
@@ -321,37 +415,12 @@ func getOrderedProviders() []string {
 	return result
 }
 
+// classifyError returns the ProviderError type for a failed request
 func classifyError(err error, response *Response) string {
-	if err != nil {
-		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline exceeded") {
-			return "timeout"
-		}
-		if strings.Contains(err.Error(), "connection") || strings.Contains(err.Error(), "network") {
-			return "network"
-		}
-	}
-	if response != nil && response.Error != nil {
-		if strings.Contains(response.Error.Type, "rate_limit") ||
-			strings.Contains(response.Error.Message, "rate limit") ||
-			strings.Contains(response.Error.Message, "429") {
-			return "rate_limit"
-		}
-		return "server_error"
-	}
-	return "unknown"
-}
-
-func combineErrors(err error, response *Response) error {
-	if err != nil && response != nil && response.Error != nil {
-		return fmt.Errorf("%v: %s", err, response.Error.Message)
-	}
-	if err != nil {
-		return err
+	if perr := toProviderError("", err, response); perr != nil {
+		return perr.Type
 	}
-	if response != nil && response.Error != nil {
-		return fmt.Errorf(response.Error.Message)
-	}
-	return fmt.Errorf("unknown error")
+	return ErrTypeUnknown
 }
 
 func main() {
@@ -367,13 +436,16 @@ func main() {
 		godotenv.Load(".env")
 	}
 
+	loadStructuredFlags()
+	loadOutputFlags()
+
 	useGopass = os.Getenv("USE_GOPASS") == "true"
 	streamingEnabled = os.Getenv("STREAMING") != "false" // Default to true if not set or set to true
 
 	if streamingEnabled {
-		fmt.Println("✅ Streaming mode enabled (chunk by chunk response)")
+		fmt.Fprintln(statusOut, "✅ Streaming mode enabled (chunk by chunk response)")
 	} else {
-		fmt.Println("📦 Single response mode (no streaming)")
+		fmt.Fprintln(statusOut, "📦 Single response mode (no streaming)")
 	}
 
 	// Check for --no-streaming flag
@@ -389,25 +461,34 @@ func main() {
 
 	if noStreaming {
 		streamingEnabled = false
-		fmt.Println("📦 Streaming disabled for this request")
+		fmt.Fprintln(statusOut, "📦 Streaming disabled for this request")
 	}
 
+	loadGenerationFlags()
+	loadPersonaFlags()
+	loadCacheFlags()
+	loadToolFlags()
+	loadImageFlags()
+	loadRenderFlags()
+
 	if err := loadProviderConfig(); err != nil {
-		fmt.Printf("Warning: Failed to load provider config: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load provider config: %v\n", err)
 	}
 
-	initProviders()
+	// Stored provider keys are decrypted with the security manager's key
 	securityMgr = initSecurityManager()
+	initProviders()
 	loadRAGIndex()
 	loadChatHistory()
 
 	dataDir := filepath.Join(homeDir, ".local", "share", "terminal-ai")
 	if err := InitEncryptedMemoryManager(dataDir); err != nil {
-		fmt.Printf("Warning: Failed to initialize memory manager: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Failed to initialize memory manager: %v\n", err)
 	}
 
 	InitAutoMemoryExtractor()
 
+	startOutput(os.Args)
 	if len(os.Args) < 2 {
 		showHelp()
 		os.Exit(0)
@@ -438,10 +519,18 @@ func main() {
 		handleHistoryCommand()
 	case "memory":
 		handleMemoryCommand()
+	case "usage":
+		handleUsageCommand()
+	case "persona":
+		handlePersonaCommand()
+	case "compare":
+		handleCompareCommand()
+	case "cache":
+		handleCacheCommand()
 	case "--help", "-h":
 		showHelp()
 	default:
-		if cmd == "openrouter" || cmd == "gemini" || cmd == "groq" {
+		if _, isProvider := providers[cmd]; isProvider {
 			provider := cmd
 			message := strings.Join(os.Args[2:], " ")
 			if message == "" {
@@ -449,7 +538,7 @@ func main() {
 				content, _ := io.ReadAll(reader)
 				message = strings.TrimSpace(string(content))
 			}
-			chatWithAI(provider, message)
+			chatWithAI(provider, message, cliImages)
 		} else {
 			message := strings.Join(os.Args[1:], " ")
 			if message == "" {
@@ -457,32 +546,71 @@ func main() {
 				content, _ := io.ReadAll(reader)
 				message = strings.TrimSpace(string(content))
 			}
-			chatWithAI("openrouter", message)
+			chatWithAI(personaProvider("openrouter"), message, cliImages)
 		}
 	}
 }
 
+// initProviders builds the provider map from providers.json, so providers
+// added with `provider add` exist on every start.
 func initProviders() {
-	providers = map[string]AIProvider{
-		"openrouter": {
-			Name:     "openrouter",
-			APIKey:   getEnvOrGopass("OPENROUTER_API_KEY", "terminal-ai/openrouter_api_key"),
-			Endpoint: os.Getenv("OPENROUTER_ENDPOINT"),
-			Model:    os.Getenv("OPENROUTER_MODEL"),
-		},
-		"gemini": {
-			Name:     "gemini",
-			APIKey:   getEnvOrGopass("GEMINI_API_KEY", "terminal-ai/gemini_api_key"),
-			Endpoint: os.Getenv("GEMINI_ENDPOINT"),
-			Model:    os.Getenv("GEMINI_MODEL"),
-		},
-		"groq": {
-			Name:     "groq",
-			APIKey:   getEnvOrGopass("GROQ_API_KEY", "terminal-ai/groq_api_key"),
-			Endpoint: os.Getenv("GROQ_ENDPOINT"),
-			Model:    os.Getenv("GROQ_MODEL"),
-		},
+	configs := providerConfig.Providers
+	if len(configs) == 0 {
+		configs = defaultProviderConfig().Providers
+	}
+
+	providers = map[string]AIProvider{}
+	for name, config := range configs {
+		providers[name] = buildProvider(name, config)
+	}
+}
+
+// buildProvider resolves a provider's endpoint, model and API key. The
+// environment wins over values stored in the config; the key falls back to
+// gopass and then to the encrypted secrets file.
+func buildProvider(name string, config AIProviderConfig) AIProvider {
+	provider := AIProvider{
+		Name:     name,
+		Type:     resolveProviderType(name, config),
+		Endpoint: config.Endpoint,
+		Model:    config.Model,
+	}
+	if config.EndpointKey != "" && os.Getenv(config.EndpointKey) != "" {
+		provider.Endpoint = os.Getenv(config.EndpointKey)
+	}
+	if config.ModelKey != "" && os.Getenv(config.ModelKey) != "" {
+		provider.Model = os.Getenv(config.ModelKey)
+	}
+	if config.EnvKey != "" || config.GopassKey != "" {
+		provider.APIKey = getEnvOrGopass(config.EnvKey, config.GopassKey)
+	}
+	if provider.APIKey == "" {
+		provider.APIKey = storedProviderSecret(name)
+	}
+	return provider
+}
+
+// saveCustomProvider stores a provider added from the CLI or the web API:
+// endpoint and model in providers.json, the API key through
+// storeProviderSecret.
+func saveCustomProvider(name string, config AIProviderConfig, apiKey string) error {
+	if apiKey != "" {
+		if err := storeProviderSecret(name, config, apiKey); err != nil {
+			return fmt.Errorf("failed to store API key: %w", err)
+		}
+	}
+
+	providerConfig.Providers[name] = config
+	if err := saveProviderConfig(); err != nil {
+		return err
 	}
+
+	provider := buildProvider(name, config)
+	if provider.APIKey == "" {
+		provider.APIKey = apiKey
+	}
+	providers[name] = provider
+	return nil
 }
 
 func getDataDir() string {
@@ -569,13 +697,24 @@ func createSession(title, provider, user string) *ChatSession {
 }
 
 func updateSession(sessionID, role, content string) error {
+	return appendSessionMessage(sessionID, ChatMessage{Role: role, Content: content})
+}
+
+// appendSessionMessage stores a message with all its metadata, filling in the
+// timestamp when it is not set.
+func appendSessionMessage(sessionID string, message ChatMessage) error {
+	if message.Timestamp == "" {
+		message.Timestamp = time.Now().Format(time.RFC3339)
+	}
+	if len(message.Attachments) > 0 {
+		stored, err := storeAttachments(message.Attachments)
+		if err != nil {
+			return err
+		}
+		message.Attachments = stored
+	}
 	for i := range chatHistory.Sessions {
 		if chatHistory.Sessions[i].ID == sessionID {
-			message := ChatMessage{
-				Role:      role,
-				Content:   content,
-				Timestamp: time.Now().Format(time.RFC3339),
-			}
 			chatHistory.Sessions[i].Messages = append(chatHistory.Sessions[i].Messages, message)
 			chatHistory.Sessions[i].UpdatedAt = time.Now().Format(time.RFC3339)
 			return saveChatHistory()
@@ -584,6 +723,51 @@ func updateSession(sessionID, role, content string) error {
 	return fmt.Errorf("session not found")
 }
 
+// setSessionMessages replaces the conversation of a session, used to undo or
+// edit earlier turns. A summary covering removed messages is dropped.
+func setSessionMessages(sessionID string, messages []ChatMessage) error {
+	for i := range chatHistory.Sessions {
+		if chatHistory.Sessions[i].ID == sessionID {
+			if chatHistory.Sessions[i].SummarizedCount > len(messages) {
+				chatHistory.Sessions[i].Summary = ""
+				chatHistory.Sessions[i].SummarizedCount = 0
+			}
+			chatHistory.Sessions[i].Messages = messages
+			chatHistory.Sessions[i].UpdatedAt = time.Now().Format(time.RFC3339)
+			return saveChatHistory()
+		}
+	}
+	return fmt.Errorf("session not found")
+}
+
+// saveSessionSummary stores the running summary of the first count messages
+func saveSessionSummary(sessionID, summary string, count int) error {
+	for i := range chatHistory.Sessions {
+		if chatHistory.Sessions[i].ID == sessionID {
+			chatHistory.Sessions[i].Summary = summary
+			chatHistory.Sessions[i].SummarizedCount = count
+			return saveChatHistory()
+		}
+	}
+	return fmt.Errorf("session not found")
+}
+
+// saveSessionSettings stores the provider, system prompt, persona, model and
+// generation parameters of a session
+func saveSessionSettings(session *ChatSession) error {
+	for i := range chatHistory.Sessions {
+		if chatHistory.Sessions[i].ID == session.ID {
+			chatHistory.Sessions[i].Provider = session.Provider
+			chatHistory.Sessions[i].System = session.System
+			chatHistory.Sessions[i].Persona = session.Persona
+			chatHistory.Sessions[i].Model = session.Model
+			chatHistory.Sessions[i].Params = session.Params
+			return saveChatHistory()
+		}
+	}
+	return fmt.Errorf("session not found")
+}
+
 func getSession(sessionID string) (*ChatSession, error) {
 	for i := range chatHistory.Sessions {
 		if chatHistory.Sessions[i].ID == sessionID {
@@ -651,7 +835,7 @@ func fetchWebContent(url string) {
 
 func handleRAGCommand() {
 	if len(os.Args) < 3 {
-		fmt.Println("Usage: terminal-ai rag index <dir> | terminal-ai rag search <query>")
+		fmt.Fprintln(os.Stderr, "Usage: terminal-ai rag index <dir> | terminal-ai rag search <query>")
 		os.Exit(1)
 	}
 
@@ -660,31 +844,49 @@ func handleRAGCommand() {
 	switch subCmd {
 	case "index":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai rag index <dir>")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai rag index <dir>")
 			os.Exit(1)
 		}
 		indexDirectory(os.Args[3])
 	case "search":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai rag search <query>")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai rag search <query>")
 			os.Exit(1)
 		}
-		results := searchRAG(os.Args[3])
-		if len(results) == 0 {
-			fmt.Println("No results found")
+		matches := rankRAGDocuments(os.Args[3], "", "")
+		if len(matches) > 3 {
+			matches = matches[:3]
+		}
+		if jsonOutput {
+			results := []RAGSearchResult{}
+			for _, match := range matches {
+				results = append(results, RAGSearchResult{
+					Path:       match.Doc.Path,
+					Score:      match.Score,
+					Owner:      match.Doc.Owner,
+					Visibility: match.Doc.Visibility,
+					IndexedAt:  match.Doc.IndexedAt,
+					Content:    match.Doc.Content,
+				})
+			}
+			writeJSON(results)
+			return
+		}
+		if len(matches) == 0 {
+			fmt.Fprintln(statusOut, "No results found")
 		} else {
-			fmt.Printf("🔍 Found %d result(s):\n\n", len(results))
-			for i, doc := range results {
-				fmt.Printf("%d. %s\n", i+1, doc.Path)
-				contentPreview := doc.Content
+			fmt.Fprintf(statusOut, "🔍 Found %d result(s):\n\n", len(matches))
+			for i, match := range matches {
+				fmt.Fprintf(resultOut, "%d. %s\n", i+1, match.Doc.Path)
+				contentPreview := match.Doc.Content
 				if len(contentPreview) > 100 {
 					contentPreview = contentPreview[:100] + "..."
 				}
-				fmt.Printf("   %s\n\n", contentPreview)
+				fmt.Fprintf(resultOut, "   %s\n\n", contentPreview)
 			}
 		}
 	default:
-		fmt.Println("Unknown RAG command. Use: index | search")
+		fmt.Fprintln(os.Stderr, "Unknown RAG command. Use: index | search")
 	}
 }
 
@@ -742,17 +944,34 @@ func indexDirectoryWithOwner(dir, owner, visibility string) {
 	fmt.Printf("✅ Indexed %d documents (owner: %s, visibility: %s)\n", count, owner, visibility)
 }
 
-func searchRAG(query string) []RAGDocument {
-	return searchRAGWithFilters(query, "", "")
+func searchRAGWithFilters(query, username, visibility string) []RAGDocument {
+	var results []RAGDocument
+	for _, match := range rankRAGDocuments(query, username, visibility) {
+		if len(results) == 3 {
+			break
+		}
+		results = append(results, match.Doc)
+	}
+	return results
+}
+
+// RAGMatch is a document with the number of query words among its keywords.
+// Score is that count relative to the shorter of the query and the keyword
+// list, so 1.0 means one fully covers the other.
+type RAGMatch struct {
+	Doc     RAGDocument
+	Matches int
+	Score   float64
 }
 
-func searchRAGWithFilters(query, username, visibility string) []RAGDocument {
-	queryWords := tokenize(query)
-	type scoreDoc struct {
-		doc   RAGDocument
-		score int
+// rankRAGDocuments returns the documents the user may read that share a
+// keyword with the query, best first
+func rankRAGDocuments(query, username, visibility string) []RAGMatch {
+	queryWords := map[string]bool{}
+	for _, word := range tokenize(query) {
+		queryWords[strings.ToLower(word)] = true
 	}
-	var scored []scoreDoc
+	var scored []RAGMatch
 
 	for _, doc := range ragIndex.Documents {
 		canAccess := false
@@ -773,38 +992,34 @@ func searchRAGWithFilters(query, username, visibility string) []RAGDocument {
 			continue
 		}
 
-		score := 0
 		docKeywords := make(map[string]bool)
 		for _, kw := range doc.Keywords {
 			docKeywords[strings.ToLower(kw)] = true
 		}
 
-		for _, qw := range queryWords {
-			if docKeywords[strings.ToLower(qw)] {
-				score++
+		matches := 0
+		for qw := range queryWords {
+			if docKeywords[qw] {
+				matches++
 			}
 		}
 
-		if score > 0 {
-			scored = append(scored, scoreDoc{doc, score})
+		if matches > 0 {
+			scored = append(scored, RAGMatch{
+				Doc:     doc,
+				Matches: matches,
+				Score:   float64(matches) / float64(min(len(queryWords), len(docKeywords))),
+			})
 		}
 	}
 
-	sort.Slice(scored, func(i, j int) bool {
-		return scored[i].score > scored[j].score
+	sort.SliceStable(scored, func(i, j int) bool {
+		if scored[i].Matches != scored[j].Matches {
+			return scored[i].Matches > scored[j].Matches
+		}
+		return scored[i].Score > scored[j].Score
 	})
-
-	var results []RAGDocument
-	maxResults := 3
-	if len(scored) < maxResults {
-		maxResults = len(scored)
-	}
-
-	for i := 0; i < maxResults; i++ {
-		results = append(results, scored[i].doc)
-	}
-
-	return results
+	return scored
 }
 
 func extractKeywords(text string) []string {
@@ -950,7 +1165,10 @@ func handleUserCommand() {
 		fmt.Print("Password: ")
 		var password string
 		fmt.Scanln(&password)
-		securityMgr.CreateUser(os.Args[3], password, os.Args[4])
+		if err := securityMgr.CreateUser(os.Args[3], password, os.Args[4]); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Printf("✅ User '%s' created\n", os.Args[3])
 	case "delete":
 		if len(os.Args) < 4 {
@@ -1024,18 +1242,37 @@ func handleProviderCommand() {
 			os.Exit(1)
 		}
 		setDefaultProvider(os.Args[3])
+	case "reset":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: terminal-ai provider reset <provider-name>")
+			os.Exit(1)
+		}
+		if err := resetProviderHealth(os.Args[3]); err != nil {
+			fmt.Printf("❌ Failed to reset health: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Health stats cleared and circuit closed for '%s'\n", os.Args[3])
 	case "byok":
+		// handleBYOKCommand reads its sub-command from os.Args[2]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
 		handleBYOKCommand()
+	case "models":
+		listModelsCLI()
 	default:
 		showProviderHelp()
 	}
 }
 
 func listProviders() {
-	fmt.Println("📊 Provider Configuration:")
-	fmt.Println()
-
 	orderedProviders := getOrderedProviders()
+	if jsonOutput {
+		listProvidersJSON(orderedProviders)
+		return
+	}
+
+	out := resultOut
+	fmt.Fprintln(out, "📊 Provider Configuration:")
+	fmt.Fprintln(out)
 
 	for i, providerName := range orderedProviders {
 		config := providerConfig.Providers[providerName]
@@ -1051,19 +1288,58 @@ func listProviders() {
 			defaultMarker = " (DEFAULT)"
 		}
 
-		fmt.Printf("%d. %s%s\n", i+1, providerName, defaultMarker)
-		fmt.Printf("   Priority: %d | %s\n", config.Priority, status)
-		fmt.Printf("   Endpoint: %s\n", provider.Endpoint)
-		fmt.Printf("   Model: %s\n", provider.Model)
+		fmt.Fprintf(out, "%d. %s%s\n", i+1, providerName, defaultMarker)
+		fmt.Fprintf(out, "   Priority: %d | %s\n", config.Priority, status)
+		fmt.Fprintf(out, "   Type: %s\n", provider.Type)
+		fmt.Fprintf(out, "   Endpoint: %s\n", provider.Endpoint)
+		fmt.Fprintf(out, "   Model: %s\n", provider.Model)
 		if config.BYOK {
-			fmt.Printf("   🔐 BYOK: Custom provider\n")
+			fmt.Fprintf(out, "   🔐 BYOK: Custom provider\n")
+		}
+		if params := providerParams(providerName); !params.isZero() {
+			fmt.Fprintf(out, "   Params: %s\n", params)
+		}
+		policy := retryPolicyFor(providerName)
+		fmt.Fprintf(out, "   Max Retries: %d (backoff %dms x%.1f, max %dms, jitter %.0f%%)\n",
+			config.MaxRetries, policy.InitialDelayMs, policy.Multiplier, policy.MaxDelayMs, policy.Jitter*100)
+		if health := getProviderHealth(providerName); len(health) > 0 {
+			fmt.Fprintln(out, "   Health:")
+			for _, h := range health {
+				fmt.Fprintf(out, "     %s\n", formatHealth(h))
+			}
 		}
-		fmt.Printf("   Max Retries: %d\n", config.MaxRetries)
-		fmt.Println()
+		fmt.Fprintln(out)
 	}
 
-	fmt.Printf("Fallback Enabled: %v\n", providerConfig.FallbackEnabled)
-	fmt.Printf("Default Provider: %s\n", providerConfig.DefaultProvider)
+	fmt.Fprintf(out, "Fallback Enabled: %v\n", providerConfig.FallbackEnabled)
+	fmt.Fprintf(out, "Default Provider: %s\n", providerConfig.DefaultProvider)
+}
+
+func listProvidersJSON(names []string) {
+	result := struct {
+		Default         string           `json:"default"`
+		FallbackEnabled bool             `json:"fallback_enabled"`
+		Providers       []ProviderStatus `json:"providers"`
+	}{Default: providerConfig.DefaultProvider, FallbackEnabled: providerConfig.FallbackEnabled, Providers: []ProviderStatus{}}
+
+	for _, name := range names {
+		config := providerConfig.Providers[name]
+		provider := providers[name]
+		result.Providers = append(result.Providers, ProviderStatus{
+			Name:     name,
+			Type:     provider.Type,
+			Endpoint: provider.Endpoint,
+			Model:    provider.Model,
+			Priority: config.Priority,
+			Enabled:  config.Enabled,
+			Default:  name == providerConfig.DefaultProvider,
+			Ready:    providerReady(provider),
+			BYOK:     config.BYOK,
+			Params:   config.Params,
+			Health:   getProviderHealth(name),
+		})
+	}
+	writeJSON(result)
 }
 
 func testProvider(providerName string) {
@@ -1084,7 +1360,7 @@ func testProvider(providerName string) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		fmt.Printf("❌ No API key configured for %s\n", providerName)
 		return
 	}
@@ -1102,7 +1378,10 @@ func testProvider(providerName string) {
 		Stream: false,
 	}
 
-	response, err := makeRequest(provider.Endpoint, provider.APIKey, req, provider.Name)
+	ctx, stop := interruptibleContext()
+	defer stop()
+
+	response, err := makeRequest(ctx, providerName, req)
 
 	if err != nil {
 		fmt.Printf("❌ Test failed: %v\n", err)
@@ -1175,6 +1454,17 @@ func addProvider(providerName string) {
 	priority := 1
 	fmt.Sscanf(strings.TrimSpace(priorityStr), "%d", &priority)
 
+	fmt.Print("Type (openai/openrouter/gemini/anthropic/ollama/local) [openai]: ")
+	providerType, _ := reader.ReadString('\n')
+	providerType = strings.TrimSpace(providerType)
+	if providerType == "" {
+		providerType = "openai"
+	}
+	if _, ok := providerFactories[providerType]; !ok {
+		fmt.Printf("❌ Unknown provider type: %s\n", providerType)
+		return
+	}
+
 	fmt.Print("Endpoint URL: ")
 	endpoint, _ := reader.ReadString('\n')
 	endpoint = strings.TrimSpace(endpoint)
@@ -1183,42 +1473,59 @@ func addProvider(providerName string) {
 	model, _ := reader.ReadString('\n')
 	model = strings.TrimSpace(model)
 
-	fmt.Print("API Key (or leave blank to use gopass): ")
-	apiKey, _ := reader.ReadString('\n')
-	apiKey = strings.TrimSpace(apiKey)
-
-	config := AIProviderConfig{
-		Priority:    priority,
-		Enabled:     true,
-		MaxRetries:  2,
-		EnvKey:      strings.ToUpper(providerName) + "_API_KEY",
-		EndpointKey: strings.ToUpper(providerName) + "_ENDPOINT",
-		ModelKey:    strings.ToUpper(providerName) + "_MODEL",
-		BYOK:        true,
-		Description: "Custom BYOK provider",
-		GopassKey:   "terminal-ai/" + providerName + "_api_key",
+	apiKey := ""
+	if !keylessProviderTypes[providerType] {
+		fmt.Print("API Key (or leave blank to use gopass): ")
+		apiKey, _ = reader.ReadString('\n')
+		apiKey = strings.TrimSpace(apiKey)
 	}
 
-	providerConfig.Providers[providerName] = config
+	config := customProviderConfig(providerName, providerType, priority, endpoint, model)
 
-	providers[providerName] = AIProvider{
-		Name:     providerName,
-		APIKey:   apiKey,
-		Endpoint: endpoint,
-		Model:    model,
+	// The model check needs the provider registered with its key
+	provider := buildProvider(providerName, config)
+	if apiKey != "" {
+		provider.APIKey = apiKey
 	}
+	providers[providerName] = provider
 
-	if err := saveProviderConfig(); err != nil {
+	if !confirmModel(providerName, model) {
+		delete(providers, providerName)
+		return
+	}
+
+	if err := saveCustomProvider(providerName, config, apiKey); err != nil {
+		delete(providers, providerName)
 		fmt.Printf("❌ Failed to add provider: %v\n", err)
 		return
 	}
 
 	fmt.Printf("✅ Provider '%s' added successfully\n", providerName)
+	fmt.Printf("   Type: %s\n", config.Type)
 	fmt.Printf("   Priority: %d\n", config.Priority)
 	fmt.Printf("   Endpoint: %s\n", endpoint)
 	fmt.Printf("   Model: %s\n", model)
 }
 
+// customProviderConfig is the config of a provider added at runtime. Its
+// environment variables are still honoured, e.g. MYPROVIDER_API_KEY.
+func customProviderConfig(name, providerType string, priority int, endpoint, model string) AIProviderConfig {
+	return AIProviderConfig{
+		Type:        providerType,
+		Priority:    priority,
+		Enabled:     true,
+		MaxRetries:  2,
+		EnvKey:      strings.ToUpper(name) + "_API_KEY",
+		EndpointKey: strings.ToUpper(name) + "_ENDPOINT",
+		ModelKey:    strings.ToUpper(name) + "_MODEL",
+		Endpoint:    endpoint,
+		Model:       model,
+		BYOK:        true,
+		Description: "Custom BYOK provider",
+		GopassKey:   "terminal-ai/" + name + "_api_key",
+	}
+}
+
 func setDefaultProvider(providerName string) {
 	_, exists := providerConfig.Providers[providerName]
 	if !exists {
@@ -1362,6 +1669,10 @@ func addBYOKProviderCLI(providerName, model string) {
 		}
 	}
 
+	if !confirmModel("openrouter", model) {
+		return
+	}
+
 	// Add to order
 	openrouterConfig.BYOKConfig.ProviderOrder = append(
 		openrouterConfig.BYOKConfig.ProviderOrder,
@@ -1558,39 +1869,19 @@ func testBYOKCLI() {
 		Stream: false,
 	}
 
-	openRouterReq := OpenRouterRequest{
-		Model:    req.Model,
-		Messages: req.Messages,
-		Stream:   req.Stream,
-		Provider: &OpenRouterProvider{
-			AllowFallbacks: openrouterConfig.BYOKConfig.AllowFallbackToShared,
-			Order:          openrouterConfig.BYOKConfig.ProviderOrder,
-		},
-	}
-
-	reqBody, _ := json.Marshal(openRouterReq)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	httpReq, _ := http.NewRequest("POST", provider.Endpoint, strings.NewReader(string(reqBody)))
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+provider.APIKey)
-	httpReq.Header.Set("HTTP-Referer", "https://terminal-ai.local")
-	httpReq.Header.Set("X-Title", "Terminal AI CLI")
-
 	fmt.Printf("🔄 Testing with BYOK order: %v\n", openrouterConfig.BYOKConfig.ProviderOrder)
 	fmt.Println()
 
-	resp, err := client.Do(httpReq)
+	interruptCtx, stop := interruptibleContext()
+	defer stop()
+	ctx, cancel := context.WithTimeout(interruptCtx, 30*time.Second)
+	defer cancel()
+
+	response, err := newOpenRouterProvider(provider).Chat(ctx, req)
 	if err != nil {
 		fmt.Printf("❌ Test failed: %v\n", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var response Response
-	json.Unmarshal(body, &response)
 
 	if response.Error != nil {
 		fmt.Printf("❌ API Error: %s\n", response.Error.Message)
@@ -1634,6 +1925,10 @@ func setBYOKModel(providerName, model string) {
 		return
 	}
 
+	if !confirmModel("openrouter", model) {
+		return
+	}
+
 	// Update model
 	if openrouterConfig.BYOKConfig.Models == nil {
 		openrouterConfig.BYOKConfig.Models = make(map[string]string)
@@ -1730,6 +2025,9 @@ func showProviderHelp() {
 	fmt.Println("  terminal-ai provider priority <provider> <n>   - Set provider priority (0=highest)")
 	fmt.Println("  terminal-ai provider add <provider>            - Add a new custom provider")
 	fmt.Println("  terminal-ai provider default <provider>        - Set default provider")
+	fmt.Println("  terminal-ai provider reset <provider>          - Clear health stats and close the circuit breaker")
+	fmt.Println("  terminal-ai provider models <provider> [--filter <text>] [--refresh]")
+	fmt.Println("                                                 - List the provider's models with context length and pricing")
 	fmt.Println()
 	fmt.Println("OpenRouter BYOK Commands:")
 	fmt.Println("  terminal-ai provider byok enable               - Enable BYOK mode")
@@ -1742,6 +2040,7 @@ func showProviderHelp() {
 	fmt.Println("Examples:")
 	fmt.Println("  terminal-ai provider list")
 	fmt.Println("  terminal-ai provider test openrouter")
+	fmt.Println("  terminal-ai provider models openrouter --filter claude")
 	fmt.Println("  terminal-ai provider byok enable")
 	fmt.Println("  terminal-ai provider byok add SambaNova sambanova/llama-3.2")
 	fmt.Println("  terminal-ai provider byok order Cerebras,SambaNova,Groq")
@@ -1750,7 +2049,7 @@ func showProviderHelp() {
 
 func handleChatCommand() {
 	if len(os.Args) < 3 {
-		fmt.Println("Usage: terminal-ai chat --list | chat --new [message] | chat --last [message] | chat --session <id> [message]")
+		fmt.Fprintln(os.Stderr, "Usage: terminal-ai chat --list | chat --new [message] | chat --last [message] | chat --session <id> [message]")
 		os.Exit(1)
 	}
 
@@ -1773,7 +2072,7 @@ func handleChatCommand() {
 		startLastSession(message)
 	case "--session":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai chat --session <id> [message]")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai chat --session <id> [message]")
 			os.Exit(1)
 		}
 		sessionID := os.Args[3]
@@ -1783,13 +2082,13 @@ func handleChatCommand() {
 		}
 		startSession(sessionID, message)
 	default:
-		fmt.Println("Unknown chat flag. Use: --list | --new | --last | --session")
+		fmt.Fprintln(os.Stderr, "Unknown chat flag. Use: --list | --new | --last | --session")
 	}
 }
 
 func handleHistoryCommand() {
 	if len(os.Args) < 3 {
-		fmt.Println("Usage: terminal-ai history list | history view <id> | history export <id> [filename] [--format txt|md] | history delete <id> | history clear")
+		fmt.Fprintln(os.Stderr, "Usage: terminal-ai history list | history view <id> | history export <id> [filename] [--format txt|md] | history delete <id> | history clear")
 		os.Exit(1)
 	}
 
@@ -1800,13 +2099,13 @@ func handleHistoryCommand() {
 		listSessionsCLI()
 	case "view":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai history view <id>")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai history view <id>")
 			os.Exit(1)
 		}
 		viewSessionCLI(os.Args[3])
 	case "export":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai history export <id> [filename] [--format txt|md]")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai history export <id> [filename] [--format txt|md]")
 			os.Exit(1)
 		}
 		sessionID := os.Args[3]
@@ -1823,69 +2122,100 @@ func handleHistoryCommand() {
 		exportSession(sessionID, filename, format)
 	case "delete":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai history delete <id>")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai history delete <id>")
 			os.Exit(1)
 		}
 		deleteSessionCLI(os.Args[3])
 	case "clear":
 		clearHistoryCLI()
 	default:
-		fmt.Println("Unknown history command. Use: list | view | export | delete | clear")
+		fmt.Fprintln(os.Stderr, "Unknown history command. Use: list | view | export | delete | clear")
 	}
 }
 
 func listSessionsCLI() {
 	sessions := listSessions()
+	if jsonOutput {
+		summaries := []SessionSummary{}
+		for _, session := range sessions {
+			summaries = append(summaries, SessionSummary{
+				ID:        session.ID,
+				Title:     session.Title,
+				Provider:  session.Provider,
+				Model:     session.Model,
+				Messages:  len(session.Messages),
+				CreatedAt: session.CreatedAt,
+				UpdatedAt: session.UpdatedAt,
+			})
+		}
+		writeJSON(summaries)
+		return
+	}
 	if len(sessions) == 0 {
-		fmt.Println("📚 No chat sessions found")
+		fmt.Fprintln(statusOut, "📚 No chat sessions found")
 		return
 	}
 
-	fmt.Printf("📚 Chat History:\n\n")
+	fmt.Fprintf(statusOut, "📚 Chat History:\n\n")
 	for i, session := range sessions {
-		fmt.Printf("%d. %s\n", i+1, session.Title)
-		fmt.Printf("   ID: %s\n", session.ID)
-		fmt.Printf("   Provider: %s\n", session.Provider)
-		fmt.Printf("   Messages: %d\n", len(session.Messages))
-		fmt.Printf("   Created: %s\n", session.CreatedAt)
-		fmt.Printf("   Updated: %s\n\n", session.UpdatedAt)
+		fmt.Fprintf(resultOut, "%d. %s\n", i+1, session.Title)
+		fmt.Fprintf(resultOut, "   ID: %s\n", session.ID)
+		fmt.Fprintf(resultOut, "   Provider: %s\n", session.Provider)
+		fmt.Fprintf(resultOut, "   Messages: %d\n", len(session.Messages))
+		fmt.Fprintf(resultOut, "   Created: %s\n", session.CreatedAt)
+		fmt.Fprintf(resultOut, "   Updated: %s\n\n", session.UpdatedAt)
 	}
 }
 
 func viewSessionCLI(sessionID string) {
 	session, err := getSession(sessionID)
 	if err != nil {
-		fmt.Printf("❌ Session not found: %s\n", sessionID)
+		fmt.Fprintf(os.Stderr, "❌ Session not found: %s\n", sessionID)
+		os.Exit(1)
+	}
+	if jsonOutput {
+		writeJSON(session)
 		return
 	}
 
-	fmt.Printf("📖 %s\n", session.Title)
-	fmt.Printf("   ID: %s\n", session.ID)
-	fmt.Printf("   Provider: %s\n", session.Provider)
-	fmt.Printf("   Created: %s\n\n", session.CreatedAt)
+	out := resultOut
+	fmt.Fprintf(out, "📖 %s\n", session.Title)
+	fmt.Fprintf(out, "   ID: %s\n", session.ID)
+	fmt.Fprintf(out, "   Provider: %s\n", session.Provider)
+	if session.Persona != "" {
+		fmt.Fprintf(out, "   Persona: %s\n", session.Persona)
+	}
+	if session.System != "" {
+		fmt.Fprintf(out, "   System: %s\n", truncate(session.System, 80))
+	}
+	fmt.Fprintf(out, "   Created: %s\n\n", session.CreatedAt)
 
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintln(out, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
 	for _, msg := range session.Messages {
 		if msg.Role == "user" {
-			fmt.Printf("\n👤 User:\n%s\n", msg.Content)
+			fmt.Fprintf(out, "\n👤 User:\n%s\n", msg.Content)
+		} else if msg.Params != nil {
+			fmt.Fprintf(out, "\n🤖 AI (%s):\n", msg.Params)
+			renderMarkdown(out, msg.Content)
 		} else {
-			fmt.Printf("\n🤖 AI:\n%s\n", msg.Content)
+			fmt.Fprintln(out, "\n🤖 AI:")
+			renderMarkdown(out, msg.Content)
 		}
 	}
 
-	fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintln(out, "\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 }
 
 func startNewSession(message string) {
-	fmt.Println("✨ Starting new chat session")
+	fmt.Fprintln(statusOut, "✨ Starting new chat session")
 	startREPLWithSession(nil, message)
 }
 
 func startLastSession(message string) {
 	session := getLatestSession()
 	if session == nil {
-		fmt.Println("⚠️  No previous session found. Starting new chat.")
+		fmt.Fprintln(statusOut, "⚠️  No previous session found. Starting new chat.")
 		startREPLWithSession(nil, message)
 		return
 	}
@@ -1895,246 +2225,226 @@ func startLastSession(message string) {
 func startSession(sessionID, message string) {
 	session, err := getSession(sessionID)
 	if err != nil {
-		fmt.Printf("❌ Session not found: %s\n", sessionID)
-		return
+		fmt.Fprintf(os.Stderr, "❌ Session not found: %s\n", sessionID)
+		os.Exit(1)
 	}
 	startREPLWithSession(session, message)
 }
 
-func startREPLWithSession(session *ChatSession, initialMessage string) {
-	providerName := providerConfig.DefaultProvider
-
-	if session == nil {
-		if initialMessage == "" {
-			fmt.Print(providerConfig.Prompts.InputMessage)
-			reader := bufio.NewReader(os.Stdin)
-			msg, _ := reader.ReadString('\n')
-			msg = strings.TrimSpace(msg)
-			if msg == "" {
-				fmt.Println(providerConfig.Prompts.MessageEmpty)
-				return
-			}
-			initialMessage = msg
-		}
+// sessionWithHistory answers the last user message of the session with the
+// whole conversation as context and saves the reply to the session. The
+// answer is written to resultOut; the result is what --json prints.
+func sessionWithHistory(session *ChatSession) ChatResult {
+	ctx, stop := interruptibleContext()
+	defer stop()
+	ctx = withUsageScope(ctx, session.User, session.ID)
 
-		fmt.Printf(providerConfig.Prompts.PrimaryProvider, providerName)
-		fmt.Printf(providerConfig.Prompts.FallbackPrompt, providerConfig.FallbackEnabled)
-
-		session = createSession(truncateTitle(initialMessage), providerName, "user")
-		if initialMessage != "" {
-			updateSession(session.ID, "user", initialMessage)
-		}
-	} else {
-		fmt.Printf(providerConfig.Prompts.LoadedSession, session.Title)
-		fmt.Printf(providerConfig.Prompts.LoadedMessages, len(session.Messages))
-		fmt.Printf(providerConfig.Prompts.LoadedProvider, session.Provider)
-		fmt.Println()
-		providerName = session.Provider
+	last := len(session.Messages) - 1
+	if last < 0 || session.Messages[last].Role != "user" {
+		return ChatResult{SessionID: session.ID, Error: "no message to answer"}
 	}
+	message := session.Messages[last].Content
+	attachments := session.Messages[last].Attachments
+	providerName := session.Provider
 
-	if initialMessage != "" && len(session.Messages) == 0 {
-		sessionWithHistory(session, providerName, initialMessage)
-	}
-
-	for {
-		fmt.Print(providerConfig.Prompts.ContinuePrompt)
-		reader := bufio.NewReader(os.Stdin)
-		answer, _ := reader.ReadString('\n')
-		answer = strings.TrimSpace(answer)
-
-		if strings.ToLower(answer) != strings.ToLower(providerConfig.Prompts.ContinueYes) {
-			fmt.Printf(providerConfig.Prompts.ChatSaved, session.ID)
-			return
-		}
-
-		fmt.Print(providerConfig.Prompts.InputMessage)
-		msg, _ := reader.ReadString('\n')
-		msg = strings.TrimSpace(msg)
-
-		if msg == "" {
-			continue
-		}
+	provider := providers[providerName]
 
-		sessionWithHistory(session, providerName, msg)
+	model := provider.Model
+	if session.Model != "" {
+		model = session.Model
 	}
-}
 
-func sessionWithHistory(session *ChatSession, providerName, message string) {
-	messages := []Message{{Role: "user", Content: message}}
-	for _, msg := range session.Messages {
-		if msg.Role == "user" || msg.Role == "assistant" {
-			messages = append(messages, Message{Role: msg.Role, Content: msg.Content})
-		}
+	injected := ContextAssembler{Memory: true, Model: model}.assemble(ctx, message)
+	if len(injected.Sources) > 0 {
+		fmt.Fprintf(statusOut, "📚 Context: %s\n", injected)
 	}
+	params := resolveParams(injected.Skills, session, cliParams)
+	plan := buildContext(ctx, session, providerName, model, params, injected)
+	printContextNotes(plan)
 
-	skills := findMatchingSkills(message)
-	finalMessage := message
-
-	if len(skills) > 0 {
-		for _, skill := range skills {
-			finalMessage = skill.Template + "\n\n" + finalMessage
-		}
+	req := Request{
+		Model:            model,
+		Messages:         plan.Messages,
+		Stream:           true, // Enable streaming for real-time response
+		Tools:            cliTools,
+		MaxSteps:         cliMaxSteps,
+		GenerationParams: params,
 	}
-
-	results := searchRAGWithFilters(message, "user", "")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		finalMessage += context
+	if !req.GenerationParams.isZero() {
+		fmt.Fprintf(statusOut, "🎛️  Params: %s\n", req.GenerationParams)
 	}
-
-	if mgr := GetEncryptedMemoryManager(); mgr != nil {
-		ctx := context.Background()
-		memoryResults, err := mgr.SearchAndDecrypt(ctx, message, 3)
-		if err == nil && len(memoryResults) > 0 {
-			context := "\n\nRelevant memories:\n"
-			for _, result := range memoryResults {
-				contentLen := len(result.Memory.Content)
-				if contentLen > 150 {
-					contentLen = 150
-				}
-				context += fmt.Sprintf("- %s\n", result.Memory.Content[:contentLen])
-			}
-			finalMessage += context
-		}
+	if len(req.Tools) > 0 {
+		fmt.Fprintf(statusOut, "🔧 Tools: %s\n", toolNames(req.Tools))
 	}
+	printAttachments(attachments)
 
-	provider := providers[providerName]
-
-	req := Request{
-		Model:    provider.Model,
-		Messages: messages,
-		Stream:   true, // Enable streaming for real-time response
+	result := ChatResult{Provider: providerName, Model: model, SessionID: session.ID, Sources: injected.Sources}
+	fail := func(format string, args ...interface{}) ChatResult {
+		result.Error = fmt.Sprintf(format, args...)
+		fmt.Fprintf(os.Stderr, "❌ %s\n", result.Error)
+		return result
 	}
 
 	var response *Response
 	var actualProvider string
 	var err error
-	var streamingErr error
-	var fullResponse string
 
 	if streamingEnabled {
 		// Use streaming mode
 		if providerConfig.FallbackEnabled {
-			fmt.Printf("🔄 Fallback enabled: %v\n", providerConfig.FallbackEnabled)
+			fmt.Fprintf(statusOut, "🔄 Fallback enabled: %v\n", providerConfig.FallbackEnabled)
 		}
-		fmt.Println("📝 Response (streaming):")
+		fmt.Fprintln(statusOut, "📝 Response (streaming):")
 
 		// For chat sessions with history, we need to capture the full response
 		// We'll use a modified approach that captures output for saving to history
-		streamingErr = makeStreamingRequestWithCapture(provider.Endpoint, provider.APIKey, req, provider.Name, &fullResponse)
-		actualProvider = providerName
-
-		if streamingErr != nil {
-			fmt.Printf("\n❌ Streaming Error: %v\n", streamingErr)
-			return
-		}
-
-		if fullResponse != "" {
-			updateSession(session.ID, "assistant", fullResponse)
+		var fullResponse string
+		response, actualProvider, err = makeStreamingRequestWithCapture(ctx, providerName, req, &fullResponse)
 
-			// Auto-extract dari EVERY conversation
-			if extractor := GetAutoMemoryExtractor(); extractor != nil {
-				fmt.Println("\n💾 Extracting important information...")
-				conversation := "User said: " + message + "\n\nAssistant responded: " + fullResponse
-				fmt.Printf("[DEBUG] Conversation length: %d\n", len(conversation))
-				count := ExtractAndSaveMemories(conversation, session.ID)
-				fmt.Printf("[DEBUG] Extracted %d memories\n", count)
-				if count > 0 {
-					fmt.Printf("✅ Saved %d memories\n", count)
+		if err != nil {
+			var interrupted *StreamInterruptedError
+			if errors.As(err, &interrupted) {
+				appendSessionMessage(session.ID, ChatMessage{
+					Role:        "assistant",
+					Content:     fullResponse,
+					Interrupted: true,
+					Provider:    interrupted.Provider,
+					Params:      withProviderParams(interrupted.Provider, req).GenerationParams.record(),
+					Context:     injected.Sources,
+				})
+				result.Answer, result.Provider, result.Interrupted = fullResponse, interrupted.Provider, true
+				if ctx.Err() != nil {
+					fmt.Fprintln(os.Stderr, "\n\n⏹️  Interrupted")
+					result.Error = "interrupted"
+				} else {
+					fmt.Fprintf(os.Stderr, "\n\n⚠️  %v\n", err)
+					result.Error = err.Error()
 				}
+				fmt.Fprintln(statusOut, "💾 Partial answer saved to the session, send \"continue\" to resume")
+				return result
 			}
+			if ctx.Err() != nil {
+				fmt.Fprintln(os.Stderr, "\n⏹️  Request cancelled")
+				result.Error = "cancelled"
+				return result
+			}
+			return fail("Streaming Error: %v", err)
+		}
+
+		if actualProvider != providerName {
+			fmt.Fprintf(statusOut, "📡 Response from fallback provider: %s\n", actualProvider)
 		}
+		result.Answer = fullResponse
 	} else {
 		// Use non-streaming mode
-		if providerConfig.FallbackEnabled {
-			response, actualProvider, err = makeRequestWithFallback(
-				provider.Endpoint, provider.APIKey, req, providerName,
-			)
-		} else {
-			response, err = makeRequest(provider.Endpoint, provider.APIKey, req, provider.Name)
-			actualProvider = providerName
-		}
+		response, actualProvider, err = runAgent(ctx, req, providerName, nil, nil)
 
 		if err != nil {
-			fmt.Printf("❌ Error: %v\n", err)
-			return
+			if ctx.Err() != nil {
+				fmt.Fprintln(os.Stderr, "\n⏹️  Request cancelled")
+				result.Error = "cancelled"
+				return result
+			}
+			return fail("Error: %v", err)
 		}
 
 		if response.Error != nil {
-			fmt.Printf("❌ API Error: %s\n", response.Error.Message)
-			return
+			return fail("API Error: %s", response.Error.Message)
+		}
+		if len(response.Choices) == 0 {
+			return fail("No response generated")
 		}
 
-		if len(response.Choices) > 0 {
-			if actualProvider != providerName {
-				fmt.Printf("📡 Response from fallback provider: %s\n", actualProvider)
-			} else {
-				fmt.Printf("✅ Success with provider: %s\n", actualProvider)
-			}
-			fmt.Println(response.Choices[0].Message.Content)
-			updateSession(session.ID, "assistant", response.Choices[0].Message.Content)
-
-			// Auto-extract dari EVERY conversation
-			if extractor := GetAutoMemoryExtractor(); extractor != nil {
-				fmt.Println("\n💾 Extracting important information...")
-				conversation := "User said: " + message + "\n\nAssistant responded: " + response.Choices[0].Message.Content
-				fmt.Printf("[DEBUG] Conversation length: %d\n", len(conversation))
-				count := ExtractAndSaveMemories(conversation, session.ID)
-				fmt.Printf("[DEBUG] Extracted %d memories\n", count)
-				if count > 0 {
-					fmt.Printf("✅ Saved %d memories\n", count)
-				}
-			}
+		if actualProvider != providerName {
+			fmt.Fprintf(statusOut, "📡 Response from fallback provider: %s\n", actualProvider)
+		} else {
+			fmt.Fprintf(statusOut, "✅ Success with provider: %s\n", actualProvider)
+		}
+		result.Answer = response.Choices[0].Message.Content
+		if !jsonOutput {
+			renderMarkdown(resultOut, result.Answer)
 		}
 	}
+
+	result.Provider = actualProvider
+	if actualProvider != providerName {
+		result.Model = providers[actualProvider].Model
+	}
+	if response != nil {
+		result.Usage = response.Usage
+	}
+	if result.Answer == "" {
+		return result
+	}
+
+	appendSessionMessage(session.ID, ChatMessage{
+		Role:     "assistant",
+		Content:  result.Answer,
+		Provider: actualProvider,
+		Params:   withProviderParams(actualProvider, req).GenerationParams.record(),
+		Context:  injected.Sources,
+	})
+
+	// Auto-extract dari EVERY conversation
+	if extractor := GetAutoMemoryExtractor(); extractor != nil {
+		fmt.Fprintln(statusOut, "\n💾 Extracting important information...")
+		conversation := "User said: " + message + "\n\nAssistant responded: " + result.Answer
+		count := ExtractAndSaveMemories(conversation, session.ID)
+		if count > 0 {
+			fmt.Fprintf(statusOut, "✅ Saved %d memories\n", count)
+		}
+	}
+	return result
 }
 
 func deleteSessionCLI(sessionID string) {
-	fmt.Printf("⚠️  Delete session '%s'? (y/n): ", sessionID)
+	fmt.Fprintf(os.Stderr, "⚠️  Delete session '%s'? (y/n): ", sessionID)
 	reader := bufio.NewReader(os.Stdin)
 	answer, _ := reader.ReadString('\n')
 	answer = strings.TrimSpace(answer)
 
 	if strings.ToLower(answer) == "y" {
 		if err := deleteSession(sessionID); err != nil {
-			fmt.Printf("❌ Failed to delete session: %v\n", err)
-		} else {
-			fmt.Printf("✅ Session '%s' deleted\n", sessionID)
+			fmt.Fprintf(os.Stderr, "❌ Failed to delete session: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(statusOut, "✅ Session '%s' deleted\n", sessionID)
+		if jsonOutput {
+			writeJSON(map[string]string{"deleted": sessionID})
 		}
 	}
 }
 
 func clearHistoryCLI() {
 	sessions := listSessions()
-	fmt.Printf("⚠️  This will delete all %d chat sessions. Continue? (y/n): ", len(sessions))
+	fmt.Fprintf(os.Stderr, "⚠️  This will delete all %d chat sessions. Continue? (y/n): ", len(sessions))
 	reader := bufio.NewReader(os.Stdin)
 	answer, _ := reader.ReadString('\n')
 	answer = strings.TrimSpace(answer)
 
 	if strings.ToLower(answer) == "y" {
 		if err := clearAllHistory(); err != nil {
-			fmt.Printf("❌ Failed to clear history: %v\n", err)
-		} else {
-			fmt.Println("✅ Chat history cleared")
+			fmt.Fprintf(os.Stderr, "❌ Failed to clear history: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(statusOut, "✅ Chat history cleared")
+		if jsonOutput {
+			writeJSON(map[string]int{"deleted": len(sessions)})
 		}
 	}
 }
 
+// exportSession writes the session to filename, or with -o and no filename
+// to the output file
 func exportSession(sessionID, filename, format string) {
 	session, err := getSession(sessionID)
 	if err != nil {
-		fmt.Printf("❌ Session not found: %s\n", sessionID)
-		return
+		fmt.Fprintf(os.Stderr, "❌ Session not found: %s\n", sessionID)
+		os.Exit(1)
 	}
 
-	if filename == "" {
+	toOutput := filename == "" && outputPath != ""
+	if filename == "" && !toOutput {
 		if format == "md" {
 			filename = fmt.Sprintf("%s.md", strings.Map(func(r rune) rune {
 				if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
@@ -2178,10 +2488,19 @@ func exportSession(sessionID, filename, format string) {
 		}
 	}
 
-	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
-		fmt.Printf("❌ Failed to export session: %v\n", err)
+	if toOutput {
+		filename = outputPath
+		_, err = io.WriteString(resultOut, content)
 	} else {
-		fmt.Printf("✅ Session exported to: %s\n", filename)
+		err = os.WriteFile(filename, []byte(content), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to export session: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(statusOut, "✅ Session exported to: %s\n", filename)
+	if jsonOutput && !toOutput {
+		writeJSON(map[string]string{"file": filename})
 	}
 }
 
@@ -2192,183 +2511,47 @@ func min(a, b int) int {
 	return b
 }
 
-func chatWithAI(providerName, message string) {
+func chatWithAI(providerName, message string, attachments []Attachment) {
 	if providerName == "" {
 		providerName = providerConfig.DefaultProvider
 	}
 
 	provider, exists := providers[providerName]
 	if !exists {
-		fmt.Printf("Unknown provider: %s\n", providerName)
+		fmt.Fprintf(os.Stderr, "Unknown provider: %s\n", providerName)
 		os.Exit(1)
 	}
 
-	if provider.APIKey == "" {
-		fmt.Printf("API key not configured for %s\n", providerName)
+	if !providerReady(provider) {
+		fmt.Fprintf(os.Stderr, "API key not configured for %s\n", providerName)
 		os.Exit(1)
 	}
 
-	skills := findMatchingSkills(message)
-	finalMessage := message
-
-	if len(skills) > 0 {
-		for _, skill := range skills {
-			finalMessage = skill.Template + "\n\n" + finalMessage
-		}
-	}
-
-	results := searchRAGWithFilters(message, "user", "")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		finalMessage += context
+	message, attachments, ok := withImageRefs(message, attachments)
+	if !ok {
+		os.Exit(1)
 	}
 
-	if mgr := GetEncryptedMemoryManager(); mgr != nil {
-		ctx := context.Background()
-		memoryResults, err := mgr.SearchAndDecrypt(ctx, message, 3)
-		if err == nil && len(memoryResults) > 0 {
-			context := "\n\nRelevant memories:\n"
-			for _, result := range memoryResults {
-				contentLen := len(result.Memory.Content)
-				if contentLen > 150 {
-					contentLen = 150
-				}
-				context += fmt.Sprintf("- %s\n", result.Memory.Content[:contentLen])
-			}
-			finalMessage += context
-		}
+	if cliResponseFormat != nil {
+		structuredChatCLI(providerName, provider, message, attachments)
+		return
 	}
 
-	req := Request{
-		Model: provider.Model,
-		Messages: []Message{
-			{Role: "user", Content: finalMessage},
-		},
-
-		Stream: true,
+	// Every one-shot chat is saved as a session; on a terminal the
+	// conversation goes on in the REPL
+	r := newREPL(providerName)
+	if message != "" {
+		r.send(message, attachments)
 	}
-
-	var response *Response
-	var actualProvider string
-	var err error
-	var streamingErr error
-
-	if streamingEnabled {
-		// Use streaming mode
-		fmt.Printf("🎯 Provider: %s\n", providerName)
-		if providerConfig.FallbackEnabled {
-			fmt.Printf("🔄 Fallback enabled: %v\n", providerConfig.FallbackEnabled)
-		}
-		fmt.Println("📝 Response (streaming):")
-
-		fmt.Fprintf(os.Stderr, "[DEBUG] chatWithAI: message = '%s', len = %d\n", message, len(message))
-
-		var fullResponse string
-		streamingErr = makeStreamingRequestWithCapture(provider.Endpoint, provider.APIKey, req, provider.Name, &fullResponse)
-		actualProvider = providerName
-
-		fmt.Fprintf(os.Stderr, "[DEBUG] chatWithAI: fullResponse len = %d, streamingErr = %v\n", len(fullResponse), streamingErr)
-
-		if streamingErr != nil {
-			fmt.Printf("\n❌ Streaming Error: %v\n", streamingErr)
-			return
-		}
-
-		if fullResponse != "" {
-			fmt.Println(fullResponse)
-
-			// Auto-extract dari EVERY conversation
-			if extractor := GetAutoMemoryExtractor(); extractor != nil {
-				fmt.Println("\n💾 Extracting important information...")
-				fmt.Printf("[DEBUG] message variable value: '%s'\n", message)
-				fmt.Printf("[DEBUG] message variable length: %d\n", len(message))
-				conversation := "User said: " + message + "\n\nAssistant responded: " + fullResponse
-				fmt.Printf("[DEBUG] fullResponse variable length: %d\n", len(fullResponse))
-				fmt.Printf("[DEBUG] Conversation length: %d\n", len(conversation))
-				fmt.Printf("[DEBUG] Full response length: %d\n", len(fullResponse))
-				fmt.Printf("[DEBUG] Conversation length: %d\n", len(conversation))
-				if len(message) > 50 {
-					fmt.Printf("[DEBUG] Message: %s...\n", message[:50])
-				} else {
-					fmt.Printf("[DEBUG] Message: %s\n", message)
-				}
-				if len(fullResponse) > 50 {
-					fmt.Printf("[DEBUG] Full response: %s...\n", fullResponse[:50])
-				} else {
-					fmt.Printf("[DEBUG] Full response: %s\n", fullResponse)
-				}
-				count := ExtractAndSaveMemories(conversation, "")
-				fmt.Printf("[DEBUG] Extracted %d memories\n", count)
-				if count > 0 {
-					fmt.Printf("✅ Saved %d memories\n", count)
-				}
-			}
-		}
-	} else {
-		// Use non-streaming mode
-		if providerConfig.FallbackEnabled {
-			fmt.Printf("🎯 Primary provider: %s\n", providerName)
-			fmt.Printf("🔄 Fallback enabled: %v\n", providerConfig.FallbackEnabled)
-			response, actualProvider, err = makeRequestWithFallback(
-				provider.Endpoint, provider.APIKey, req, providerName,
-			)
-		} else {
-			response, err = makeRequest(provider.Endpoint, provider.APIKey, req, provider.Name)
-			actualProvider = providerName
-		}
-
-		if err != nil {
-			fmt.Printf("❌ Error: %v\n", err)
-			return
-		}
-
-		if response.Error != nil {
-			fmt.Printf("❌ API Error: %s\n", response.Error.Message)
-			return
-		}
-
-		if len(response.Choices) > 0 {
-			if actualProvider != providerName {
-				fmt.Printf("📡 Response from fallback provider: %s\n", actualProvider)
-			}
-			fmt.Println(response.Choices[0].Message.Content)
-
-			// Auto-extract dari EVERY conversation
-			if extractor := GetAutoMemoryExtractor(); extractor != nil {
-				fmt.Println("\n💾 Extracting important information...")
-				conversation := "User said: " + message + "\n\nAssistant responded: " + response.Choices[0].Message.Content
-				fmt.Printf("[DEBUG] Conversation length: %d\n", len(conversation))
-				count := ExtractAndSaveMemories(conversation, "")
-				fmt.Printf("[DEBUG] Extracted %d memories\n", count)
-				if count > 0 {
-					fmt.Printf("✅ Saved %d memories\n", count)
-				}
-			}
+	if !r.input.terminal || scriptMode() {
+		if message == "" {
+			fmt.Fprintln(os.Stderr, providerConfig.Prompts.MessageEmpty)
+			os.Exit(1)
 		}
-	}
-
-	fmt.Print("\nContinue? (y/n): ")
-	var answer string
-	fmt.Scanln(&answer)
-	if strings.ToLower(answer) != "y" {
+		r.finish()
 		return
 	}
-
-	fmt.Print("Your message: ")
-	reader := bufio.NewReader(os.Stdin)
-	msg, _ := reader.ReadString('\n')
-	msg = strings.TrimSpace(msg)
-
-	if msg != "" {
-		chatWithAI(actualProvider, msg)
-	}
+	r.loop()
 }
 
 func findMatchingSkills(message string) []Skill {
@@ -2403,13 +2586,66 @@ func findMatchingSkills(message string) []Skill {
 	return matches
 }
 
-func makeRequestWithFallback(endpoint, apiKey string, req Request, providerName string) (*Response, string, error) {
+// fallbackOrder returns the requested provider first, followed by the other
+// enabled providers in priority order. Providers whose circuit breaker is open
+// are skipped unless nothing else is left.
+func fallbackOrder(primary, model string) []string {
+	candidates := []string{}
+	if config, exists := providerConfig.Providers[primary]; exists && config.Enabled {
+		candidates = append(candidates, primary)
+	}
+	for _, name := range getOrderedProviders() {
+		if name != primary {
+			candidates = append(candidates, name)
+		}
+	}
+
+	var healthy, tripped []string
+	for _, name := range candidates {
+		candidateModel := providers[name].Model
+		if name == primary && model != "" {
+			candidateModel = model
+		}
+		if circuitState(name, candidateModel) == CircuitOpen {
+			tripped = append(tripped, name)
+		} else {
+			healthy = append(healthy, name)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return tripped
+	}
+	if len(tripped) > 0 {
+		fmt.Fprintf(statusOut, "⏸️  Skipping %s (circuit open after repeated failures)\n", strings.Join(tripped, ", "))
+	}
+	return healthy
+}
+
+func makeRequestWithFallback(ctx context.Context, req Request, primaryProvider string) (*Response, string, error) {
 	var lastError error
 	attemptedProviders := make(map[string]bool)
+	order := fallbackOrder(primaryProvider, req.Model)
 
-	orderedProviders := getOrderedProviders()
+	if strategy := fallbackStrategy(); strategy != StrategySequential {
+		response, winner, tried, err := raceRequest(ctx, req, primaryProvider, order, strategy)
+		if err == nil {
+			return response, winner, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		// Whoever was not raced is still tried one by one
+		lastError = err
+		for _, name := range tried {
+			attemptedProviders[name] = true
+		}
+	}
 
-	for _, providerName := range orderedProviders {
+	for _, providerName := range order {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
 		if attemptedProviders[providerName] {
 			continue
 		}
@@ -2422,49 +2658,31 @@ func makeRequestWithFallback(endpoint, apiKey string, req Request, providerName
 		}
 
 		provider := providers[providerName]
-		if provider.APIKey == "" {
-			fmt.Printf("⚠️  Provider '%s' has no API key, skipping...\n", providerName)
+		if !providerReady(provider) {
+			fmt.Fprintf(statusOut, "⚠️  Provider '%s' has no API key, skipping...\n", providerName)
 			continue
 		}
 
-		fmt.Printf("🔄 Attempting provider: %s (Priority %d)\n", providerName, config.Priority)
+		fmt.Fprintf(statusOut, "🔄 Attempting provider: %s (Priority %d)\n", providerName, config.Priority)
 
-		var response *Response
-		var err error
+		// Each provider uses its own model; only the primary keeps the requested one
+		attempt := req
+		if providerName != primaryProvider {
+			attempt.Model = provider.Model
+		}
 
+		policy := retryPolicyFor(providerName)
 		for retry := 0; retry <= config.MaxRetries; retry++ {
-			if retry > 0 {
-				fmt.Printf("   Retry %d/%d...\n", retry, config.MaxRetries)
-				time.Sleep(time.Duration(providerConfig.RetryDelayMs) * time.Millisecond)
-			}
-
-			response, err = makeRequest(provider.Endpoint, provider.APIKey, req, provider.Name)
+			response, err := makeRequest(ctx, providerName, attempt)
 
-			if err == nil && (response.Error == nil || response.Error.Message == "") {
-				fmt.Printf("✅ Success with provider: %s\n", providerName)
+			perr := toProviderError(providerName, err, response)
+			if perr == nil {
+				fmt.Fprintf(statusOut, "✅ Success with provider: %s\n", providerName)
 				return response, providerName, nil
 			}
+			lastError = perr
 
-			errorType := classifyError(err, response)
-			lastError = fmt.Errorf("provider %s: %w", providerName, combineErrors(err, response))
-
-			if errorType == "rate_limit" {
-				fmt.Printf("   ⚠️  Rate limit exceeded on %s\n", providerName)
-				if retry < config.MaxRetries {
-					continue
-				}
-				break
-			} else if errorType == "server_error" || errorType == "network" {
-				fmt.Printf("   ⚠️  %s error on %s\n", errorType, providerName)
-				if retry < config.MaxRetries {
-					continue
-				}
-				break
-			} else if errorType == "timeout" {
-				fmt.Printf("   ⚠️  Timeout on %s\n", providerName)
-				if retry < config.MaxRetries {
-					continue
-				}
+			if !waitForRetry(ctx, policy, perr, retry, config.MaxRetries) {
 				break
 			}
 		}
@@ -2473,265 +2691,291 @@ func makeRequestWithFallback(endpoint, apiKey string, req Request, providerName
 	return nil, "", fmt.Errorf("all providers failed. Last error: %w", lastError)
 }
 
-func makeRequest(endpoint, apiKey string, req Request, provider string) (*Response, error) {
-	var reqBody []byte
-	var err error
+// withoutProviders returns order minus the given providers
+func withoutProviders(order, skip []string) []string {
+	skipped := map[string]bool{}
+	for _, name := range skip {
+		skipped[name] = true
+	}
 
-	// Check if OpenRouter with BYOK enabled
-	if provider == "openrouter" {
-		if config, exists := providerConfig.Providers["openrouter"]; exists && config.BYOKConfig != nil && config.BYOKConfig.Enabled {
-			// Build OpenRouter request with BYOK provider ordering
-			openRouterReq := OpenRouterRequest{
-				Model:    req.Model,
-				Messages: req.Messages,
-				Stream:   req.Stream,
-				Provider: &OpenRouterProvider{
-					AllowFallbacks: config.BYOKConfig.AllowFallbackToShared,
-					Order:          config.BYOKConfig.ProviderOrder,
-				},
-			}
-			reqBody, err = json.Marshal(openRouterReq)
-			if err != nil {
-				return nil, err
-			}
-			fmt.Printf("🔄 Using OpenRouter BYOK with order: %v\n", config.BYOKConfig.ProviderOrder)
-		} else {
-			// Regular OpenRouter request without BYOK
-			reqBody, err = json.Marshal(req)
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		// Non-OpenRouter providers
-		reqBody, err = json.Marshal(req)
-		if err != nil {
-			return nil, err
+	var rest []string
+	for _, name := range order {
+		if !skipped[name] {
+			rest = append(rest, name)
 		}
 	}
+	return rest
+}
 
-	client := &http.Client{Timeout: 120 * time.Second}
+// waitForRetry reports the failure and sleeps according to the provider's
+// retry policy. It returns false when the provider should not be retried.
+func waitForRetry(ctx context.Context, policy RetryPolicy, perr *ProviderError, retry, maxRetries int) bool {
+	fmt.Fprintf(statusOut, "   ⚠️  %v\n", perr)
+	if !perr.Retryable() || retry >= maxRetries {
+		return false
+	}
 
-	httpReq, err := http.NewRequest("POST", endpoint, strings.NewReader(string(reqBody)))
-	if err != nil {
-		return nil, err
+	wait, ok := policy.delay(retry+1, perr)
+	if !ok {
+		fmt.Fprintf(statusOut, "   ⏭️  %s asks to wait %s, moving on\n", perr.Provider, wait.Round(time.Second))
+		return false
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	fmt.Fprintf(statusOut, "   Retry %d/%d in %s...\n", retry+1, maxRetries, wait.Round(time.Millisecond))
+	return sleepContext(ctx, wait) == nil
+}
 
-	if provider == "openrouter" {
-		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-		httpReq.Header.Set("HTTP-Referer", "https://terminal-ai.local")
-		httpReq.Header.Set("X-Title", "Terminal AI CLI")
-	} else if provider == "gemini" {
-		httpReq.Header.Set("x-goog-api-key", apiKey)
-	} else {
-		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-	}
+// interruptibleContext returns a context that Ctrl+C cancels instead of
+// killing the process, so a running request can stop cleanly and keep what it
+// already received. Call stop to restore the default Ctrl+C behaviour. Only
+// the command line uses it, so the context is marked as a local run.
+func interruptibleContext() (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(withLocalRun(context.Background()), os.Interrupt)
+}
 
-	resp, err := client.Do(httpReq)
+func makeRequest(ctx context.Context, providerName string, req Request) (*Response, error) {
+	provider, err := getProvider(providerName)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if req.Stream {
-		// Handle streaming response
-		return handleStreamingResponse(resp.Body, provider)
+	if providerName == "openrouter" {
+		if config, exists := providerConfig.Providers["openrouter"]; exists && config.BYOKConfig != nil && config.BYOKConfig.Enabled {
+			fmt.Fprintf(statusOut, "🔄 Using OpenRouter BYOK with order: %v\n", config.BYOKConfig.ProviderOrder)
+		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	model := req.Model
+	if model == "" {
+		model = providers[providerName].Model
 	}
 
-	var response Response
-	json.Unmarshal(body, &response)
+	req = withProviderParams(providerName, req)
+	if entry := lookupCache(ctx, providerName, req); entry != nil {
+		return entry.response(), nil
+	}
 
-	return &response, nil
+	if !claimProbe(providerName, model) {
+		return nil, errProbeInFlight(providerName)
+	}
+	start := time.Now()
+	response, err := provider.Chat(ctx, req)
+	perr := toProviderError(providerName, err, response)
+	recordProviderResult(providerName, model, time.Since(start), perr)
+	if perr == nil {
+		recordUsage(ctx, providerName, model, response.Usage, false)
+		storeCache(ctx, providerName, req, response)
+	}
+
+	return response, err
 }
 
-func handleStreamingResponse(body io.ReadCloser, provider string) (*Response, error) {
-	scanner := bufio.NewScanner(body)
-	var fullContent strings.Builder
-	var response Response
+// StreamInterruptedError is returned when a stream dies after some of the
+// answer was already shown and no other provider could finish it. Partial
+// holds everything received so far so the caller can keep it and resume.
+type StreamInterruptedError struct {
+	Provider string
+	Partial  string
+	Err      error
+}
 
-	writer := bufio.NewWriter(os.Stdout)
-	writer.WriteString("\n")
-	writer.Flush()
+func (e *StreamInterruptedError) Error() string {
+	return fmt.Sprintf("stream from %s interrupted after %d characters: %v", e.Provider, len(e.Partial), e.Err)
+}
 
-	for i := 0; i < 3; i++ {
-		writer.WriteString("🚀")
-		writer.Flush()
-		time.Sleep(100 * time.Millisecond)
-	}
-	writer.WriteString(fmt.Sprintf(" Streaming from %s ", provider))
-	writer.Flush()
-	for i := 0; i < 3; i++ {
-		writer.WriteString("🚀")
-		writer.Flush()
-		time.Sleep(100 * time.Millisecond)
+func (e *StreamInterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// continuationMessages asks the next provider to pick up an answer that was
+// cut off mid-stream, so the user sees one continuous reply.
+func continuationMessages(messages []Message, partial string) []Message {
+	resumed := append([]Message{}, messages...)
+	return append(resumed,
+		Message{Role: "assistant", Content: partial},
+		Message{Role: "user", Content: "Your previous reply was cut off. Continue exactly where it stopped, without repeating anything or adding a preamble."},
+	)
+}
+
+// makeStreamingRequestWithFallback replays a cached answer for the primary
+// provider as a stream, or streams a fresh one and caches it.
+func makeStreamingRequestWithFallback(ctx context.Context, req Request, primaryProvider string, onDelta func(string)) (*Response, string, error) {
+	if entry := lookupCache(ctx, primaryProvider, withProviderParams(primaryProvider, req)); entry != nil {
+		entry.replay(onDelta)
+		return entry.response(), primaryProvider, nil
 	}
-	writer.WriteString("\n\n")
-	writer.Flush()
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
+	response, actualProvider, err := streamWithFallback(ctx, req, primaryProvider, onDelta)
+	if err == nil {
+		served := withProviderParams(actualProvider, req)
+		if actualProvider != primaryProvider {
+			served.Model = providers[actualProvider].Model
 		}
+		storeCache(ctx, actualProvider, served, response)
+	}
+	return response, actualProvider, err
+}
 
-		// Remove "data: " prefix if present
-		if strings.HasPrefix(line, "data: ") {
-			line = line[6:]
-		}
+// streamWithFallback streams from the primary provider and, when fallback is
+// enabled, moves down the priority list. Errors before the first token are
+// retried like non-streaming requests. If a stream dies after output started,
+// the next provider is asked to continue the partial answer; without one a
+// StreamInterruptedError carrying the partial text is returned.
+func streamWithFallback(ctx context.Context, req Request, primaryProvider string, onDelta func(string)) (*Response, string, error) {
+	order := []string{primaryProvider}
+	if providerConfig.FallbackEnabled {
+		order = fallbackOrder(primaryProvider, req.Model)
+	}
 
-		// Skip [DONE] signals
-		if line == "[DONE]" {
-			break
+	var lastError error
+	var partial strings.Builder
+	lastProvider := primaryProvider
+
+	if strategy := fallbackStrategy(); strategy != StrategySequential {
+		response, winner, received, tried, err := raceStream(ctx, req, primaryProvider, order, strategy, onDelta)
+		if err == nil {
+			return response, winner, nil
+		}
+		// Whoever was not raced is still tried one by one, continuing any
+		// answer the winner left unfinished
+		lastError = err
+		partial.WriteString(received)
+		if winner != "" {
+			lastProvider = winner
+		}
+		order = withoutProviders(order, tried)
+		if ctx.Err() != nil {
+			order = nil
 		}
+	}
 
-		var chunk map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+	for _, providerName := range order {
+		provider := providers[providerName]
+		if !providerReady(provider) {
+			fmt.Fprintf(statusOut, "⚠️  Provider '%s' has no API key, skipping...\n", providerName)
 			continue
 		}
 
-		// Handle different response formats
-		if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-			if choice, ok := choices[0].(map[string]interface{}); ok {
-				if delta, ok := choice["delta"].(map[string]interface{}); ok {
-					if content, ok := delta["content"].(string); ok && content != "" {
-						fullContent.WriteString(content)
-
-						chunk := content
-
-						if len(chunk) > 1 {
-							for _, char := range chunk {
-								writer.WriteString(fmt.Sprintf("\033[36m%c\033[0m", char))
-								writer.Flush()
-								time.Sleep(20 * time.Millisecond)
-							}
-						} else {
-							writer.WriteString(fmt.Sprintf("\033[36m%s\033[0m", chunk))
-							writer.Flush()
-						}
-					}
-				}
-			}
+		streamer, err := getProvider(providerName)
+		if err != nil {
+			lastError = err
+			continue
 		}
-	}
 
-	// Completion animation
-	writer.WriteString("\n\n")
-	writer.Flush()
-	for i := 0; i < 5; i++ {
-		writer.WriteString("✨")
-		writer.Flush()
-		time.Sleep(50 * time.Millisecond)
-	}
-	writer.WriteString(" Response Complete ")
-	writer.Flush()
-	for i := 0; i < 5; i++ {
-		writer.WriteString("✨")
-		writer.Flush()
-		time.Sleep(50 * time.Millisecond)
-	}
-	writer.WriteString("\n\n")
-	writer.Flush()
-
-	// Create a response with the full content for session storage
-	response = Response{
-		Choices: []Choice{
-			{
-				Message: Message{
-					Role:    "assistant",
-					Content: fullContent.String(),
-				},
-			},
-		},
-	}
-
-	fmt.Fprintf(os.Stderr, "[DEBUG] handleStreamingResponse: fullContent length = %d, content = '%s'\n", len(fullContent.String()), fullContent.String())
+		if partial.Len() > 0 {
+			fmt.Fprintf(statusOut, "\n\n🔄 Stream from %s interrupted, continuing with %s...\n\n", lastProvider, providerName)
+		} else if providerName != primaryProvider {
+			fmt.Fprintf(statusOut, "🔄 Switching to fallback provider: %s\n", providerName)
+		}
+		lastProvider = providerName
 
-	return &response, nil
-}
+		attempt := withProviderParams(providerName, req)
+		if providerName != primaryProvider {
+			attempt.Model = provider.Model
+		}
+		if partial.Len() > 0 {
+			attempt.Messages = continuationMessages(req.Messages, partial.String())
+		}
+		healthModel := attempt.Model
+		if healthModel == "" {
+			healthModel = provider.Model
+		}
 
-func makeStreamingRequest(endpoint, apiKey string, req Request, provider string) error {
-	client := &http.Client{Timeout: 300 * time.Second}
+		config := providerConfig.Providers[providerName]
+		policy := retryPolicyFor(providerName)
+		for retry := 0; retry <= config.MaxRetries; retry++ {
+			if !claimProbe(providerName, healthModel) {
+				lastError = errProbeInFlight(providerName)
+				break
+			}
+			var received strings.Builder
+			start := time.Now()
+			response, err := streamer.ChatStream(ctx, attempt, func(chunk string) {
+				received.WriteString(chunk)
+				if onDelta != nil {
+					onDelta(chunk)
+				}
+			})
+			recordProviderResult(providerName, healthModel, time.Since(start), toProviderError(providerName, err, nil))
+			if err == nil {
+				var usage *Usage
+				if response != nil {
+					usage = response.Usage
+				}
+				recordUsage(ctx, providerName, healthModel, usage, true)
+				content := partial.String() + received.String()
+				if response == nil {
+					response = &Response{}
+				}
+				response.Choices = []Choice{{Message: Message{Role: "assistant", Content: content, ToolCalls: toolCallsOf(response)}}}
+				return response, providerName, nil
+			}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
+			perr := toProviderError(providerName, err, nil)
+			lastError = perr
 
-	httpReq, err := http.NewRequest("POST", endpoint, strings.NewReader(string(reqBody)))
-	if err != nil {
-		return err
-	}
+			// Output already reached the user, retrying would repeat it
+			if received.Len() > 0 {
+				partial.WriteString(received.String())
+				break
+			}
+			if ctx.Err() != nil {
+				break
+			}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+			if !waitForRetry(ctx, policy, perr, retry, config.MaxRetries) {
+				break
+			}
+		}
 
-	if provider == "openrouter" {
-		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-		httpReq.Header.Set("HTTP-Referer", "https://terminal-ai.local")
-		httpReq.Header.Set("X-Title", "Terminal AI CLI")
-	} else if provider == "gemini" {
-		httpReq.Header.Set("x-goog-api-key", apiKey)
-	} else {
-		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+		if ctx.Err() != nil || !providerConfig.FallbackEnabled {
+			break
+		}
 	}
 
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
+	if ctx.Err() != nil {
+		lastError = ctx.Err()
 	}
-	defer resp.Body.Close()
-
-	_, err = handleStreamingResponse(resp.Body, provider)
-	return err
-}
-
-func makeStreamingRequestWithCapture(endpoint, apiKey string, req Request, provider string, fullResponse *string) error {
-	client := &http.Client{Timeout: 300 * time.Second}
-
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return err
+	if partial.Len() > 0 {
+		return nil, lastProvider, &StreamInterruptedError{Provider: lastProvider, Partial: partial.String(), Err: lastError}
 	}
-
-	httpReq, err := http.NewRequest("POST", endpoint, strings.NewReader(string(reqBody)))
-	if err != nil {
-		return err
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
 	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	if provider == "openrouter" {
-		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
-		httpReq.Header.Set("HTTP-Referer", "https://terminal-ai.local")
-		httpReq.Header.Set("X-Title", "Terminal AI CLI")
-	} else if provider == "gemini" {
-		httpReq.Header.Set("x-goog-api-key", apiKey)
-	} else {
-		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	if lastError == nil {
+		lastError = fmt.Errorf("no provider is ready")
 	}
+	return nil, "", fmt.Errorf("all providers failed. Last error: %w", lastError)
+}
 
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// handleStreamingResponse writes the stream to resultOut as it arrives and
+// returns the complete response and the provider that produced it.
+func handleStreamingResponse(ctx context.Context, providerName string, req Request) (*Response, string, error) {
+	fmt.Fprintln(statusOut)
+	out := newMarkdownWriter(answerOut(), outputRenderMode())
+	response, actualProvider, err := runAgent(ctx, req, providerName, out.Write, nil)
+	out.Close()
+	fmt.Fprintln(statusOut)
+	return response, actualProvider, err
+}
 
-	response, err := handleStreamingResponse(resp.Body, provider)
+// makeStreamingRequestWithCapture streams a reply into fullResponse. When the
+// stream is interrupted, fullResponse still receives the partial answer.
+func makeStreamingRequestWithCapture(ctx context.Context, providerName string, req Request, fullResponse *string) (*Response, string, error) {
+	response, actualProvider, err := handleStreamingResponse(ctx, providerName, req)
 	if err != nil {
-		return err
+		var interrupted *StreamInterruptedError
+		if errors.As(err, &interrupted) {
+			*fullResponse = interrupted.Partial
+		}
+		return response, actualProvider, err
 	}
 
 	if len(response.Choices) > 0 {
 		*fullResponse = response.Choices[0].Message.Content
 	}
 
-	return nil
+	return response, actualProvider, nil
 }
 
 func truncate(s string, maxLen int) string {
@@ -2744,11 +2988,12 @@ func truncate(s string, maxLen int) string {
 func handleMemoryCommand() {
 	mgr := GetEncryptedMemoryManager()
 	if mgr == nil {
-		fmt.Println("❌ Memory manager not initialized")
+		fmt.Fprintln(os.Stderr, "❌ Memory manager not initialized")
 		return
 	}
 
-	ctx := context.Background()
+	ctx, stop := interruptibleContext()
+	defer stop()
 
 	if len(os.Args) < 3 {
 		fmt.Println("Usage:")
@@ -2784,25 +3029,33 @@ func handleMemoryCommand() {
 
 	case "recall":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: terminal-ai memory recall <query>")
+			fmt.Fprintln(os.Stderr, "Usage: terminal-ai memory recall <query>")
 			return
 		}
 		query := strings.Join(os.Args[3:], " ")
 		results, err := mgr.SearchAndDecrypt(ctx, query, 5)
 		if err != nil {
-			fmt.Printf("❌ Failed to search memories: %v\n", err)
+			fmt.Fprintf(os.Stderr, "❌ Failed to search memories: %v\n", err)
+			os.Exit(1)
+		}
+		if jsonOutput {
+			recalled := []MemoryRecallResult{}
+			for _, result := range results {
+				recalled = append(recalled, MemoryRecallResult{Memory: result.Memory, Similarity: result.Similarity})
+			}
+			writeJSON(recalled)
 			return
 		}
 		if len(results) == 0 {
-			fmt.Println("No memories found")
+			fmt.Fprintln(statusOut, "No memories found")
 			return
 		}
-		fmt.Printf("Found %d memories:\n", len(results))
+		fmt.Fprintf(statusOut, "Found %d memories:\n", len(results))
 		for i, result := range results {
-			fmt.Printf("\n%d. [%.2f] %s\n", i+1, result.Similarity, truncate(result.Memory.Content, 100))
-			fmt.Printf("   Created: %s\n", result.Memory.CreatedAt.Format("2006-01-02 15:04"))
+			fmt.Fprintf(resultOut, "\n%d. [%.2f] %s\n", i+1, result.Similarity, truncate(result.Memory.Content, 100))
+			fmt.Fprintf(resultOut, "   Created: %s\n", result.Memory.CreatedAt.Format("2006-01-02 15:04"))
 			if len(result.Memory.Metadata.Tags) > 0 {
-				fmt.Printf("   Tags: %v\n", result.Memory.Metadata.Tags)
+				fmt.Fprintf(resultOut, "   Tags: %v\n", result.Memory.Metadata.Tags)
 			}
 		}
 
@@ -2921,7 +3174,7 @@ func showHelp() {
 	fmt.Println("Usage:")
 	fmt.Println("  terminal-ai [provider] <message>       - Chat with AI")
 	fmt.Println("  terminal-ai [provider] --no-streaming <message>  - Chat without streaming")
-	fmt.Println("  terminal-ai chat --list/--new/--last/--session <id>  - Chat sessions")
+	fmt.Println("  terminal-ai chat --list/--new/--last/--session <id>  - Chat sessions (type /help inside for commands)")
 	fmt.Println("  terminal-ai history list/view/export/delete <id>/clear  - Chat history")
 	fmt.Println("  terminal-ai rag index <dir> / search <query>  - Local RAG")
 	fmt.Println("  terminal-ai skill list/create <name>   - Custom skills")
@@ -2929,6 +3182,10 @@ func showHelp() {
 	fmt.Println("  terminal-ai provider list/test/enable/disable/priority/add/default  - Provider config")
 	fmt.Println("  terminal-ai web <url> / web-server      - Web fetch & server")
 	fmt.Println("  terminal-ai memory add/recall/list/delete/consolidate - Long-term memory")
+	fmt.Println("  terminal-ai usage [--since 7d] [--by provider|model|user|session]  - Token & cost ledger")
+	fmt.Println("  terminal-ai persona list/show/create/delete <name>  - Reusable system prompts")
+	fmt.Println("  terminal-ai compare --providers a,b,c [--save] <message>  - Ask several providers at once")
+	fmt.Println("  terminal-ai cache stats|clear          - Response cache")
 	fmt.Println("  terminal-ai --help                     - Show this help")
 	fmt.Println()
 	fmt.Println("Memory Commands:")
@@ -2942,10 +3199,27 @@ func showHelp() {
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  --no-streaming, -s    Disable streaming mode (wait for complete response)")
+	fmt.Println("  --temperature <0-2>   Sampling temperature for this request")
+	fmt.Println("  --max-tokens <n>      Limit the length of the answer")
+	fmt.Println("  --top-p <0-1>         Nucleus sampling")
+	fmt.Println("  --seed <n>            Seed for repeatable answers (where supported)")
+	fmt.Println("  --stop <text>         Stop sequence, repeatable")
+	fmt.Println("  --system <prompt>     System prompt for this chat or new session")
+	fmt.Println("  --persona <name>      Use a persona from ~/.config/terminal-ai/personas/")
+	fmt.Println("  --cache / --no-cache  Force the response cache on or off for this run")
+	fmt.Println("  --tools[=a,b]         Let the AI call web_fetch, rag_search, memory_recall, memory_save")
+	fmt.Println("  --max-steps <n>       Maximum tool rounds per answer (default 5)")
+	fmt.Println("  --json-schema <file>  Answer with JSON matching the schema, printed alone on stdout")
+	fmt.Println("  --image <file>        Attach an image (PNG, JPEG, GIF, WebP), repeatable; @file.png in a message works too")
+	fmt.Println("  --json-retries <n>    Re-ask this many times when the JSON does not match (default 2)")
+	fmt.Println("  --render <mode>       Show answers as markdown (default on a terminal), plain or raw")
+	fmt.Println("  --quiet, -q           Print only the answer or result; status messages are hidden")
+	fmt.Println("  --json                Print the result as JSON (answer, provider, model, usage, session id, sources)")
+	fmt.Println("  -o, --output <file>   Write the answer or result to a file")
 	fmt.Println("  STREAMING=false       Environment variable to disable streaming")
 	fmt.Println()
 	fmt.Println("Providers (default: openrouter):")
-	fmt.Println("  - openrouter (1) - gemini (2) - groq (3) - Custom BYOK (0+)")
+	fmt.Println("  - openrouter (1) - gemini (2) - groq (3) - anthropic (4) - Custom BYOK (0+)")
 	fmt.Println()
 	fmt.Println("Tips for long responses:")
 	fmt.Println("  - Use --no-streaming for complete response at once")
@@ -290,6 +290,16 @@ func (m *MemoryManager) AddMemory(ctx context.Context, content string, metadata
 }
 
 func (m *MemoryManager) SearchMemories(ctx context.Context, query string, topK int) ([]MemorySearchResult, error) {
+	return m.searchMemories(ctx, query, topK, nil)
+}
+
+// SearchUserMemories searches only the memories saved for user; "" is the
+// owner of the command line
+func (m *MemoryManager) SearchUserMemories(ctx context.Context, query, user string, topK int) ([]MemorySearchResult, error) {
+	return m.searchMemories(ctx, query, topK, map[string]string{"user": user})
+}
+
+func (m *MemoryManager) searchMemories(ctx context.Context, query string, topK int, where map[string]string) ([]MemorySearchResult, error) {
 	if !m.initialized {
 		return nil, fmt.Errorf("memory manager not initialized")
 	}
@@ -307,7 +317,7 @@ func (m *MemoryManager) SearchMemories(ctx context.Context, query string, topK i
 		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
 	}
 
-	results, err := m.collection.QueryEmbedding(ctx, embedding, topK, nil, nil)
+	results, err := m.collection.QueryEmbedding(ctx, embedding, topK, where, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search memories: %w", err)
 	}
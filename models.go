@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultModelCacheTTL is how long a provider's model list is reused
+const DefaultModelCacheTTL = 24 * time.Hour
+
+// ModelList is a provider's model listing as cached in models/<name>.json.
+// The endpoint is kept so a changed endpoint is fetched again.
+type ModelList struct {
+	Provider  string      `json:"provider"`
+	Endpoint  string      `json:"endpoint"`
+	FetchedAt string      `json:"fetched_at"`
+	Models    []ModelInfo `json:"models"`
+}
+
+func getModelCacheDir() string {
+	return filepath.Join(getDataDir(), "models")
+}
+
+func modelCachePath(providerName string) string {
+	return filepath.Join(getModelCacheDir(), normalizeProviderKeyCLI(providerName)+".json")
+}
+
+func loadModelCache(providerName, endpoint string) *ModelList {
+	data, err := os.ReadFile(modelCachePath(providerName))
+	if err != nil {
+		return nil
+	}
+	var list ModelList
+	if err := json.Unmarshal(data, &list); err != nil || list.Endpoint != endpoint {
+		return nil
+	}
+	return &list
+}
+
+func readModelCache(providerName, endpoint string) *ModelList {
+	list := loadModelCache(providerName, endpoint)
+	if list == nil {
+		return nil
+	}
+	fetched, err := time.Parse(time.RFC3339, list.FetchedAt)
+	if err != nil || time.Since(fetched) > DefaultModelCacheTTL {
+		return nil
+	}
+	return list
+}
+
+// cachedContextLength returns the model's context window from the model
+// cache, even a stale one, without fetching. 0 means unknown.
+func cachedContextLength(providerName, model string) int {
+	list := loadModelCache(providerName, providers[providerName].Endpoint)
+	if list == nil {
+		return 0
+	}
+	if m := findModel(list.Models, model); m != nil {
+		return m.ContextLength
+	}
+	return 0
+}
+
+// listProviderModels returns the provider's models, from the local cache
+// unless it is stale or refresh is set.
+func listProviderModels(ctx context.Context, providerName string, refresh bool) (*ModelList, error) {
+	cfg, exists := providers[providerName]
+	if !exists {
+		return nil, fmt.Errorf("unknown provider: %s", providerName)
+	}
+	if !refresh {
+		if list := readModelCache(providerName, cfg.Endpoint); list != nil {
+			return list, nil
+		}
+	}
+
+	provider, err := getProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	models, err := provider.ListModels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models for %s: %w", providerName, err)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+
+	list := &ModelList{
+		Provider:  providerName,
+		Endpoint:  cfg.Endpoint,
+		FetchedAt: time.Now().Format(time.RFC3339),
+		Models:    models,
+	}
+	if data, err := json.MarshalIndent(list, "", "  "); err == nil {
+		os.MkdirAll(getModelCacheDir(), 0755)
+		os.WriteFile(modelCachePath(providerName), data, 0644)
+	}
+	return list, nil
+}
+
+func filterModels(models []ModelInfo, filter string) []ModelInfo {
+	if filter == "" {
+		return models
+	}
+	filter = strings.ToLower(filter)
+	out := []ModelInfo{}
+	for _, m := range models {
+		if strings.Contains(strings.ToLower(m.ID), filter) || strings.Contains(strings.ToLower(m.Name), filter) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// findModel matches a model id exactly; Ollama's implicit ":latest" tag
+// counts as a match.
+func findModel(models []ModelInfo, model string) *ModelInfo {
+	for i, m := range models {
+		if m.ID == model || m.ID == model+":latest" {
+			return &models[i]
+		}
+	}
+	return nil
+}
+
+// suggestModels returns up to three listed ids that look like model
+func suggestModels(models []ModelInfo, model string) []string {
+	type scored struct {
+		id    string
+		score int
+	}
+	needle := strings.ToLower(model)
+	if i := strings.LastIndex(needle, "/"); i >= 0 {
+		needle = needle[i+1:]
+	}
+
+	var candidates []scored
+	for _, m := range models {
+		id := strings.ToLower(m.ID)
+		score := editDistance(needle, id)
+		if strings.Contains(id, needle) {
+			score = 0
+		} else if d := editDistance(needle, id[strings.LastIndex(id, "/")+1:]); d < score {
+			score = d
+		}
+		if score <= len(needle)/3+1 {
+			candidates = append(candidates, scored{m.ID, score})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+
+	var out []string
+	for i := 0; i < len(candidates) && i < 3; i++ {
+		out = append(out, candidates[i].id)
+	}
+	return out
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// UnknownModelError means the provider's model list does not contain the model
+type UnknownModelError struct {
+	Provider    string
+	Model       string
+	Suggestions []string
+}
+
+func (e *UnknownModelError) Error() string {
+	msg := fmt.Sprintf("model %q is not offered by %s", e.Model, e.Provider)
+	if len(e.Suggestions) > 0 {
+		msg += ", did you mean: " + strings.Join(e.Suggestions, ", ")
+	}
+	return msg
+}
+
+// validateModel returns an *UnknownModelError when the provider does not list
+// the model, or another error when the list could not be fetched. A stale
+// cache miss is retried against the live list before the model is rejected.
+func validateModel(ctx context.Context, providerName, model string) error {
+	if model == "" {
+		return nil
+	}
+	list, err := listProviderModels(ctx, providerName, false)
+	if err != nil {
+		return err
+	}
+	if findModel(list.Models, model) == nil {
+		if list, err = listProviderModels(ctx, providerName, true); err != nil {
+			return err
+		}
+	}
+	if findModel(list.Models, model) != nil {
+		return nil
+	}
+	return &UnknownModelError{Provider: providerName, Model: model, Suggestions: suggestModels(list.Models, model)}
+}
+
+// confirmModel validates a model for a CLI command. When the list cannot be
+// fetched it warns and accepts the model; an unknown model is rejected.
+func confirmModel(providerName, model string) bool {
+	ctx, stop := interruptibleContext()
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	err := validateModel(ctx, providerName, model)
+	if err == nil {
+		return true
+	}
+	if unknown, ok := err.(*UnknownModelError); ok {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", unknown)
+		fmt.Fprintf(os.Stderr, "   See: terminal-ai provider models %s --filter <text>\n", providerName)
+		return false
+	}
+	fmt.Fprintf(statusOut, "⚠️  Could not verify model %q: %v\n", model, err)
+	return true
+}
+
+func formatModelPrice(perToken float64) string {
+	if perToken == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", perToken*1_000_000)
+}
+
+func listModelsCLI() {
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: terminal-ai provider models <provider-name> [--filter <text>] [--refresh]")
+		os.Exit(1)
+	}
+	providerName := os.Args[3]
+	filter := ""
+	refresh := false
+	for i := 4; i < len(os.Args); i++ {
+		switch {
+		case os.Args[i] == "--refresh":
+			refresh = true
+		case os.Args[i] == "--filter" && i+1 < len(os.Args):
+			filter = os.Args[i+1]
+			i++
+		case strings.HasPrefix(os.Args[i], "--filter="):
+			filter = strings.TrimPrefix(os.Args[i], "--filter=")
+		default:
+			filter = os.Args[i]
+		}
+	}
+
+	ctx, stop := interruptibleContext()
+	defer stop()
+
+	list, err := listProviderModels(ctx, providerName, refresh)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	models := filterModels(list.Models, filter)
+
+	fmt.Printf("📋 Models for %s (%d of %d, fetched %s)\n", providerName, len(models), len(list.Models), list.FetchedAt)
+	fmt.Println()
+	if len(models) == 0 {
+		fmt.Println("No matching models")
+		return
+	}
+
+	configured := providers[providerName].Model
+	fmt.Printf("  %-50s %10s %10s %10s\n", "MODEL", "CONTEXT", "IN $/1M", "OUT $/1M")
+	for _, m := range models {
+		contextLength := "-"
+		if m.ContextLength > 0 {
+			contextLength = fmt.Sprintf("%d", m.ContextLength)
+		}
+		marker := ""
+		if m.ID == configured || m.ID == configured+":latest" {
+			marker = " ⭐"
+		}
+		fmt.Printf("  %-50s %10s %10s %10s%s\n", m.ID, contextLength, formatModelPrice(m.PromptPrice), formatModelPrice(m.CompletionPrice), marker)
+	}
+}
+
+// handleListModels serves GET /api/providers/{name}/models. "filter"
+// narrows the list, "refresh=true" bypasses the cache and "model" checks one
+// model name, adding "valid" and "suggestions" to the response.
+func handleListModels(w http.ResponseWriter, r *http.Request) {
+	providerName := mux.Vars(r)["name"]
+	if _, exists := providers[providerName]; !exists {
+		sendJSONError(w, http.StatusNotFound, "Provider not found")
+		return
+	}
+
+	query := r.URL.Query()
+	list, err := listProviderModels(r.Context(), providerName, query.Get("refresh") == "true")
+	if err != nil {
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	result := map[string]interface{}{
+		"provider":   providerName,
+		"fetched_at": list.FetchedAt,
+		"models":     filterModels(list.Models, query.Get("filter")),
+	}
+	if model := query.Get("model"); model != "" {
+		err := validateModel(r.Context(), providerName, model)
+		result["valid"] = err == nil
+		if unknown, ok := err.(*UnknownModelError); ok {
+			result["suggestions"] = unknown.Suggestions
+		}
+	}
+	sendJSONResponse(w, http.StatusOK, result)
+}
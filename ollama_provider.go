@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const OllamaDefaultURL = "http://localhost:11434"
+
+// OllamaProvider talks to a local Ollama server through the native /api/chat
+// endpoint (NDJSON streaming). When the endpoint points at the OpenAI
+// compatible /v1/chat/completions route, chat goes through the OpenAI client
+// and only model discovery uses the native API. No API key is needed.
+type OllamaProvider struct {
+	cfg    AIProvider
+	compat *OpenAIProvider
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"`
+}
+
+// ollamaMessage differs from the OpenAI format in its tool calls: arguments
+// are a JSON object rather than a string, and there are no call ids.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+	Images    []string         `json:"images,omitempty"` // base64, for vision models
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+func toOllamaMessages(messages []Message) []ollamaMessage {
+	out := make([]ollamaMessage, 0, len(messages))
+	for _, msg := range messages {
+		converted := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		if msg.Role == "tool" {
+			converted.ToolName = msg.Name
+		}
+		for _, img := range msg.images() {
+			converted.Images = append(converted.Images, img.Data)
+		}
+		for _, call := range msg.ToolCalls {
+			var tc ollamaToolCall
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(tc.Function.Arguments) {
+				tc.Function.Arguments = json.RawMessage("{}")
+			}
+			converted.ToolCalls = append(converted.ToolCalls, tc)
+		}
+		out = append(out, converted)
+	}
+	return out
+}
+
+// ollamaTools leaves the tools out once the model must answer in text,
+// Ollama has no tool_choice
+func ollamaTools(req Request) []Tool {
+	if req.ToolChoice == "none" {
+		return nil
+	}
+	return req.Tools
+}
+
+func (m ollamaMessage) toolCalls() []ToolCall {
+	var calls []ToolCall
+	for _, call := range m.ToolCalls {
+		args := string(call.Function.Arguments)
+		if args == "" || args == "null" {
+			args = "{}"
+		}
+		calls = append(calls, ToolCall{
+			Type:     "function",
+			Function: ToolCallFunction{Name: call.Function.Name, Arguments: args},
+		})
+	}
+	return calls
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// ollamaFormat passes a JSON schema through as Ollama's structured output
+// format, or asks for plain JSON mode.
+func ollamaFormat(req Request) json.RawMessage {
+	if req.ResponseFormat == nil {
+		return nil
+	}
+	if schema := req.ResponseFormat.schema(); schema != nil {
+		return schema
+	}
+	return json.RawMessage(`"json"`)
+}
+
+// ollamaOptionsFor maps generation parameters to Ollama's option names
+func ollamaOptionsFor(params GenerationParams) *ollamaOptions {
+	if params.isZero() {
+		return nil
+	}
+	return &ollamaOptions{
+		Temperature: params.Temperature,
+		NumPredict:  params.MaxTokens,
+		TopP:        params.TopP,
+		Stop:        params.Stop,
+		Seed:        params.Seed,
+	}
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func newOllamaProvider(cfg AIProvider) Provider {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = OllamaDefaultURL + "/api/chat"
+	}
+	p := &OllamaProvider{cfg: cfg}
+	if strings.Contains(cfg.Endpoint, "/v1/") {
+		p.compat = &OpenAIProvider{cfg: cfg}
+	}
+	return p
+}
+
+// newLocalProvider is used for llama.cpp server, LM Studio, vLLM and other
+// keyless OpenAI-compatible servers.
+func newLocalProvider(cfg AIProvider) Provider {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "http://localhost:8080/v1/chat/completions"
+	}
+	return &localProvider{OpenAIProvider{cfg: cfg}}
+}
+
+type localProvider struct {
+	OpenAIProvider
+}
+
+func (p *localProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	req.Model = p.resolveModel(ctx, req.Model)
+	return p.OpenAIProvider.Chat(ctx, req)
+}
+
+func (p *localProvider) ChatStream(ctx context.Context, req Request, onDelta func(string)) (*Response, error) {
+	req.Model = p.resolveModel(ctx, req.Model)
+	return p.OpenAIProvider.ChatStream(ctx, req, onDelta)
+}
+
+// resolveModel picks the first model the server reports when none is set.
+// llama.cpp ignores the field, but most other servers require it.
+func (p *localProvider) resolveModel(ctx context.Context, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if p.cfg.Model != "" {
+		return p.cfg.Model
+	}
+	if models, err := p.ListModels(ctx); err == nil && len(models) > 0 {
+		return models[0].ID
+	}
+	return ""
+}
+
+func (p *OllamaProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *OllamaProvider) baseURL() string {
+	endpoint := strings.TrimRight(p.cfg.Endpoint, "/")
+	for _, marker := range []string{"/api/", "/v1/"} {
+		if idx := strings.Index(endpoint, marker); idx >= 0 {
+			return endpoint[:idx]
+		}
+	}
+	return endpoint
+}
+
+// resolveModel falls back to the first installed model so a fresh Ollama
+// install works without OLLAMA_MODEL.
+func (p *OllamaProvider) resolveModel(ctx context.Context, requested string) (string, error) {
+	if requested != "" {
+		return requested, nil
+	}
+	if p.cfg.Model != "" {
+		return p.cfg.Model, nil
+	}
+
+	models, err := p.ListModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(models) == 0 {
+		return "", fmt.Errorf("no models installed in Ollama, run: ollama pull <model>")
+	}
+	return models[0].ID, nil
+}
+
+func (p *OllamaProvider) post(ctx context.Context, url string, payload interface{}, timeout time.Duration) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: timeout}
+	return client.Do(httpReq)
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	model, err := p.resolveModel(ctx, req.Model)
+	if err != nil {
+		return nil, err
+	}
+	req.Model = model
+
+	if p.compat != nil {
+		return p.compat.Chat(ctx, req)
+	}
+
+	resp, err := p.post(ctx, p.baseURL()+"/api/chat", ollamaChatRequest{
+		Model:    req.Model,
+		Messages: toOllamaMessages(req.Messages),
+		Stream:   false,
+		Options:  ollamaOptionsFor(req.GenerationParams),
+		Tools:    ollamaTools(req),
+		Format:   ollamaFormat(req),
+	}, 300*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ollamaResp ollamaChatResponse
+	if err := json.Unmarshal(body, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama response: %w", err)
+	}
+
+	response := &Response{Usage: ollamaResp.usage()}
+	if ollamaResp.Error != "" {
+		response.Error = &APIError{Message: ollamaResp.Error, Type: "ollama_error"}
+		return response, nil
+	}
+	response.Choices = []Choice{
+		{Message: Message{Role: "assistant", Content: ollamaResp.Message.Content, ToolCalls: ollamaResp.Message.toolCalls()}},
+	}
+
+	return response, nil
+}
+
+func (p *OllamaProvider) ChatStream(ctx context.Context, req Request, onDelta func(string)) (*Response, error) {
+	model, err := p.resolveModel(ctx, req.Model)
+	if err != nil {
+		return nil, err
+	}
+	req.Model = model
+
+	if p.compat != nil {
+		return p.compat.ChatStream(ctx, req, onDelta)
+	}
+
+	resp, err := p.post(ctx, p.baseURL()+"/api/chat", ollamaChatRequest{
+		Model:    req.Model,
+		Messages: toOllamaMessages(req.Messages),
+		Stream:   true,
+		Options:  ollamaOptionsFor(req.GenerationParams),
+		Tools:    ollamaTools(req),
+		Format:   ollamaFormat(req),
+	}, 600*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	var fullContent strings.Builder
+	var toolCalls []ToolCall
+	var usage *Usage
+	finished := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue
+		}
+
+		if chunk.Error != "" {
+			err = fmt.Errorf("%s", chunk.Error)
+			break
+		}
+
+		if chunk.Message.Content != "" {
+			fullContent.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+
+		toolCalls = append(toolCalls, chunk.Message.toolCalls()...)
+
+		if chunk.Done {
+			usage = chunk.usage()
+			finished = true
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err == nil && !finished {
+		err = io.ErrUnexpectedEOF
+	}
+
+	response := &Response{
+		Choices: []Choice{
+			{Message: Message{Role: "assistant", Content: fullContent.String(), ToolCalls: toolCalls}},
+		},
+		Usage: usage,
+	}
+	return response, err
+}
+
+func (r *ollamaChatResponse) usage() *Usage {
+	if r.PromptEvalCount == 0 && r.EvalCount == 0 {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:     r.PromptEvalCount,
+		CompletionTokens: r.EvalCount,
+		TotalTokens:      r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+// ListModels returns the models installed locally (GET /api/tags)
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", p.baseURL()+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s: %w", p.baseURL(), err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(result.Models))
+	for _, m := range result.Models {
+		id := m.Model
+		if id == "" {
+			id = m.Name
+		}
+		models = append(models, ModelInfo{ID: id, Name: m.Name})
+	}
+
+	return models, nil
+}
+
+func (p *OllamaProvider) Embed(ctx context.Context, model, text string) ([]float32, error) {
+	payload := map[string]interface{}{
+		"model":  model,
+		"prompt": text,
+		"stream": false,
+	}
+
+	resp, err := p.post(ctx, p.baseURL()+"/api/embeddings", payload, 120*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Ollama embedding API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama embedding API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+
+	if len(result.Embedding) == 0 {
+		return nil, fmt.Errorf("no embeddings returned from Ollama")
+	}
+
+	return result.Embedding, nil
+}
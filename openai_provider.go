@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OpenAIProvider talks to any OpenAI-compatible /chat/completions endpoint.
+// OpenRouter and the Gemini compatibility layer reuse it with different
+// headers and request bodies.
+type OpenAIProvider struct {
+	cfg          AIProvider
+	setAuth      func(h http.Header, apiKey string)
+	extraHeaders map[string]string
+	wrapBody     func(req Request) interface{}
+}
+
+func newOpenAIProvider(cfg AIProvider) Provider {
+	return &OpenAIProvider{cfg: cfg}
+}
+
+func newOpenRouterProvider(cfg AIProvider) Provider {
+	return &OpenAIProvider{
+		cfg: cfg,
+		extraHeaders: map[string]string{
+			"HTTP-Referer": "https://terminal-ai.local",
+			"X-Title":      "Terminal AI CLI",
+		},
+		wrapBody: openRouterBody,
+	}
+}
+
+func newGeminiCompatProvider(cfg AIProvider) Provider {
+	return &OpenAIProvider{
+		cfg: cfg,
+		setAuth: func(h http.Header, apiKey string) {
+			h.Set("x-goog-api-key", apiKey)
+		},
+	}
+}
+
+// openRouterBody turns on cost accounting and adds BYOK provider ordering
+// when it is enabled in the config.
+func openRouterBody(req Request) interface{} {
+	body := OpenRouterRequest{
+		Model:          req.Model,
+		Messages:       req.Messages,
+		Stream:         req.Stream,
+		StreamOptions:  req.StreamOptions,
+		Usage:          &UsageAccounting{Include: true},
+		Tools:          req.Tools,
+		ToolChoice:     req.ToolChoice,
+		ResponseFormat: req.ResponseFormat,
+
+		GenerationParams: req.GenerationParams,
+	}
+	if config, exists := providerConfig.Providers["openrouter"]; exists && config.BYOKConfig != nil && config.BYOKConfig.Enabled {
+		body.Provider = &OpenRouterProvider{
+			AllowFallbacks: config.BYOKConfig.AllowFallbackToShared,
+			Order:          config.BYOKConfig.ProviderOrder,
+		}
+	}
+	return body
+}
+
+func (p *OpenAIProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *OpenAIProvider) newRequest(ctx context.Context, method, url string, payload interface{}) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.setAuth != nil {
+		p.setAuth(httpReq.Header, p.cfg.APIKey)
+	} else if p.cfg.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.cfg.APIKey)
+	}
+	for key, value := range p.extraHeaders {
+		httpReq.Header.Set(key, value)
+	}
+
+	return httpReq, nil
+}
+
+func (p *OpenAIProvider) body(req Request) interface{} {
+	if req.Model == "" {
+		req.Model = p.cfg.Model
+	}
+	if p.wrapBody != nil {
+		return p.wrapBody(req)
+	}
+	return req
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	req.Stream = false
+
+	httpReq, err := p.newRequest(ctx, "POST", p.cfg.Endpoint, p.body(req))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response Response
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w: %s", p.cfg.Name, err, truncate(string(body), 200))
+	}
+
+	return &response, nil
+}
+
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req Request, onDelta func(string)) (*Response, error) {
+	req.Stream = true
+	req.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	httpReq, err := p.newRequest(ctx, "POST", p.cfg.Endpoint, p.body(req))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 300 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	var fullContent bytes.Buffer
+	var toolCalls []ToolCall
+	var usage *Usage
+	finished := false
+	err = readSSE(resp.Body, func(data string) error {
+		var chunk StreamingResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			// Some providers send keep-alive or vendor specific lines, skip them
+			return nil
+		}
+
+		if chunk.Error != nil {
+			return fmt.Errorf("%s", chunk.Error.Message)
+		}
+
+		// With include_usage the last chunk has no choices, only usage
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		if len(chunk.Choices) > 0 {
+			if chunk.Choices[0].Delta.Content != "" {
+				fullContent.WriteString(chunk.Choices[0].Delta.Content)
+				if onDelta != nil {
+					onDelta(chunk.Choices[0].Delta.Content)
+				}
+			}
+			for _, delta := range chunk.Choices[0].Delta.ToolCalls {
+				toolCalls = mergeToolCallDelta(toolCalls, delta)
+			}
+			if chunk.Choices[0].FinishReason != "" {
+				finished = true
+			}
+		}
+		return nil
+	})
+	// Some servers close the stream after finish_reason without sending [DONE]
+	if err == io.ErrUnexpectedEOF && finished {
+		err = nil
+	}
+
+	response := &Response{
+		Choices: []Choice{
+			{Message: Message{Role: "assistant", Content: fullContent.String(), ToolCalls: toolCalls}},
+		},
+		Usage: usage,
+	}
+	return response, err
+}
+
+// Streamed tool call indexes may skip ahead at most this far
+const maxToolCallIndexGap = 8
+
+// mergeToolCallDelta adds a streamed tool call fragment to the calls seen so
+// far. A new index starts a call, later fragments append to its arguments.
+// Indexes that are negative or far past the calls seen are dropped, so a bad
+// stream cannot panic or grow the list without bound.
+func mergeToolCallDelta(calls []ToolCall, delta ToolCallDelta) []ToolCall {
+	if delta.Index < 0 || delta.Index > len(calls)+maxToolCallIndexGap {
+		return calls
+	}
+	for len(calls) <= delta.Index {
+		calls = append(calls, ToolCall{Type: "function"})
+	}
+	call := &calls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	return calls
+}
+
+func (p *OpenAIProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := p.newRequest(ctx, "GET", replaceEndpointPath(p.cfg.Endpoint, "/chat/completions", "/models"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkHTTPStatus(p.cfg.Name, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data []struct {
+			ID            string `json:"id"`
+			Name          string `json:"name"`
+			ContextLength int    `json:"context_length"`
+			ContextWindow int    `json:"context_window"`
+			Pricing       struct {
+				Prompt     string `json:"prompt"`
+				Completion string `json:"completion"`
+			} `json:"pricing"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(result.Data))
+	for _, m := range result.Data {
+		info := ModelInfo{ID: m.ID, Name: m.Name, ContextLength: m.ContextLength}
+		if info.ContextLength == 0 {
+			info.ContextLength = m.ContextWindow
+		}
+		info.PromptPrice, _ = strconv.ParseFloat(m.Pricing.Prompt, 64)
+		info.CompletionPrice, _ = strconv.ParseFloat(m.Pricing.Completion, 64)
+		models = append(models, info)
+	}
+
+	return models, nil
+}
+
+func (p *OpenAIProvider) Embed(ctx context.Context, model, text string) ([]float32, error) {
+	payload := map[string]interface{}{
+		"model": model,
+		"input": []string{text},
+	}
+
+	httpReq, err := p.newRequest(ctx, "POST", replaceEndpointPath(p.cfg.Endpoint, "/chat/completions", "/embeddings"), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embedding API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("no embeddings returned")
+	}
+
+	return result.Data[0].Embedding, nil
+}
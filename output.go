@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Output flags for scripts. --quiet prints only the result, --json prints it
+// as one JSON document and -o writes it to a file.
+var (
+	quietOutput bool
+	jsonOutput  bool
+	outputPath  string
+
+	// resultOut receives answers and command results: stdout or the -o file
+	resultOut = os.Stdout
+	// statusOut receives progress and informational messages. While a
+	// command's result is kept apart they go to stderr, or nowhere with
+	// --quiet. Errors and prompts are always written to stderr.
+	statusOut io.Writer = os.Stdout
+)
+
+// noColor reports whether NO_COLOR asks for output without escape codes
+func noColor() bool {
+	return os.Getenv("NO_COLOR") != ""
+}
+
+// scriptMode is on when the output is read by a program rather than a
+// person: with --quiet, --json, -o, --json-schema or when stdout is piped.
+// A chat then answers once instead of starting the REPL.
+func scriptMode() bool {
+	return quietOutput || jsonOutput || outputPath != "" || cliResponseFormat != nil || !isTerminal(os.Stdout)
+}
+
+// loadOutputFlags removes --quiet, --json and -o from os.Args. Status
+// messages from here on go to stderr in script mode, until startOutput
+// knows whether the command keeps its result apart.
+func loadOutputFlags() {
+	var rest []string
+	args := os.Args
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--quiet" || arg == "-q":
+			quietOutput = true
+		case arg == "--json":
+			jsonOutput = true
+		case arg == "-o" || arg == "--output":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "❌ %s needs a file name\n", arg)
+				os.Exit(1)
+			}
+			outputPath = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--output="):
+			outputPath = strings.TrimPrefix(arg, "--output=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	os.Args = rest
+
+	if jsonOutput && cliResponseFormat != nil {
+		fmt.Fprintln(os.Stderr, "❌ --json cannot be combined with --json-schema")
+		os.Exit(1)
+	}
+	if scriptMode() {
+		statusOut = diagnosticsOut()
+	}
+}
+
+// diagnosticsOut is where status messages go in script mode
+func diagnosticsOut() io.Writer {
+	if quietOutput {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
+// scriptedCommand reports whether the command writes its result to
+// resultOut, and so supports --quiet, --json and -o
+func scriptedCommand(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	sub := ""
+	if len(args) > 2 {
+		sub = args[2]
+	}
+	switch args[1] {
+	case "chat", "history":
+		return true
+	case "rag":
+		return sub == "search"
+	case "memory":
+		return sub == "recall"
+	case "provider":
+		return sub == "list"
+	case "web", "skill", "user", "web-server", "usage", "persona", "compare", "cache", "--help", "-h":
+		return false
+	}
+	return true // a message, chat with the default provider
+}
+
+// startOutput runs before the command. Commands without a separate result
+// print everything to stdout; for the others -o is opened and, in script
+// mode, status messages stay on stderr.
+func startOutput(args []string) {
+	if !scriptedCommand(args) {
+		if quietOutput || jsonOutput || outputPath != "" {
+			fmt.Fprintln(os.Stderr, "❌ --quiet, --json and -o work with chat, rag search, memory recall, history and provider list")
+			os.Exit(1)
+		}
+		statusOut = os.Stdout
+		return
+	}
+
+	if outputPath != "" {
+		file, err := os.Create(expandHome(outputPath))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to open output file: %v\n", err)
+			os.Exit(1)
+		}
+		resultOut = file
+	}
+}
+
+// answerOut is where a chat answer is written as it arrives; with --json
+// it only appears in the final result
+func answerOut() io.Writer {
+	if jsonOutput {
+		return io.Discard
+	}
+	return resultOut
+}
+
+// writeJSON prints a command's result for --json
+func writeJSON(v interface{}) {
+	encoder := json.NewEncoder(resultOut)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write JSON: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ChatResult is the --json result of a chat
+type ChatResult struct {
+	Answer      string          `json:"answer"`
+	Provider    string          `json:"provider"`
+	Model       string          `json:"model"`
+	Usage       *Usage          `json:"usage,omitempty"`
+	SessionID   string          `json:"session_id"`
+	Sources     []ContextSource `json:"sources,omitempty"`
+	Interrupted bool            `json:"interrupted,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// RAGSearchResult is one --json result of `rag search`
+type RAGSearchResult struct {
+	Path       string  `json:"path"`
+	Score      float64 `json:"score"`
+	Owner      string  `json:"owner,omitempty"`
+	Visibility string  `json:"visibility,omitempty"`
+	IndexedAt  string  `json:"indexed_at"`
+	Content    string  `json:"content"`
+}
+
+// MemoryRecallResult is one --json result of `memory recall`
+type MemoryRecallResult struct {
+	Memory
+	Similarity float32 `json:"similarity"`
+}
+
+// SessionSummary is one --json entry of `history list`
+type SessionSummary struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Provider  string `json:"provider"`
+	Model     string `json:"model,omitempty"`
+	Messages  int    `json:"messages"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// ProviderStatus is one --json entry of `provider list`
+type ProviderStatus struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Endpoint string            `json:"endpoint"`
+	Model    string            `json:"model"`
+	Priority int               `json:"priority"`
+	Enabled  bool              `json:"enabled"`
+	Default  bool              `json:"default"`
+	Ready    bool              `json:"ready"`
+	BYOK     bool              `json:"byok,omitempty"`
+	Params   *GenerationParams `json:"params,omitempty"`
+	Health   []ProviderHealth  `json:"health,omitempty"`
+}
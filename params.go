@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GenerationParams controls output length and randomness. Nil fields are
+// left to the provider, so an explicit temperature of 0 is still sent.
+type GenerationParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// cliParams holds the --temperature/--max-tokens/... flags of this run
+var cliParams GenerationParams
+
+// merge returns p with every field that is set in over replacing its own
+func (p GenerationParams) merge(over GenerationParams) GenerationParams {
+	if over.Temperature != nil {
+		p.Temperature = over.Temperature
+	}
+	if over.MaxTokens != nil {
+		p.MaxTokens = over.MaxTokens
+	}
+	if over.TopP != nil {
+		p.TopP = over.TopP
+	}
+	if len(over.Stop) > 0 {
+		p.Stop = over.Stop
+	}
+	if over.Seed != nil {
+		p.Seed = over.Seed
+	}
+	return p
+}
+
+func (p GenerationParams) isZero() bool {
+	return p.Temperature == nil && p.MaxTokens == nil && p.TopP == nil && len(p.Stop) == 0 && p.Seed == nil
+}
+
+// record returns a copy for storing with a message, or nil when nothing is set
+func (p GenerationParams) record() *GenerationParams {
+	if p.isZero() {
+		return nil
+	}
+	return &p
+}
+
+func (p GenerationParams) String() string {
+	var parts []string
+	if p.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *p.Temperature))
+	}
+	if p.MaxTokens != nil {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", *p.MaxTokens))
+	}
+	if p.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *p.TopP))
+	}
+	if len(p.Stop) > 0 {
+		parts = append(parts, fmt.Sprintf("stop=%q", p.Stop))
+	}
+	if p.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *p.Seed))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p GenerationParams) validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p.MaxTokens != nil && *p.MaxTokens <= 0 {
+		return fmt.Errorf("max_tokens must be positive")
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be in (0, 1]")
+	}
+	return nil
+}
+
+// providerParams returns the defaults configured for a provider
+func providerParams(name string) GenerationParams {
+	if config, exists := providerConfig.Providers[name]; exists && config.Params != nil {
+		return *config.Params
+	}
+	return GenerationParams{}
+}
+
+// resolveParams layers skill, session and per-call parameters, later ones
+// winning. Provider defaults are applied underneath by withProviderParams
+// once the provider that serves the request is known.
+func resolveParams(skills []Skill, session *ChatSession, call GenerationParams) GenerationParams {
+	var params GenerationParams
+	for _, skill := range skills {
+		if skill.Params != nil {
+			params = params.merge(*skill.Params)
+		}
+	}
+	if session != nil && session.Params != nil {
+		params = params.merge(*session.Params)
+	}
+	return params.merge(call)
+}
+
+// withProviderParams fills the parameters the request leaves unset with the
+// defaults of the provider about to serve it.
+func withProviderParams(providerName string, req Request) Request {
+	req.GenerationParams = providerParams(providerName).merge(req.GenerationParams)
+	return req
+}
+
+// parseGenerationFlags removes --temperature, --max-tokens, --top-p, --seed
+// and --stop (repeatable) from args and returns the values found.
+func parseGenerationFlags(args []string) ([]string, GenerationParams, error) {
+	var params GenerationParams
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		switch name {
+		case "--temperature", "--max-tokens", "--top-p", "--seed", "--stop":
+		default:
+			rest = append(rest, args[i])
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, params, fmt.Errorf("%s needs a value", name)
+			}
+			value = args[i+1]
+			i++
+		}
+
+		switch name {
+		case "--temperature":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, params, fmt.Errorf("invalid --temperature %q", value)
+			}
+			params.Temperature = &f
+		case "--max-tokens":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, params, fmt.Errorf("invalid --max-tokens %q", value)
+			}
+			params.MaxTokens = &n
+		case "--top-p":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, params, fmt.Errorf("invalid --top-p %q", value)
+			}
+			params.TopP = &f
+		case "--seed":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, params, fmt.Errorf("invalid --seed %q", value)
+			}
+			params.Seed = &n
+		case "--stop":
+			params.Stop = append(params.Stop, value)
+		}
+	}
+
+	return rest, params, params.validate()
+}
+
+func loadGenerationFlags() {
+	args, params, err := parseGenerationFlags(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	os.Args = args
+	cliParams = params
+}
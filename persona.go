@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Persona is a reusable system prompt with its preferred provider, model
+// and generation parameters, stored as ~/.config/terminal-ai/personas/<name>.json
+type Persona struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	System      string            `json:"system"`
+	Provider    string            `json:"provider,omitempty"`
+	Model       string            `json:"model,omitempty"`
+	Params      *GenerationParams `json:"params,omitempty"`
+}
+
+// activePersona and cliSystem come from --persona and --system. An explicit
+// --system replaces the persona's prompt.
+var activePersona *Persona
+var cliSystem string
+
+func getPersonasDir() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, configDir, "personas")
+}
+
+func loadPersona(name string) (*Persona, error) {
+	data, err := os.ReadFile(filepath.Join(getPersonasDir(), name+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("persona '%s' not found", name)
+		}
+		return nil, err
+	}
+
+	var persona Persona
+	if err := json.Unmarshal(data, &persona); err != nil {
+		return nil, fmt.Errorf("invalid persona '%s': %w", name, err)
+	}
+	if persona.Name == "" {
+		persona.Name = name
+	}
+	return &persona, nil
+}
+
+func savePersona(persona Persona) error {
+	os.MkdirAll(getPersonasDir(), 0755)
+
+	data, err := json.MarshalIndent(persona, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(getPersonasDir(), persona.Name+".json"), data, 0644)
+}
+
+func listPersonas() []Persona {
+	entries, err := os.ReadDir(getPersonasDir())
+	if err != nil {
+		return nil
+	}
+
+	var personas []Persona
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if persona, err := loadPersona(strings.TrimSuffix(entry.Name(), ".json")); err == nil {
+			personas = append(personas, *persona)
+		}
+	}
+	sort.Slice(personas, func(i, j int) bool {
+		return personas[i].Name < personas[j].Name
+	})
+	return personas
+}
+
+// systemPrompt returns the system prompt chosen on the command line
+func systemPrompt() string {
+	if cliSystem != "" {
+		return cliSystem
+	}
+	if activePersona != nil {
+		return activePersona.System
+	}
+	return ""
+}
+
+// personaProvider returns the persona's provider, or fallback when it has none
+func personaProvider(fallback string) string {
+	if activePersona != nil && activePersona.Provider != "" {
+		if _, exists := providers[activePersona.Provider]; exists {
+			return activePersona.Provider
+		}
+	}
+	return fallback
+}
+
+// personaModel returns the persona's model when it applies to providerName
+func personaModel(providerName string) string {
+	if activePersona == nil || activePersona.Model == "" {
+		return ""
+	}
+	if activePersona.Provider != "" && activePersona.Provider != providerName {
+		return ""
+	}
+	return activePersona.Model
+}
+
+// withSystemMessage puts the system prompt in front of the conversation
+func withSystemMessage(system string, messages []Message) []Message {
+	if strings.TrimSpace(system) == "" {
+		return messages
+	}
+	return append([]Message{{Role: "system", Content: system}}, messages...)
+}
+
+// loadPersonaFlags removes --system and --persona from os.Args. Persona
+// parameters become defaults under any --temperature/--max-tokens flags.
+func loadPersonaFlags() {
+	var rest []string
+	args := os.Args
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--system" && name != "--persona" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "❌ %s needs a value\n", name)
+				os.Exit(1)
+			}
+			value = args[i+1]
+			i++
+		}
+
+		if name == "--system" {
+			cliSystem = value
+			continue
+		}
+		persona, err := loadPersona(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		activePersona = persona
+	}
+	os.Args = rest
+
+	if activePersona != nil {
+		if activePersona.Params != nil {
+			cliParams = activePersona.Params.merge(cliParams)
+		}
+		fmt.Fprintf(statusOut, "🎭 Persona: %s\n", activePersona.Name)
+	}
+}
+
+func handlePersonaCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: terminal-ai persona list | persona show <name> | persona create <name> | persona delete <name>")
+		os.Exit(1)
+	}
+
+	subCmd := os.Args[2]
+	if subCmd != "list" && len(os.Args) < 4 {
+		fmt.Printf("Usage: terminal-ai persona %s <name>\n", subCmd)
+		os.Exit(1)
+	}
+
+	switch subCmd {
+	case "list":
+		personas := listPersonas()
+		if len(personas) == 0 {
+			fmt.Printf("No personas found in %s\n", getPersonasDir())
+			return
+		}
+		fmt.Println("🎭 Personas:")
+		for _, persona := range personas {
+			target := ""
+			if persona.Provider != "" || persona.Model != "" {
+				target = fmt.Sprintf(" [%s %s]", persona.Provider, persona.Model)
+			}
+			fmt.Printf("  - %s%s: %s\n", persona.Name, target, persona.Description)
+		}
+	case "show":
+		persona, err := loadPersona(os.Args[3])
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("🎭 %s\n", persona.Name)
+		if persona.Description != "" {
+			fmt.Printf("   Description: %s\n", persona.Description)
+		}
+		if persona.Provider != "" {
+			fmt.Printf("   Provider: %s\n", persona.Provider)
+		}
+		if persona.Model != "" {
+			fmt.Printf("   Model: %s\n", persona.Model)
+		}
+		if persona.Params != nil {
+			fmt.Printf("   Params: %s\n", persona.Params)
+		}
+		fmt.Printf("\n%s\n", persona.System)
+	case "create":
+		createPersona(os.Args[3])
+	case "delete":
+		if err := os.Remove(filepath.Join(getPersonasDir(), os.Args[3]+".json")); err != nil {
+			fmt.Printf("❌ Failed to delete persona: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Persona '%s' deleted\n", os.Args[3])
+	default:
+		fmt.Println("Unknown persona command. Use: list | show | create | delete")
+	}
+}
+
+func createPersona(name string) {
+	reader := bufio.NewReader(os.Stdin)
+	ask := func(prompt string) string {
+		fmt.Print(prompt)
+		value, _ := reader.ReadString('\n')
+		return strings.TrimSpace(value)
+	}
+
+	persona := Persona{Name: name}
+	persona.Description = ask("Description: ")
+	persona.System = ask("System prompt: ")
+	persona.Provider = ask("Provider (empty for default): ")
+	persona.Model = ask("Model (empty for provider default): ")
+
+	if persona.System == "" {
+		fmt.Println("❌ System prompt is required")
+		os.Exit(1)
+	}
+	if persona.Provider != "" {
+		if _, exists := providers[persona.Provider]; !exists {
+			fmt.Printf("❌ Unknown provider: %s\n", persona.Provider)
+			os.Exit(1)
+		}
+	}
+
+	if err := savePersona(persona); err != nil {
+		fmt.Printf("❌ Failed to save persona: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Persona '%s' created in %s\n", name, getPersonasDir())
+}
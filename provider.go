@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Provider is implemented by every chat backend. Adding a new backend means
+// writing one implementation and registering its factory in providerFactories.
+type Provider interface {
+	Name() string
+	Chat(ctx context.Context, req Request) (*Response, error)
+	ChatStream(ctx context.Context, req Request, onDelta func(string)) (*Response, error)
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+	Embed(ctx context.Context, model, text string) ([]float32, error)
+}
+
+type ModelInfo struct {
+	ID              string  `json:"id"`
+	Name            string  `json:"name,omitempty"`
+	ContextLength   int     `json:"context_length,omitempty"`
+	PromptPrice     float64 `json:"prompt_price,omitempty"`
+	CompletionPrice float64 `json:"completion_price,omitempty"`
+}
+
+type ProviderFactory func(cfg AIProvider) Provider
+
+// providerFactories maps a provider type to its constructor. Providers whose
+// config has no explicit type use their own name when it is registered here,
+// and fall back to the generic OpenAI-compatible client otherwise.
+var providerFactories = map[string]ProviderFactory{
+	"openai":     newOpenAIProvider,
+	"openrouter": newOpenRouterProvider,
+	"gemini":     newGeminiProvider,
+	"anthropic":  newAnthropicProvider,
+	"ollama":     newOllamaProvider,
+	"local":      newLocalProvider,
+}
+
+// keylessProviderTypes run against local servers and need no API key
+var keylessProviderTypes = map[string]bool{
+	"ollama": true,
+	"local":  true,
+}
+
+// providerReady reports whether a provider has the credentials it needs
+func providerReady(p AIProvider) bool {
+	return p.APIKey != "" || keylessProviderTypes[p.Type]
+}
+
+func resolveProviderType(name string, config AIProviderConfig) string {
+	if config.Type != "" {
+		return config.Type
+	}
+	if _, ok := providerFactories[name]; ok {
+		return name
+	}
+	return "openai"
+}
+
+func getProvider(name string) (Provider, error) {
+	cfg, exists := providers[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+
+	kind := cfg.Type
+	if kind == "" {
+		kind = resolveProviderType(name, providerConfig.Providers[name])
+	}
+
+	factory, ok := providerFactories[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider type %q for %s", kind, name)
+	}
+	return factory(cfg), nil
+}
+
+// errStreamDone can be returned by a readSSE callback when the provider
+// signals the end of the stream with its own event instead of [DONE].
+var errStreamDone = errors.New("stream done")
+
+// readSSE calls fn with the payload of every "data:" line in an SSE stream
+// until a [DONE] marker is seen or fn returns an error. A stream that ends
+// without a completion marker returns io.ErrUnexpectedEOF so callers can tell
+// a finished answer from a dropped connection.
+func readSSE(body io.Reader, fn func(data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+
+		if err := fn(data); err != nil {
+			if err == errStreamDone {
+				return nil
+			}
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// checkHTTPStatus turns a non-2xx response into a ProviderError carrying the
+// provider's error message, so it is never parsed as a normal reply.
+func checkHTTPStatus(provider string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return newHTTPError(provider, resp, body)
+}
+
+// extractErrorMessage understands the common error body shapes:
+// {"error": {"message": ...}}, {"error": "..."} and {"message": ...}.
+func extractErrorMessage(body []byte) string {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(parsed.Error, &nested) == nil && nested.Message != "" {
+			return nested.Message
+		}
+		var plain string
+		if json.Unmarshal(parsed.Error, &plain) == nil && plain != "" {
+			return plain
+		}
+		if parsed.Message != "" {
+			return parsed.Message
+		}
+	}
+
+	text := strings.TrimSpace(string(body))
+	if len(text) > 300 {
+		text = text[:300] + "..."
+	}
+	if text == "" {
+		text = "empty response body"
+	}
+	return text
+}
+
+// replaceEndpointPath swaps the trailing path of a chat endpoint, e.g.
+// ".../v1/chat/completions" becomes ".../v1/models".
+func replaceEndpointPath(endpoint, suffix, replacement string) string {
+	if idx := strings.LastIndex(endpoint, suffix); idx >= 0 {
+		return endpoint[:idx] + replacement
+	}
+	return strings.TrimRight(endpoint, "/") + replacement
+}
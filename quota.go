@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quota limits one user or role. Zero means unlimited.
+type Quota struct {
+	RequestsPerDay  int     `json:"requests_per_day,omitempty"`
+	TokensPerMonth  int     `json:"tokens_per_month,omitempty"`
+	MaxCostPerMonth float64 `json:"max_cost_per_month,omitempty"`
+}
+
+// QuotaConfig is stored in ~/.config/terminal-ai/quotas.json. A user entry
+// overrides the matching fields of its role; anonymous /api/chat/public
+// traffic is accounted as the user "@public" with the role "public".
+type QuotaConfig struct {
+	Enabled bool             `json:"enabled"`
+	Roles   map[string]Quota `json:"roles"`
+	Users   map[string]Quota `json:"users"`
+}
+
+// QuotaStatus is returned to clients that hit a limit
+type QuotaStatus struct {
+	User      string                 `json:"user"`
+	Role      string                 `json:"role"`
+	Limits    Quota                  `json:"limits"`
+	Used      QuotaUsage             `json:"used"`
+	Remaining map[string]interface{} `json:"remaining"`
+	Exceeded  string                 `json:"exceeded,omitempty"`
+	ResetsAt  string                 `json:"resets_at,omitempty"`
+}
+
+type QuotaUsage struct {
+	RequestsToday   int     `json:"requests_today"`
+	TokensThisMonth int     `json:"tokens_this_month"`
+	CostThisMonth   float64 `json:"cost_this_month"`
+}
+
+const (
+	// PublicUser is the ledger name of anonymous traffic. Usernames starting
+	// with "@" cannot be created, so no account shares its quota.
+	PublicUser = "@public"
+	PublicRole = "public"
+)
+
+var quotaConfig QuotaConfig
+
+// quotaCounter is one user's usage this month and on its latest day
+type quotaCounter struct {
+	day      string // YYYY-MM-DD of the requests count
+	requests int
+	tokens   int
+	cost     float64
+}
+
+// The counters are seeded from the ledger once per month and then kept
+// current by recordUsage, so a quota check does not read the ledger.
+var (
+	quotaMu       sync.Mutex
+	quotaMonth    string // YYYY-MM of the counters, "" until seeded
+	quotaCounters map[string]*quotaCounter
+)
+
+func getQuotaConfigPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, configDir, "quotas.json")
+}
+
+func defaultQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		Enabled: true,
+		Roles: map[string]Quota{
+			"admin":    {},
+			"user":     {RequestsPerDay: 500, TokensPerMonth: 5000000},
+			PublicRole: {RequestsPerDay: 100, TokensPerMonth: 500000, MaxCostPerMonth: 5},
+		},
+		Users: map[string]Quota{},
+	}
+}
+
+func loadQuotaConfig() error {
+	path := getQuotaConfigPath()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			quotaConfig = defaultQuotaConfig()
+			return saveQuotaConfig()
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(data, &quotaConfig); err != nil {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return nil
+}
+
+func saveQuotaConfig() error {
+	path := getQuotaConfigPath()
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	data, err := json.MarshalIndent(quotaConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func userRole(username string) string {
+	if username == PublicUser {
+		return PublicRole
+	}
+	if securityMgr != nil {
+		if user, exists := securityMgr.users[username]; exists && user.Role != "" {
+			return user.Role
+		}
+	}
+	return "user"
+}
+
+// quotaFor merges the role quota with the user's own overrides
+func quotaFor(username, role string) Quota {
+	quota := quotaConfig.Roles[role]
+	if custom, exists := quotaConfig.Users[username]; exists {
+		if custom.RequestsPerDay != 0 {
+			quota.RequestsPerDay = custom.RequestsPerDay
+		}
+		if custom.TokensPerMonth != 0 {
+			quota.TokensPerMonth = custom.TokensPerMonth
+		}
+		if custom.MaxCostPerMonth != 0 {
+			quota.MaxCostPerMonth = custom.MaxCostPerMonth
+		}
+	}
+	return quota
+}
+
+// checkQuota compares the user's usage ledger entries against their quota.
+// The returned status has Exceeded set when a limit is reached.
+func checkQuota(username string) (*QuotaStatus, error) {
+	return checkQuotaFor(username, 1)
+}
+
+// checkQuotaFor is checkQuota for a call that sends several requests at once,
+// the daily request limit must leave room for all of them
+func checkQuotaFor(username string, requests int) (*QuotaStatus, error) {
+	role := userRole(username)
+	status := &QuotaStatus{
+		User:      username,
+		Role:      role,
+		Limits:    quotaFor(username, role),
+		Remaining: map[string]interface{}{},
+	}
+	if !quotaConfig.Enabled {
+		return status, nil
+	}
+
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	used, err := quotaUsage(username, now)
+	if err != nil {
+		return nil, err
+	}
+	status.Used = used
+
+	limits := status.Limits
+	nextDay := dayStart.AddDate(0, 0, 1)
+	nextMonth := monthStart.AddDate(0, 1, 0)
+
+	if limits.RequestsPerDay > 0 {
+		remaining := limits.RequestsPerDay - status.Used.RequestsToday
+		status.Remaining["requests_today"] = max(remaining, 0)
+		if remaining < requests {
+			status.Exceeded = "requests_per_day"
+			status.ResetsAt = nextDay.Format(time.RFC3339)
+		}
+	}
+	if limits.TokensPerMonth > 0 {
+		remaining := limits.TokensPerMonth - status.Used.TokensThisMonth
+		status.Remaining["tokens_this_month"] = max(remaining, 0)
+		if remaining <= 0 && status.Exceeded == "" {
+			status.Exceeded = "tokens_per_month"
+			status.ResetsAt = nextMonth.Format(time.RFC3339)
+		}
+	}
+	if limits.MaxCostPerMonth > 0 {
+		remaining := limits.MaxCostPerMonth - status.Used.CostThisMonth
+		status.Remaining["cost_this_month"] = max(remaining, 0)
+		if remaining <= 0 && status.Exceeded == "" {
+			status.Exceeded = "max_cost_per_month"
+			status.ResetsAt = nextMonth.Format(time.RFC3339)
+		}
+	}
+
+	return status, nil
+}
+
+// quotaUsage returns what the user used today and this month
+func quotaUsage(username string, now time.Time) (QuotaUsage, error) {
+	month := now.Format("2006-01")
+
+	quotaMu.Lock()
+	seeded := quotaMonth == month
+	quotaMu.Unlock()
+	if !seeded {
+		// Lock order is ledger then counters, as in recordUsage
+		usageLedgerMu.Lock()
+		quotaMu.Lock()
+		var err error
+		if quotaMonth != month {
+			err = seedQuotaCounters(now)
+		}
+		quotaMu.Unlock()
+		usageLedgerMu.Unlock()
+		if err != nil {
+			return QuotaUsage{}, err
+		}
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	var used QuotaUsage
+	if c, exists := quotaCounters[username]; exists {
+		used.TokensThisMonth = c.tokens
+		used.CostThisMonth = c.cost
+		if c.day == now.Format("2006-01-02") {
+			used.RequestsToday = c.requests
+		}
+	}
+	return used, nil
+}
+
+// seedQuotaCounters reads this month's ledger. It must be called with
+// usageLedgerMu and quotaMu held.
+func seedQuotaCounters(now time.Time) error {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	records, err := readUsageRecords(monthStart)
+	if err != nil {
+		return err
+	}
+
+	quotaMonth = now.Format("2006-01")
+	quotaCounters = map[string]*quotaCounter{}
+	for _, record := range records {
+		countQuotaRecord(record)
+	}
+	return nil
+}
+
+// countQuotaUsage adds a record just written to the ledger. recordUsage calls
+// it with usageLedgerMu held.
+func countQuotaUsage(record UsageRecord) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if quotaMonth != "" {
+		countQuotaRecord(record)
+	}
+}
+
+// countQuotaRecord must be called with quotaMu held. Records of another
+// month are left for the next seeding.
+func countQuotaRecord(record UsageRecord) {
+	ts, err := time.Parse(time.RFC3339, record.Timestamp)
+	if err != nil {
+		return
+	}
+	ts = ts.Local()
+	if ts.Format("2006-01") != quotaMonth {
+		return
+	}
+
+	c, exists := quotaCounters[record.User]
+	if !exists {
+		c = &quotaCounter{}
+		quotaCounters[record.User] = c
+	}
+	c.tokens += record.TotalTokens
+	c.cost += record.Cost
+
+	switch day := ts.Format("2006-01-02"); {
+	case day == c.day:
+		c.requests++
+	case day > c.day:
+		c.day = day
+		c.requests = 1
+	}
+}
+
+// enforceQuota writes a 429 with the remaining budget and returns false when
+// the user may not make another request.
+func enforceQuota(w http.ResponseWriter, username string) bool {
+	return enforceQuotaFor(w, username, 1)
+}
+
+// enforceQuotaFor is enforceQuota for a handler that makes several requests
+func enforceQuotaFor(w http.ResponseWriter, username string, requests int) bool {
+	status, err := checkQuotaFor(username, requests)
+	if err != nil {
+		// Never block users because the ledger could not be read
+		fmt.Fprintf(os.Stderr, "⚠️  Quota check failed: %v\n", err)
+		return true
+	}
+	if status.Exceeded == "" {
+		return true
+	}
+
+	if status.ResetsAt != "" {
+		if reset, err := time.Parse(time.RFC3339, status.ResetsAt); err == nil {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(time.Until(reset).Seconds())+1))
+		}
+	}
+	sendJSONResponse(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error": fmt.Sprintf("Quota exceeded (%s) for %s", status.Exceeded, username),
+		"quota": status,
+	})
+	return false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withQuotas gives the test an empty ledger and unseeded counters
+func withQuotas(t *testing.T, config QuotaConfig) {
+	t.Helper()
+	isolateDataDir(t)
+	swapGlobal(t, &quotaConfig, config)
+	swapGlobal(t, &quotaMonth, "")
+	swapGlobal(t, &quotaCounters, nil)
+}
+
+func writeLedger(t *testing.T, records ...UsageRecord) {
+	t.Helper()
+	var lines []string
+	for _, r := range records {
+		data, _ := json.Marshal(r)
+		lines = append(lines, string(data))
+	}
+	os.MkdirAll(getDataDir(), 0755)
+	if err := os.WriteFile(getUsageLedgerPath(), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckQuotaSeedsFromLedger(t *testing.T) {
+	withQuotas(t, QuotaConfig{Enabled: true, Roles: map[string]Quota{
+		"user": {RequestsPerDay: 10, TokensPerMonth: 1000},
+	}})
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	writeLedger(t,
+		UsageRecord{Timestamp: monthStart.AddDate(0, 0, -1).Format(time.RFC3339), User: "alice", TotalTokens: 999},
+		UsageRecord{Timestamp: monthStart.Format(time.RFC3339), User: "alice", TotalTokens: 100, Cost: 0.5},
+		UsageRecord{Timestamp: now.Format(time.RFC3339), User: "alice", TotalTokens: 50},
+		UsageRecord{Timestamp: now.Format(time.RFC3339), User: "bob", TotalTokens: 700},
+	)
+
+	status, err := checkQuota("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRequests := 1
+	if now.Day() == 1 {
+		wantRequests = 2 // the month start record is also today
+	}
+	if status.Used.TokensThisMonth != 150 || status.Used.RequestsToday != wantRequests || status.Used.CostThisMonth != 0.5 {
+		t.Errorf("used = %+v", status.Used)
+	}
+	if status.Exceeded != "" {
+		t.Errorf("exceeded = %s", status.Exceeded)
+	}
+
+	// Later requests are counted without reading the ledger again
+	os.Remove(getUsageLedgerPath())
+	recordUsage(withUsageScope(context.Background(), "alice", ""), "p", "m", &Usage{TotalTokens: 900}, false)
+	status, _ = checkQuota("alice")
+	if status.Used.TokensThisMonth != 1050 || status.Exceeded != "tokens_per_month" {
+		t.Errorf("after a new request used = %+v exceeded = %q", status.Used, status.Exceeded)
+	}
+	if status.ResetsAt == "" {
+		t.Error("an exceeded quota needs resets_at")
+	}
+}
+
+func TestCheckQuotaRequestsPerDay(t *testing.T) {
+	withQuotas(t, QuotaConfig{Enabled: true, Roles: map[string]Quota{"user": {RequestsPerDay: 2}}})
+
+	ctx := withUsageScope(context.Background(), "alice", "")
+	for i := 0; i < 2; i++ {
+		status, _ := checkQuota("alice")
+		if status.Exceeded != "" {
+			t.Fatalf("request %d refused: %+v", i+1, status)
+		}
+		recordUsage(ctx, "p", "m", nil, false)
+	}
+
+	status, _ := checkQuota("alice")
+	if status.Exceeded != "requests_per_day" || status.Remaining["requests_today"] != 0 {
+		t.Errorf("status = %+v", status)
+	}
+	if other, _ := checkQuota("bob"); other.Exceeded != "" {
+		t.Errorf("bob shares alice's quota: %+v", other)
+	}
+}
+
+func TestCheckQuotaForSeveralRequests(t *testing.T) {
+	withQuotas(t, QuotaConfig{Enabled: true, Roles: map[string]Quota{"user": {RequestsPerDay: 3}}})
+	recordUsage(withUsageScope(context.Background(), "alice", ""), "p", "m", nil, false)
+
+	if status, _ := checkQuotaFor("alice", 2); status.Exceeded != "" {
+		t.Errorf("two requests fit the remaining two: %+v", status)
+	}
+	status, _ := checkQuotaFor("alice", 3)
+	if status.Exceeded != "requests_per_day" || status.Remaining["requests_today"] != 2 || status.ResetsAt == "" {
+		t.Errorf("three requests with two left: %+v", status)
+	}
+}
+
+func TestPublicQuotaIsSeparate(t *testing.T) {
+	withQuotas(t, QuotaConfig{Enabled: true, Roles: map[string]Quota{
+		"user":     {RequestsPerDay: 100},
+		PublicRole: {RequestsPerDay: 1},
+	}})
+
+	recordUsage(withUsageScope(context.Background(), PublicUser, ""), "p", "m", nil, false)
+
+	anonymous, _ := checkQuota(PublicUser)
+	if anonymous.Role != PublicRole || anonymous.Exceeded != "requests_per_day" {
+		t.Errorf("anonymous status = %+v", anonymous)
+	}
+	// An account that happens to be called "public" has its own quota
+	named, _ := checkQuota("public")
+	if named.Role != "user" || named.Used.RequestsToday != 0 || named.Exceeded != "" {
+		t.Errorf("user public status = %+v", named)
+	}
+}
+
+func TestQuotaUserOverride(t *testing.T) {
+	withQuotas(t, QuotaConfig{
+		Enabled: true,
+		Roles:   map[string]Quota{"user": {RequestsPerDay: 5, TokensPerMonth: 100}},
+		Users:   map[string]Quota{"alice": {TokensPerMonth: 1000}},
+	})
+
+	if q := quotaFor("alice", "user"); q.RequestsPerDay != 5 || q.TokensPerMonth != 1000 {
+		t.Errorf("alice quota = %+v", q)
+	}
+	if q := quotaFor("bob", "user"); q.TokensPerMonth != 100 {
+		t.Errorf("bob quota = %+v", q)
+	}
+}
+
+func TestQuotaDisabled(t *testing.T) {
+	withQuotas(t, QuotaConfig{Enabled: false, Roles: map[string]Quota{"user": {RequestsPerDay: 1}}})
+	writeLedger(t, UsageRecord{Timestamp: time.Now().Format(time.RFC3339), User: "alice"})
+
+	status, err := checkQuota("alice")
+	if err != nil || status.Exceeded != "" {
+		t.Errorf("disabled quota: %+v, %v", status, err)
+	}
+}
+
+func TestReservedUsername(t *testing.T) {
+	sm := &SecurityManager{users: map[string]User{}}
+	if err := sm.CreateUser(PublicUser, "pw", "user"); err == nil {
+		t.Error("creating the anonymous user name should fail")
+	}
+}
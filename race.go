@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fallback strategies
+const (
+	StrategySequential = "sequential"
+	StrategyRace       = "race"
+	StrategyHedged     = "hedged"
+)
+
+const (
+	DefaultRaceProviders = 2
+	DefaultHedgeDelayMs  = 2000
+)
+
+func fallbackStrategy() string {
+	switch providerConfig.FallbackStrategy {
+	case StrategyRace, StrategyHedged:
+		if providerConfig.FallbackEnabled {
+			return providerConfig.FallbackStrategy
+		}
+	}
+	return StrategySequential
+}
+
+// raceCandidates returns the first ready providers of a fallback order,
+// at most race_providers of them.
+func raceCandidates(order []string) []string {
+	limit := providerConfig.RaceProviders
+	if limit <= 0 {
+		limit = DefaultRaceProviders
+	}
+
+	var candidates []string
+	for _, name := range order {
+		if len(candidates) == limit {
+			break
+		}
+		if providerReady(providers[name]) {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates
+}
+
+func hedgeDelay() time.Duration {
+	if providerConfig.HedgeDelayMs > 0 {
+		return time.Duration(providerConfig.HedgeDelayMs) * time.Millisecond
+	}
+	return DefaultHedgeDelayMs * time.Millisecond
+}
+
+// raceAttempt uses the requested model for the primary and each other
+// provider's own model, like sequential fallback does.
+func raceAttempt(name, primary string, req Request) Request {
+	if name != primary {
+		req.Model = providers[name].Model
+	}
+	return req
+}
+
+// raceOutcome is what one raced provider produced. Received is the output
+// that reached the caller, which only the winner has.
+type raceOutcome struct {
+	provider string
+	response *Response
+	received string
+	err      error
+}
+
+// runRace starts the candidates all at once (race) or one after another
+// whenever the running ones stay silent for the hedge delay (hedged). A
+// failure starts the next candidate straight away. attempt must call claim
+// once it has something to show; the first provider to claim wins and every
+// other one is cancelled. tried lists the providers that were started.
+func runRace(ctx context.Context, candidates []string, strategy string, attempt func(ctx context.Context, name string, claim func() bool) raceOutcome) (outcome raceOutcome, tried []string, err error) {
+	raceCtx, cancelAll := context.WithCancel(ctx)
+	defer cancelAll()
+
+	var mu sync.Mutex
+	winner := ""
+	cancels := map[string]context.CancelFunc{}
+	results := make(chan raceOutcome, len(candidates))
+
+	// claim makes name the winner unless another provider already is
+	claim := func(name string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if winner == "" {
+			winner = name
+			for other, cancelOther := range cancels {
+				if other != name {
+					cancelOther()
+				}
+			}
+			if len(cancels) > 1 {
+				fmt.Fprintf(statusOut, "🏆 %s answered first\n", name)
+			}
+		}
+		return winner == name
+	}
+
+	launch := func(name string) {
+		attemptCtx, cancel := context.WithCancel(raceCtx)
+		mu.Lock()
+		cancels[name] = cancel
+		mu.Unlock()
+		tried = append(tried, name)
+
+		go func() {
+			defer cancel()
+			results <- attempt(attemptCtx, name, func() bool { return claim(name) })
+		}()
+	}
+
+	currentWinner := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return winner
+	}
+
+	next := len(candidates)
+	if strategy == StrategyHedged {
+		next = 1
+	}
+	if strategy == StrategyRace && len(candidates) > 1 {
+		fmt.Fprintf(statusOut, "🏁 Racing %s\n", strings.Join(candidates, ", "))
+	}
+	for _, name := range candidates[:next] {
+		launch(name)
+	}
+	running := next
+
+	var hedge <-chan time.Time
+	if next < len(candidates) {
+		hedge = time.After(hedgeDelay())
+	}
+
+	var lastError error
+	for running > 0 {
+		select {
+		case out := <-results:
+			running--
+			if out.err == nil {
+				// A loser that finished before it was cancelled is ignored
+				if claim(out.provider) {
+					return out, tried, nil
+				}
+				continue
+			}
+
+			switch currentWinner() {
+			case out.provider:
+				// The winner died after output started
+				return out, tried, out.err
+			case "":
+			default:
+				// Cancelled because another provider won
+				continue
+			}
+			if ctx.Err() != nil {
+				return out, tried, ctx.Err()
+			}
+			lastError = out.err
+			fmt.Fprintf(statusOut, "   ⚠️  %v\n", out.err)
+
+			if currentWinner() == "" && next < len(candidates) {
+				launch(candidates[next])
+				next++
+				running++
+			}
+		case <-hedge:
+			hedge = nil
+			if currentWinner() == "" && next < len(candidates) {
+				fmt.Fprintf(statusOut, "⏱️  No answer after %s, also trying %s\n", hedgeDelay(), candidates[next])
+				launch(candidates[next])
+				next++
+				running++
+				if next < len(candidates) {
+					hedge = time.After(hedgeDelay())
+				}
+			}
+		}
+	}
+
+	if lastError == nil {
+		lastError = fmt.Errorf("no provider is ready")
+	}
+	return raceOutcome{}, tried, lastError
+}
+
+// raceRequest runs a non-streaming request with the race or hedged strategy.
+// The first complete answer wins.
+func raceRequest(ctx context.Context, req Request, primary string, order []string, strategy string) (*Response, string, []string, error) {
+	outcome, tried, err := runRace(ctx, raceCandidates(order), strategy, func(ctx context.Context, name string, claim func() bool) raceOutcome {
+		response, err := makeRequest(ctx, name, raceAttempt(name, primary, req))
+		if perr := toProviderError(name, err, response); perr != nil {
+			return raceOutcome{provider: name, err: perr}
+		}
+		return raceOutcome{provider: name, response: response}
+	})
+	if err != nil {
+		return nil, "", tried, err
+	}
+	fmt.Fprintf(statusOut, "✅ Success with provider: %s\n", outcome.provider)
+	return outcome.response, outcome.provider, tried, nil
+}
+
+// raceStream runs a streaming request with the race or hedged strategy. The
+// first provider to produce a token is streamed to onDelta, the others are
+// cancelled. When the winner dies mid-stream the partial output is returned
+// with the error so the caller can continue it elsewhere.
+func raceStream(ctx context.Context, req Request, primary string, order []string, strategy string, onDelta func(string)) (*Response, string, string, []string, error) {
+	outcome, tried, err := runRace(ctx, raceCandidates(order), strategy, func(ctx context.Context, name string, claim func() bool) raceOutcome {
+		streamer, err := getProvider(name)
+		if err != nil {
+			return raceOutcome{provider: name, err: err}
+		}
+
+		attempt := withProviderParams(name, raceAttempt(name, primary, req))
+		model := attempt.Model
+		if model == "" {
+			model = providers[name].Model
+		}
+
+		if !claimProbe(name, model) {
+			return raceOutcome{provider: name, err: errProbeInFlight(name)}
+		}
+		var received strings.Builder
+		start := time.Now()
+		response, err := streamer.ChatStream(ctx, attempt, func(chunk string) {
+			if !claim() {
+				return
+			}
+			received.WriteString(chunk)
+			if onDelta != nil {
+				onDelta(chunk)
+			}
+		})
+		recordProviderResult(name, model, time.Since(start), toProviderError(name, err, nil))
+		if err != nil {
+			return raceOutcome{provider: name, received: received.String(), err: toProviderError(name, err, nil)}
+		}
+
+		var usage *Usage
+		if response != nil {
+			usage = response.Usage
+		}
+		recordUsage(ctx, name, model, usage, true)
+		if response == nil {
+			response = &Response{}
+		}
+		response.Choices = []Choice{{Message: Message{Role: "assistant", Content: received.String(), ToolCalls: toolCallsOf(response)}}}
+		return raceOutcome{provider: name, response: response, received: received.String()}
+	})
+	return outcome.response, outcome.provider, outcome.received, tried, err
+}
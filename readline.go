@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxInputHistory is how many REPL inputs are kept in input_history
+const MaxInputHistory = 500
+
+// errLineInterrupted is returned when Ctrl+C discards the line being typed
+var errLineInterrupted = errors.New("line interrupted")
+
+// lineReader reads REPL input. On a terminal it edits the line in raw mode
+// with cursor movement and Up/Down history; otherwise it reads plain lines.
+// Lines pasted in one go are kept together as a single multi-line input.
+type lineReader struct {
+	in       *bufio.Reader
+	terminal bool
+	history  []string
+	// completions are offered on Tab for input starting with "/"
+	completions []string
+}
+
+func newLineReader(completions []string) *lineReader {
+	return &lineReader{
+		in:          bufio.NewReader(os.Stdin),
+		terminal:    isTerminal(os.Stdin) && isTerminal(os.Stdout),
+		history:     loadInputHistory(),
+		completions: completions,
+	}
+}
+
+func getInputHistoryPath() string {
+	return filepath.Join(getDataDir(), "input_history")
+}
+
+// loadInputHistory reads one JSON string per line so multi-line inputs
+// survive the round trip
+func loadInputHistory() []string {
+	data, err := os.ReadFile(getInputHistoryPath())
+	if err != nil {
+		return nil
+	}
+	var history []string
+	for _, line := range strings.Split(string(data), "\n") {
+		var entry string
+		if json.Unmarshal([]byte(line), &entry) == nil && entry != "" {
+			history = append(history, entry)
+		}
+	}
+	return history
+}
+
+func (lr *lineReader) addHistory(input string) {
+	if strings.TrimSpace(input) == "" {
+		return
+	}
+	if n := len(lr.history); n > 0 && lr.history[n-1] == input {
+		return
+	}
+	lr.history = append(lr.history, input)
+	if len(lr.history) > MaxInputHistory {
+		lr.history = lr.history[len(lr.history)-MaxInputHistory:]
+	}
+
+	var sb strings.Builder
+	for _, entry := range lr.history {
+		data, _ := json.Marshal(entry)
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
+	os.MkdirAll(getDataDir(), 0755)
+	os.WriteFile(getInputHistoryPath(), []byte(sb.String()), 0600)
+}
+
+// readLine returns the next line without its newline. io.EOF means Ctrl+D
+// on an empty line or the end of piped input.
+func (lr *lineReader) readLine(prompt string) (string, error) {
+	if lr.terminal {
+		if restore, err := makeRaw(os.Stdin); err == nil {
+			defer restore()
+			return lr.editLine(prompt)
+		}
+	}
+
+	fmt.Print(prompt)
+	line, err := lr.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			fmt.Println()
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (lr *lineReader) editLine(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	histPos := len(lr.history)
+	draft := ""
+
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+
+	lr.redraw(prompt, buf, pos)
+	for {
+		r, _, err := lr.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			// More input already waiting means a paste: keep the newline
+			if lr.in.Buffered() > 0 {
+				buf = insertRunes(buf, pos, '\n')
+				pos++
+				break
+			}
+			pos = len(buf)
+			lr.redraw(prompt, buf, pos)
+			fmt.Print("\r\n")
+			return string(buf), nil
+		case 3: // Ctrl+C
+			fmt.Print("^C\r\n")
+			return "", errLineInterrupted
+		case 4: // Ctrl+D
+			if len(buf) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl+A
+			pos = 0
+		case 5: // Ctrl+E
+			pos = len(buf)
+		case 2: // Ctrl+B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl+F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl+K
+			buf = buf[:pos]
+		case 21: // Ctrl+U
+			buf = buf[pos:]
+			pos = 0
+		case 23: // Ctrl+W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // Ctrl+L
+			fmt.Print("\x1b[H\x1b[2J")
+		case 16, 14: // Ctrl+P, Ctrl+N
+			histPos, draft = lr.browseHistory(r == 16, histPos, draft, string(buf), setLine)
+		case '\t':
+			if completed, ok := lr.complete(string(buf)); ok {
+				setLine(completed)
+			}
+		case 27: // Escape sequence
+			switch lr.readEscape() {
+			case "A":
+				histPos, draft = lr.browseHistory(true, histPos, draft, string(buf), setLine)
+			case "B":
+				histPos, draft = lr.browseHistory(false, histPos, draft, string(buf), setLine)
+			case "C":
+				if pos < len(buf) {
+					pos++
+				}
+			case "D":
+				if pos > 0 {
+					pos--
+				}
+			case "H", "1~", "7~":
+				pos = 0
+			case "F", "4~", "8~":
+				pos = len(buf)
+			case "3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= 32 {
+				buf = insertRunes(buf, pos, r)
+				pos++
+			}
+		}
+		lr.redraw(prompt, buf, pos)
+	}
+}
+
+// readEscape reads the rest of an ESC [ or ESC O sequence and returns its
+// parameters and final byte, e.g. "A" for Up or "3~" for Delete
+func (lr *lineReader) readEscape() string {
+	b, err := lr.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return ""
+	}
+	var seq []byte
+	for {
+		b, err := lr.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7e {
+			return string(seq)
+		}
+	}
+}
+
+// browseHistory moves one entry up or down, remembering the line being
+// typed so Down past the newest entry brings it back
+func (lr *lineReader) browseHistory(up bool, histPos int, draft, current string, setLine func(string)) (int, string) {
+	if histPos == len(lr.history) {
+		draft = current
+	}
+	if up && histPos > 0 {
+		histPos--
+	} else if !up && histPos < len(lr.history) {
+		histPos++
+	} else {
+		return histPos, draft
+	}
+	if histPos == len(lr.history) {
+		setLine(draft)
+	} else {
+		setLine(lr.history[histPos])
+	}
+	return histPos, draft
+}
+
+// complete extends a slash command to the longest common prefix of the
+// matching completions
+func (lr *lineReader) complete(input string) (string, bool) {
+	if !strings.HasPrefix(input, "/") || strings.Contains(input, " ") {
+		return "", false
+	}
+	var matches []string
+	for _, c := range lr.completions {
+		if strings.HasPrefix(c, input) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(matches) == 1 {
+		prefix += " "
+	}
+	return prefix, prefix != input
+}
+
+// redraw repaints the prompt and line. A line wider than the terminal
+// scrolls horizontally so the cursor stays visible; newlines from pastes
+// are shown as ↵.
+func (lr *lineReader) redraw(prompt string, buf []rune, pos int) {
+	width := terminalWidth(os.Stdout) - len([]rune(prompt)) - 1
+	if width < 10 {
+		width = 10
+	}
+	start := 0
+	if pos > width {
+		start = pos - width
+	}
+	end := len(buf)
+	if end-start > width {
+		end = start + width
+	}
+
+	visible := strings.ReplaceAll(string(buf[start:end]), "\n", "↵")
+	fmt.Printf("\r%s%s\x1b[K", prompt, visible)
+	if back := end - pos; back > 0 {
+		fmt.Printf("\x1b[%dD", back)
+	}
+}
+
+func insertRunes(buf []rune, pos int, r rune) []rune {
+	buf = append(buf, 0)
+	copy(buf[pos+1:], buf[pos:])
+	buf[pos] = r
+	return buf
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Answers are printed in one of these modes, chosen with --render
+const (
+	RenderMarkdown = "markdown" // styled headings, lists, tables and highlighted code
+	RenderPlain    = "plain"    // the same layout without escape codes
+	RenderRaw      = "raw"      // the model's text exactly as it streams in
+)
+
+// renderMode is set by --render. When empty, outputRenderMode picks one.
+var renderMode string
+
+// loadRenderFlags removes --render from os.Args
+func loadRenderFlags() {
+	var rest []string
+	args := os.Args
+	for i := 0; i < len(args); i++ {
+		value, ok := strings.CutPrefix(args[i], "--render=")
+		if !ok && args[i] == "--render" {
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "❌ --render needs plain, markdown or raw")
+				os.Exit(1)
+			}
+			value, ok = args[i+1], true
+			i++
+		}
+		if !ok {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		switch value {
+		case RenderMarkdown, RenderPlain, RenderRaw:
+			renderMode = value
+		default:
+			fmt.Fprintf(os.Stderr, "❌ Unknown render mode %q, use plain, markdown or raw\n", value)
+			os.Exit(1)
+		}
+	}
+	os.Args = rest
+}
+
+// outputRenderMode is the mode for answers written to resultOut. An answer
+// saved with -o is kept as the model wrote it; NO_COLOR and pipes get plain
+// text.
+func outputRenderMode() string {
+	switch {
+	case renderMode != "":
+		return renderMode
+	case outputPath != "":
+		return RenderRaw
+	case noColor() || !isTerminal(resultOut):
+		return RenderPlain
+	}
+	return RenderMarkdown
+}
+
+// renderMarkdown writes a complete answer
+func renderMarkdown(out io.Writer, text string) {
+	m := newMarkdownWriter(out, outputRenderMode())
+	m.Write(text)
+	m.Close()
+}
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockList
+	blockQuote
+	blockRule
+)
+
+// blockStart describes how a line begins: its kind, the rendered marker and
+// where the inline text starts
+type blockStart struct {
+	kind    blockKind
+	level   int // heading level
+	indent  string
+	marker  string
+	content int
+}
+
+// markdownWriter renders streamed markdown. Paragraphs, headings, list items
+// and quotes are written as the text arrives; fenced code and table rows
+// wait for the end of their line so they can be highlighted and aligned.
+type markdownWriter struct {
+	out    *bufio.Writer
+	mode   string
+	styled bool
+	width  int // of the terminal written to, for rules
+
+	line    []rune // the line being received
+	started bool   // the line's block is known and its text is being written
+	written int    // runes of line already written
+	inline  inlineRenderer
+
+	code        *highlighter // open fenced code block
+	fence       string
+	table       [][]string
+	atLineStart bool
+}
+
+func newMarkdownWriter(out io.Writer, mode string) *markdownWriter {
+	return &markdownWriter{
+		out:         bufio.NewWriter(out),
+		width:       writerWidth(out),
+		mode:        mode,
+		styled:      mode == RenderMarkdown,
+		atLineStart: true,
+	}
+}
+
+// writerWidth is the terminal width of out, or 80 when it is not a terminal
+func writerWidth(out io.Writer) int {
+	if f, ok := out.(*os.File); ok && isTerminal(f) {
+		return terminalWidth(f)
+	}
+	return 80
+}
+
+// Write takes the next chunk of the stream
+func (m *markdownWriter) Write(chunk string) {
+	if m.mode == RenderRaw {
+		m.out.WriteString(chunk)
+		if chunk != "" {
+			m.atLineStart = strings.HasSuffix(chunk, "\n")
+		}
+		m.out.Flush()
+		return
+	}
+
+	for _, r := range chunk {
+		switch r {
+		case '\r':
+		case '\n':
+			m.endLine()
+		default:
+			m.line = append(m.line, r)
+		}
+	}
+	m.writePartial()
+	m.out.Flush()
+}
+
+// Close writes whatever is still buffered and ends the last line
+func (m *markdownWriter) Close() {
+	if m.mode != RenderRaw && (len(m.line) > 0 || m.started) {
+		m.endLine()
+	}
+	if m.table != nil {
+		m.flushTable()
+	}
+	if !m.atLineStart {
+		m.out.WriteString("\n")
+		m.atLineStart = true
+	}
+	m.out.Flush()
+}
+
+func (m *markdownWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	m.out.WriteString(s)
+	m.atLineStart = strings.HasSuffix(s, "\n")
+}
+
+// ansi returns the escape sequence for an SGR code, or nothing in plain mode
+func (m *markdownWriter) ansi(code string) string {
+	if !m.styled {
+		return ""
+	}
+	return "\x1b[" + code + "m"
+}
+
+// writePartial writes the received part of a line once its block is known,
+// holding back trailing markers such as "*" until the next character shows
+// whether they open or close emphasis
+func (m *markdownWriter) writePartial() {
+	if m.code != nil || len(m.line) == 0 {
+		return
+	}
+	if !m.started {
+		start, ok := parseBlock(m.line, false)
+		if !ok || start.kind == blockRule {
+			return
+		}
+		m.startBlock(start)
+	}
+
+	end := len(m.line)
+	for end > m.written && strings.ContainsRune("*_~`\\", m.line[end-1]) {
+		end--
+	}
+	if end > m.written {
+		m.write(m.inline.render(m.line[m.written:end]))
+		m.written = end
+	}
+}
+
+func (m *markdownWriter) endLine() {
+	line := m.line
+	m.line = nil
+
+	if m.started {
+		m.write(m.inline.render(line[m.written:]) + m.inline.end() + "\n")
+		m.started = false
+		return
+	}
+	m.renderLine(string(line))
+}
+
+// renderLine renders a complete line that was not written while streaming
+func (m *markdownWriter) renderLine(line string) {
+	trimmed := strings.TrimSpace(line)
+
+	if m.code != nil {
+		if strings.HasPrefix(trimmed, m.fence) && strings.Trim(trimmed, m.fence[:1]) == "" {
+			m.write(m.ansi("2") + line + m.ansi("0") + "\n")
+			m.code = nil
+			return
+		}
+		if m.styled {
+			line = m.code.line(line)
+		}
+		m.write(line + "\n")
+		return
+	}
+
+	if strings.HasPrefix(trimmed, "|") && strings.Count(trimmed, "|") >= 2 {
+		m.table = append(m.table, splitTableRow(trimmed))
+		return
+	}
+	if m.table != nil {
+		m.flushTable()
+	}
+
+	if fence, lang, ok := parseFence(trimmed); ok {
+		m.code = newHighlighter(lang)
+		m.fence = fence
+		m.write(m.ansi("2") + line + m.ansi("0") + "\n")
+		return
+	}
+
+	start, _ := parseBlock([]rune(line), true)
+	if start.kind == blockRule {
+		width := min(m.width, 60)
+		if m.styled {
+			m.write(m.ansi("2") + strings.Repeat("─", width) + m.ansi("0") + "\n")
+		} else {
+			m.write(strings.Repeat("-", width) + "\n")
+		}
+		return
+	}
+	m.startBlock(start)
+	m.write(m.inline.render([]rune(line)[m.written:]) + m.inline.end() + "\n")
+	m.started = false
+}
+
+// startBlock writes the line's marker and sets the style of its text
+func (m *markdownWriter) startBlock(start blockStart) {
+	m.started = true
+	m.written = start.content
+	m.inline = inlineRenderer{styled: m.styled}
+
+	switch start.kind {
+	case blockHeading:
+		switch start.level {
+		case 1:
+			m.inline.base = "1;4;36"
+		case 2:
+			m.inline.base = "1;36"
+		default:
+			m.inline.base = "1"
+		}
+		m.write(start.indent)
+	case blockList:
+		marker := start.marker
+		if m.styled && (marker == "-" || marker == "*" || marker == "+") {
+			marker = "•"
+		}
+		m.write(start.indent + m.ansi("36") + marker + m.ansi("0") + " ")
+	case blockQuote:
+		if m.styled {
+			m.write(start.indent + m.ansi("2") + "│" + m.ansi("0") + " ")
+			m.inline.base = "3"
+		} else {
+			m.write(start.indent + "> ")
+		}
+	default:
+		m.write(start.indent)
+	}
+	m.write(m.inline.begin())
+}
+
+// parseBlock finds the kind of a line. For a line still being received it
+// reports false while the beginning could still turn out differently, e.g.
+// "-" may become a list item or a rule.
+func parseBlock(line []rune, complete bool) (blockStart, bool) {
+	i := 0
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	start := blockStart{indent: string(line[:i]), content: i}
+	rest := line[i:]
+	if len(rest) == 0 {
+		return start, complete
+	}
+
+	switch c := rest[0]; {
+	case c == '#':
+		n := 0
+		for n < len(rest) && rest[n] == '#' {
+			n++
+		}
+		if n < len(rest) && rest[n] == ' ' && n <= 6 {
+			start.kind, start.level, start.content = blockHeading, n, i+n+1
+			return start, true
+		}
+		if n == len(rest) && !complete {
+			return start, false
+		}
+	case c == '>':
+		if len(rest) == 1 && !complete {
+			return start, false
+		}
+		start.kind, start.content = blockQuote, i+1
+		if len(rest) > 1 && rest[1] == ' ' {
+			start.content++
+		}
+		return start, true
+	case c == '-' || c == '*' || c == '+' || c == '_':
+		if isRule(rest) {
+			if !complete {
+				return start, false
+			}
+			start.kind = blockRule
+			return start, true
+		}
+		if c != '_' && len(rest) > 1 && rest[1] == ' ' {
+			start.kind, start.marker, start.content = blockList, string(c), i+2
+			return start, true
+		}
+		if len(rest) == 1 && !complete {
+			return start, false
+		}
+	case unicode.IsDigit(c):
+		n := 0
+		for n < len(rest) && unicode.IsDigit(rest[n]) {
+			n++
+		}
+		if n+1 < len(rest) && (rest[n] == '.' || rest[n] == ')') && rest[n+1] == ' ' {
+			start.kind, start.marker, start.content = blockList, string(rest[:n+1]), i+n+2
+			return start, true
+		}
+		if n+1 >= len(rest) && !complete {
+			return start, false
+		}
+	case c == '`' || c == '~' || c == '|' || c == '=':
+		// A fence or table row is only known from the whole line
+		if !complete {
+			return start, false
+		}
+	}
+	return start, true
+}
+
+// isRule reports whether the text so far only has rule characters: three or
+// more of the same "-", "*" or "_", optionally separated by spaces
+func isRule(rest []rune) bool {
+	count := 0
+	for _, r := range rest {
+		switch {
+		case r == rest[0]:
+			count++
+		case r != ' ':
+			return false
+		}
+	}
+	return count >= 3 || count == len(rest)
+}
+
+// parseFence recognises ``` or ~~~ with an optional language
+func parseFence(trimmed string) (fence, lang string, ok bool) {
+	for _, c := range []string{"`", "~"} {
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, c))
+		if n >= 3 {
+			info := strings.Fields(trimmed[n:])
+			if c == "`" && strings.Contains(trimmed[n:], "`") {
+				return "", "", false
+			}
+			if len(info) > 0 {
+				lang = info[0]
+			}
+			return trimmed[:n], lang, true
+		}
+	}
+	return "", "", false
+}
+
+func splitTableRow(trimmed string) []string {
+	trimmed = strings.TrimPrefix(trimmed, "|")
+	trimmed = strings.TrimSuffix(trimmed, "|")
+	cells := strings.Split(trimmed, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+func isTableSeparator(row []string) bool {
+	for _, cell := range row {
+		if strings.Trim(cell, ":-") != "" || !strings.Contains(cell, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// flushTable writes the buffered rows with aligned columns. The row above
+// the separator is the header; a separator cell like "--:" right-aligns.
+func (m *markdownWriter) flushTable() {
+	rows := m.table
+	m.table = nil
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	widths := make([]int, columns)
+	plain := inlineRenderer{}
+	for _, row := range rows {
+		if isTableSeparator(row) {
+			continue
+		}
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(plain.render([]rune(cell))))
+		}
+	}
+
+	sep, cross, dash := " │ ", "─┼─", "─"
+	if !m.styled {
+		sep, cross, dash = " | ", "-+-", "-"
+	}
+	header := len(rows) > 1 && isTableSeparator(rows[1])
+	right := make([]bool, columns)
+	if header {
+		for i, cell := range rows[1] {
+			right[i] = strings.HasSuffix(cell, ":") && !strings.HasPrefix(cell, ":")
+		}
+	}
+
+	for r, row := range rows {
+		if isTableSeparator(row) {
+			parts := make([]string, columns)
+			for i, w := range widths {
+				parts[i] = strings.Repeat(dash, w)
+			}
+			m.write(m.ansi("2") + strings.Join(parts, cross) + m.ansi("0") + "\n")
+			continue
+		}
+		parts := make([]string, columns)
+		for i := range parts {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			render := inlineRenderer{styled: m.styled}
+			if header && r == 0 {
+				render.base = "1"
+			}
+			text := render.begin() + render.render([]rune(cell)) + render.end()
+			padding := strings.Repeat(" ", widths[i]-displayWidth(plain.render([]rune(cell))))
+			if right[i] {
+				parts[i] = padding + text
+			} else {
+				parts[i] = text + padding
+			}
+		}
+		m.write(strings.TrimRight(strings.Join(parts, m.ansi("2")+sep+m.ansi("0")), " ") + "\n")
+	}
+}
+
+// displayWidth counts terminal columns; wide CJK characters and emoji take two
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana),
+			r >= 0xFF00 && r <= 0xFF60, r >= 0x1F300 && r <= 0x1FAFF:
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// inlineRenderer styles **bold**, *italic*, ~~strike~~ and `code` spans.
+// Its state carries over between calls so a span may arrive in pieces;
+// the caller holds back trailing markers until the next chunk.
+type inlineRenderer struct {
+	styled bool
+	base   string // style of the whole block, e.g. bold for headings
+	bold   bool
+	italic bool
+	strike bool
+	code   int // length of the backtick run that opened the code span
+	prev   rune
+}
+
+// begin returns the escape sequence for the block's base style
+func (s *inlineRenderer) begin() string {
+	if !s.styled || s.base == "" {
+		return ""
+	}
+	return "\x1b[" + s.base + "m"
+}
+
+// end closes any open style at the end of a line
+func (s *inlineRenderer) end() string {
+	open := s.styled && (s.base != "" || s.bold || s.italic || s.strike || s.code > 0)
+	*s = inlineRenderer{styled: s.styled, base: s.base}
+	if open {
+		return "\x1b[0m"
+	}
+	return ""
+}
+
+func (s *inlineRenderer) style() string {
+	if !s.styled {
+		return ""
+	}
+	codes := []string{"0"}
+	if s.base != "" {
+		codes = append(codes, s.base)
+	}
+	if s.bold {
+		codes = append(codes, "1")
+	}
+	if s.italic {
+		codes = append(codes, "3")
+	}
+	if s.strike {
+		codes = append(codes, "9")
+	}
+	if s.code > 0 {
+		codes = append(codes, "36")
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+func (s *inlineRenderer) render(text []rune) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		r := text[i]
+		run := 1
+		for i+run < len(text) && text[i+run] == r {
+			run++
+		}
+		next := rune(0)
+		if i+run < len(text) {
+			next = text[i+run]
+		}
+
+		if s.code > 0 {
+			if r == '`' && run == s.code {
+				s.code = 0
+				sb.WriteString(s.style())
+				i += run - 1
+			} else {
+				sb.WriteRune(r)
+			}
+			s.prev = r
+			continue
+		}
+
+		switch r {
+		case '\\':
+			if next != 0 && unicode.IsPunct(next) {
+				sb.WriteRune(next)
+				s.prev = next
+				i++
+				continue
+			}
+		case '`':
+			s.code = run
+			sb.WriteString(s.style())
+			i += run - 1
+			s.prev = r
+			continue
+		case '*', '_', '~':
+			if r == '_' && isWordRune(s.prev) && isWordRune(next) {
+				break // snake_case
+			}
+			opens := next != 0 && !unicode.IsSpace(next)
+			closes := s.prev != 0 && !unicode.IsSpace(s.prev)
+			used := 0
+			if r == '~' {
+				if run == 2 && ((!s.strike && opens) || (s.strike && closes)) {
+					s.strike = !s.strike
+					used = 2
+				}
+			} else {
+				if run >= 2 && ((!s.bold && opens) || (s.bold && closes)) {
+					s.bold = !s.bold
+					used += 2
+				}
+				if run-used >= 1 && ((!s.italic && opens) || (s.italic && closes)) {
+					s.italic = !s.italic
+					used++
+				}
+			}
+			if used > 0 {
+				sb.WriteString(s.style())
+				sb.WriteString(strings.Repeat(string(r), run-used))
+				i += run - 1
+				s.prev = r
+				continue
+			}
+		}
+		sb.WriteRune(r)
+		s.prev = r
+	}
+	return sb.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// replCommand is a slash command of the interactive chat
+type replCommand struct {
+	Name  string
+	Alias []string
+	Args  string
+	Help  string
+	Run   func(r *repl, args string) bool // false ends the REPL
+}
+
+var replCommands []replCommand
+
+func init() {
+	replCommands = []replCommand{
+		{Name: "/provider", Args: "[name]", Help: "Show or switch the provider", Run: (*repl).cmdProvider},
+		{Name: "/model", Args: "[model]", Help: "Show or set the model", Run: (*repl).cmdModel},
+		{Name: "/system", Args: "[prompt|off]", Help: "Show, set or clear the system prompt", Run: (*repl).cmdSystem},
+		{Name: "/retry", Help: "Answer the last message again", Run: (*repl).cmdRetry},
+		{Name: "/undo", Help: "Remove the last exchange", Run: (*repl).cmdUndo},
+		{Name: "/edit", Args: "[message]", Help: "Rewrite the last message ($EDITOR without text) and answer again", Run: (*repl).cmdEdit},
+		{Name: "/save", Args: "[title]", Help: "Show the session ID, optionally renaming the session", Run: (*repl).cmdSave},
+		{Name: "/export", Args: "[file] [--format txt|md]", Help: "Export the conversation", Run: (*repl).cmdExport},
+		{Name: "/sessions", Help: "List recent sessions", Run: (*repl).cmdSessions},
+		{Name: "/switch", Args: "<id|number>", Help: "Continue another session", Run: (*repl).cmdSwitch},
+		{Name: "/clear", Help: "Start a new conversation with the same settings", Run: (*repl).cmdClear},
+		{Name: "/tokens", Help: "Show the context the next request sends and its token estimate", Run: (*repl).cmdTokens},
+		{Name: "/help", Help: "Show this help", Run: (*repl).cmdHelp},
+		{Name: "/exit", Alias: []string{"/quit"}, Help: "Leave the chat (or Ctrl+D)", Run: func(*repl, string) bool { return false }},
+	}
+}
+
+func findREPLCommand(name string) *replCommand {
+	for i, c := range replCommands {
+		if c.Name == name {
+			return &replCommands[i]
+		}
+		for _, alias := range c.Alias {
+			if alias == name {
+				return &replCommands[i]
+			}
+		}
+	}
+	return nil
+}
+
+// repl is the interactive chat. Until the first message is sent there is no
+// session yet and settings changes go to draft, which the new session
+// starts from.
+type repl struct {
+	sessionID string
+	draft     ChatSession
+	input     *lineReader
+	// result is the outcome of the last answer
+	result ChatResult
+}
+
+func newREPL(providerName string) *repl {
+	var names []string
+	for _, c := range replCommands {
+		names = append(names, c.Name)
+	}
+	r := &repl{input: newLineReader(names)}
+	r.draft = ChatSession{
+		Provider: providerName,
+		User:     "user",
+		System:   systemPrompt(),
+		Model:    personaModel(providerName),
+		Params:   cliParams.record(),
+	}
+	if activePersona != nil {
+		r.draft.Persona = activePersona.Name
+	}
+	return r
+}
+
+// current returns the live session, or the draft before the first message
+func (r *repl) current() *ChatSession {
+	if r.sessionID != "" {
+		if session, err := getSession(r.sessionID); err == nil {
+			return session
+		}
+	}
+	return &r.draft
+}
+
+func (r *repl) saveSettings() {
+	if r.sessionID != "" {
+		if err := saveSessionSettings(r.current()); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to save session: %v\n", err)
+		}
+	}
+}
+
+func startREPLWithSession(session *ChatSession, initialMessage string) {
+	r := newREPL(personaProvider(providerConfig.DefaultProvider))
+	initialMessage, initialAttachments, ok := withImageRefs(initialMessage, cliImages)
+	if !ok {
+		return
+	}
+
+	if session == nil {
+		fmt.Fprintf(statusOut, providerConfig.Prompts.PrimaryProvider, r.draft.Provider)
+		fmt.Fprintf(statusOut, providerConfig.Prompts.FallbackPrompt, providerConfig.FallbackEnabled)
+	} else {
+		r.load(session)
+		// --system/--persona on an existing session replace its prompt
+		if system := systemPrompt(); system != "" {
+			session.System = system
+			if activePersona != nil {
+				session.Persona = activePersona.Name
+			}
+			r.saveSettings()
+		}
+	}
+	if initialMessage != "" && scriptMode() {
+		r.send(initialMessage, initialAttachments)
+		r.finish()
+		return
+	}
+	fmt.Println("💬 Type /help for commands, end a line with \\ or wrap text in \"\"\" for multi-line input")
+
+	if initialMessage != "" {
+		r.send(initialMessage, initialAttachments)
+	}
+	r.loop()
+}
+
+func (r *repl) load(session *ChatSession) {
+	r.sessionID = session.ID
+	fmt.Fprintf(statusOut, providerConfig.Prompts.LoadedSession, session.Title)
+	fmt.Fprintf(statusOut, providerConfig.Prompts.LoadedMessages, len(session.Messages))
+	fmt.Fprintf(statusOut, providerConfig.Prompts.LoadedProvider, session.Provider)
+	fmt.Fprintln(statusOut)
+}
+
+// loop reads messages and commands until /exit or end of input
+func (r *repl) loop() {
+	for {
+		input, err := r.readInput()
+		if err == errLineInterrupted {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		r.input.addHistory(input)
+
+		if name, args, isCommand := parseREPLCommand(input); isCommand {
+			cmd := findREPLCommand(name)
+			if cmd == nil {
+				fmt.Printf("❓ Unknown command %s, type /help\n", name)
+				continue
+			}
+			if !cmd.Run(r, args) {
+				break
+			}
+			continue
+		}
+
+		message, attachments, ok := withImageRefs(input, nil)
+		if ok {
+			r.send(message, attachments)
+		}
+	}
+	r.printSaved()
+}
+
+// readInput reads one message. A line ending in \ continues on the next
+// line, and a line with only """ starts a block that ends with """.
+func (r *repl) readInput() (string, error) {
+	line, err := r.input.readLine(providerConfig.Prompts.InputMessage)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(line) == `"""` {
+		var lines []string
+		for {
+			next, err := r.input.readLine("... ")
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(next) == `"""` {
+				return strings.Join(lines, "\n"), nil
+			}
+			lines = append(lines, next)
+		}
+	}
+
+	for strings.HasSuffix(line, "\\") {
+		next, err := r.input.readLine("... ")
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimSuffix(line, "\\") + "\n" + next
+	}
+	return line, nil
+}
+
+// parseREPLCommand splits "/name args". Input such as "/etc/hosts is
+// empty?" is a message, not a command.
+func parseREPLCommand(input string) (string, string, bool) {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, "/") {
+		return "", "", false
+	}
+	name, args, _ := strings.Cut(input, " ")
+	if strings.Contains(name[1:], "/") {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(args), true
+}
+
+// send stores the user message, starting the session on the first one, and
+// answers it
+func (r *repl) send(message string, attachments []Attachment) {
+	if r.sessionID == "" {
+		session := createSession(truncateTitle(message), r.draft.Provider, r.draft.User)
+		session.System = r.draft.System
+		session.Persona = r.draft.Persona
+		session.Model = r.draft.Model
+		session.Params = r.draft.Params
+		saveSessionSettings(session)
+		r.sessionID = session.ID
+	}
+
+	if err := appendSessionMessage(r.sessionID, ChatMessage{Role: "user", Content: message, Attachments: attachments}); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to save message: %v\n", err)
+		r.result = ChatResult{SessionID: r.sessionID, Error: err.Error()}
+		return
+	}
+	r.answer()
+}
+
+// answer replies to the last user message; with --json the result is
+// printed
+func (r *repl) answer() {
+	r.result = sessionWithHistory(r.current())
+	if jsonOutput {
+		writeJSON(r.result)
+	}
+}
+
+// finish ends a one-shot chat and exits non-zero when no answer came back
+func (r *repl) finish() {
+	r.printSaved()
+	if r.result.Error != "" {
+		os.Exit(1)
+	}
+	if outputPath != "" {
+		fmt.Fprintf(statusOut, "📄 Answer written to %s\n", outputPath)
+	}
+}
+
+func (r *repl) printSaved() {
+	if r.sessionID == "" {
+		return
+	}
+	fmt.Fprintf(statusOut, providerConfig.Prompts.ChatSaved, r.sessionID)
+	fmt.Fprintf(statusOut, "   Resume with: terminal-ai chat --session %s\n", r.sessionID)
+}
+
+// requireSession reports when a command needs a conversation that has not
+// started yet
+func (r *repl) requireSession() *ChatSession {
+	if r.sessionID == "" {
+		fmt.Println("⚠️  No messages yet")
+		return nil
+	}
+	return r.current()
+}
+
+// lastUserMessage returns the index of the newest user message, or -1
+func lastUserMessage(messages []ChatMessage) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *repl) cmdProvider(args string) bool {
+	session := r.current()
+	if args == "" {
+		model := session.Model
+		if model == "" {
+			model = providers[session.Provider].Model
+		}
+		fmt.Printf("🎯 Provider: %s (model: %s)\n", session.Provider, model)
+		var available []string
+		for _, name := range getOrderedProviders() {
+			if providerConfig.Providers[name].Enabled && providerReady(providers[name]) {
+				available = append(available, name)
+			}
+		}
+		fmt.Printf("   Available: %s\n", strings.Join(available, ", "))
+		return true
+	}
+
+	provider, exists := providers[args]
+	if !exists {
+		fmt.Printf("❌ Unknown provider: %s\n", args)
+		return true
+	}
+	if !providerReady(provider) {
+		fmt.Printf("❌ API key not configured for %s\n", args)
+		return true
+	}
+	session.Provider = args
+	session.Model = personaModel(args)
+	r.saveSettings()
+	fmt.Printf("🎯 Provider: %s\n", args)
+	return true
+}
+
+func (r *repl) cmdModel(args string) bool {
+	session := r.current()
+	if args == "" {
+		model := session.Model
+		if model == "" {
+			model = providers[session.Provider].Model + " (provider default)"
+		}
+		fmt.Printf("🧠 Model: %s\n", model)
+		return true
+	}
+	if !confirmModel(session.Provider, args) {
+		return true
+	}
+	session.Model = args
+	r.saveSettings()
+	fmt.Printf("🧠 Model: %s\n", args)
+	return true
+}
+
+func (r *repl) cmdSystem(args string) bool {
+	session := r.current()
+	switch args {
+	case "":
+		if session.System == "" {
+			fmt.Println("📜 No system prompt")
+		} else {
+			fmt.Printf("📜 System: %s\n", session.System)
+		}
+		return true
+	case "off":
+		session.System = ""
+		session.Persona = ""
+		fmt.Println("📜 System prompt cleared")
+	default:
+		session.System = args
+		session.Persona = ""
+		fmt.Println("📜 System prompt set")
+	}
+	r.saveSettings()
+	return true
+}
+
+func (r *repl) cmdRetry(string) bool {
+	session := r.requireSession()
+	if session == nil {
+		return true
+	}
+	i := lastUserMessage(session.Messages)
+	if i < 0 {
+		fmt.Println("⚠️  Nothing to retry")
+		return true
+	}
+	if err := setSessionMessages(session.ID, session.Messages[:i+1]); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return true
+	}
+	fmt.Println("🔁 Retrying")
+	r.answer()
+	return true
+}
+
+func (r *repl) cmdUndo(string) bool {
+	session := r.requireSession()
+	if session == nil {
+		return true
+	}
+	i := lastUserMessage(session.Messages)
+	if i < 0 {
+		fmt.Println("⚠️  Nothing to undo")
+		return true
+	}
+	removed := session.Messages[i].Content
+	if err := setSessionMessages(session.ID, session.Messages[:i]); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return true
+	}
+	fmt.Printf("↩️  Removed: %s\n", truncate(removed, 60))
+	return true
+}
+
+func (r *repl) cmdEdit(args string) bool {
+	session := r.requireSession()
+	if session == nil {
+		return true
+	}
+	i := lastUserMessage(session.Messages)
+	if i < 0 {
+		fmt.Println("⚠️  Nothing to edit")
+		return true
+	}
+
+	edited := args
+	if edited == "" {
+		var err error
+		if edited, err = editInEditor(session.Messages[i].Content); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return true
+		}
+	}
+	if strings.TrimSpace(edited) == "" {
+		fmt.Println(providerConfig.Prompts.MessageEmpty)
+		return true
+	}
+
+	message := session.Messages[i]
+	message.Content = edited
+	messages := append(append([]ChatMessage{}, session.Messages[:i]...), message)
+	if err := setSessionMessages(session.ID, messages); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return true
+	}
+	fmt.Printf("✏️  Edited: %s\n", truncate(edited, 60))
+	r.answer()
+	return true
+}
+
+// editInEditor opens $VISUAL or $EDITOR (vi by default) on text and returns
+// the saved result
+func editInEditor(text string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	file, err := os.CreateTemp("", "terminal-ai-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(text)
+	file.Close()
+
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor failed: %w", err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+func (r *repl) cmdSave(args string) bool {
+	session := r.requireSession()
+	if session == nil {
+		return true
+	}
+	if args != "" {
+		session.Title = truncateTitle(args)
+		if err := saveChatHistory(); err != nil {
+			fmt.Printf("❌ Failed to save session: %v\n", err)
+			return true
+		}
+	}
+	fmt.Printf("💾 %s\n", session.Title)
+	fmt.Printf("   ID: %s\n", session.ID)
+	fmt.Printf("   Resume with: terminal-ai chat --session %s\n", session.ID)
+	return true
+}
+
+func (r *repl) cmdExport(args string) bool {
+	session := r.requireSession()
+	if session == nil {
+		return true
+	}
+	filename := ""
+	format := ""
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		if fields[i] == "--format" && i+1 < len(fields) {
+			format = fields[i+1]
+			i++
+		} else if filename == "" {
+			filename = fields[i]
+		}
+	}
+	if format == "" {
+		format = "md"
+		if strings.HasSuffix(filename, ".txt") {
+			format = "txt"
+		}
+	}
+	exportSession(session.ID, filename, format)
+	return true
+}
+
+func (r *repl) cmdSessions(string) bool {
+	sessions := listSessions()
+	if len(sessions) == 0 {
+		fmt.Println("📚 No chat sessions found")
+		return true
+	}
+	fmt.Println("📚 Recent sessions:")
+	for i, session := range sessions {
+		if i == 10 {
+			fmt.Printf("   ... %d more, see: terminal-ai chat --list\n", len(sessions)-10)
+			break
+		}
+		marker := "  "
+		if session.ID == r.sessionID {
+			marker = "▶ "
+		}
+		fmt.Printf("%s%2d. %s  %s (%d messages)\n", marker, i+1, session.ID, truncate(session.Title, 40), len(session.Messages))
+	}
+	return true
+}
+
+func (r *repl) cmdSwitch(args string) bool {
+	if args == "" {
+		fmt.Println("Usage: /switch <id|number>")
+		return true
+	}
+	session, err := getSession(args)
+	if n, convErr := strconv.Atoi(args); err != nil && convErr == nil {
+		// Numbers refer to the /sessions listing
+		if sessions := listSessions(); n >= 1 && n <= len(sessions) {
+			session, err = &sessions[n-1], nil
+		}
+	}
+	if err != nil {
+		fmt.Printf("❌ Session not found: %s\n", args)
+		return true
+	}
+	r.load(session)
+	return true
+}
+
+func (r *repl) cmdClear(string) bool {
+	previous := r.sessionID
+	r.draft = *r.current()
+	r.draft.ID = ""
+	r.draft.Title = ""
+	r.draft.Messages = nil
+	r.draft.Summary = ""
+	r.draft.SummarizedCount = 0
+	r.sessionID = ""
+	if previous != "" {
+		fmt.Printf("🧹 New conversation, previous one saved as %s\n", previous)
+	} else {
+		fmt.Println("🧹 New conversation")
+	}
+	return true
+}
+
+// cmdTokens shows what the next request would send from the session
+func (r *repl) cmdTokens(string) bool {
+	session := r.current()
+	model := session.Model
+	if model == "" {
+		model = providers[session.Provider].Model
+	}
+	printContextPlan(planContext(session, session.Provider, model, resolveParams(nil, session, cliParams), AssembledContext{}))
+	return true
+}
+
+func (r *repl) cmdHelp(string) bool {
+	fmt.Println("Commands:")
+	for _, c := range replCommands {
+		fmt.Printf("  %-34s %s\n", strings.TrimSpace(c.Name+" "+c.Args), c.Help)
+	}
+	fmt.Println()
+	fmt.Println("Input: end a line with \\ to continue it, or put \"\"\" on its own line to start and end a block.")
+	fmt.Println("Keys: ↑/↓ history, Tab completes commands, Ctrl+C discards the line, Ctrl+D quits.")
+	fmt.Println("Images: mention @path/to/image.png in a message to attach it.")
+	return true
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error classifications used by ProviderError.Type
+const (
+	ErrTypeBadRequest  = "bad_request"
+	ErrTypeAuth        = "auth"
+	ErrTypeNotFound    = "not_found"
+	ErrTypeRateLimit   = "rate_limit"
+	ErrTypeServerError = "server_error"
+	ErrTypeTimeout     = "timeout"
+	ErrTypeNetwork     = "network"
+	ErrTypeCanceled    = "canceled"
+	ErrTypeUnknown     = "unknown"
+	// Not sent: another request is probing the provider's open breaker
+	ErrTypeCircuitOpen = "circuit_open"
+)
+
+// ProviderError is a classified failure from a provider, built from the HTTP
+// status, headers and body or from the transport error.
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Type       string
+	Message    string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	msg := e.Message
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: HTTP %d (%s): %s", e.Provider, e.StatusCode, e.Type, msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Provider, e.Type, msg)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the same request may succeed if sent again.
+// Client errors (400, 401, 403, 404) and cancellations never are.
+func (e *ProviderError) Retryable() bool {
+	switch e.Type {
+	case ErrTypeBadRequest, ErrTypeAuth, ErrTypeNotFound, ErrTypeCanceled, ErrTypeCircuitOpen:
+		return false
+	}
+	return true
+}
+
+func classifyStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrTypeAuth
+	case status == http.StatusNotFound:
+		return ErrTypeNotFound
+	case status == http.StatusTooManyRequests:
+		return ErrTypeRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrTypeTimeout
+	case status >= 500:
+		return ErrTypeServerError
+	case status >= 400:
+		return ErrTypeBadRequest
+	}
+	return ErrTypeUnknown
+}
+
+// errProbeInFlight is returned for a request that was not sent because
+// another request holds the half-open probe, see claimProbe
+func errProbeInFlight(provider string) *ProviderError {
+	return &ProviderError{Provider: provider, Type: ErrTypeCircuitOpen, Message: "circuit open, another request is probing"}
+}
+
+// newHTTPError builds a ProviderError from a non-2xx response and its body.
+// Only 429 and 503 carry a wait: the rate limit reset headers come with
+// every response, so they are read for a 429 alone.
+func newHTTPError(provider string, resp *http.Response, body []byte) *ProviderError {
+	perr := &ProviderError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Type:       classifyStatus(resp.StatusCode),
+		Message:    extractErrorMessage(body),
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		perr.RetryAfter = parseRetryAfter(resp.Header, time.Now(), true)
+	case http.StatusServiceUnavailable:
+		perr.RetryAfter = parseRetryAfter(resp.Header, time.Now(), false)
+	}
+	return perr
+}
+
+// toProviderError classifies the outcome of a request. It returns nil when
+// the request succeeded.
+func toProviderError(provider string, err error, response *Response) *ProviderError {
+	if err != nil {
+		var perr *ProviderError
+		if errors.As(err, &perr) {
+			return perr
+		}
+
+		result := &ProviderError{Provider: provider, Type: ErrTypeUnknown, Err: err}
+		var netErr net.Error
+		switch {
+		case errors.Is(err, context.Canceled):
+			result.Type = ErrTypeCanceled
+		case errors.Is(err, context.DeadlineExceeded):
+			result.Type = ErrTypeTimeout
+		case errors.As(err, &netErr) && netErr.Timeout():
+			result.Type = ErrTypeTimeout
+		case netErr != nil:
+			result.Type = ErrTypeNetwork
+		}
+		return result
+	}
+
+	if response != nil && response.Error != nil && response.Error.Message != "" {
+		// Some providers report errors in a 200 body
+		result := &ProviderError{Provider: provider, Type: ErrTypeServerError, Message: response.Error.Message}
+		kind := strings.ToLower(response.Error.Type)
+		switch {
+		case strings.Contains(kind, "rate_limit") || strings.Contains(kind, "resource_exhausted"):
+			result.Type = ErrTypeRateLimit
+		case strings.Contains(kind, "invalid_request") || strings.Contains(kind, "invalid_argument"):
+			result.Type = ErrTypeBadRequest
+		case strings.Contains(kind, "authentication") || strings.Contains(kind, "permission"):
+			result.Type = ErrTypeAuth
+		}
+		return result
+	}
+
+	return nil
+}
+
+// parseRetryAfter reads Retry-After (seconds or HTTP date) and, with resets,
+// the x-ratelimit-reset family, which providers send as a duration ("6m0s"),
+// a delay in seconds, a unix timestamp in seconds or milliseconds, or an
+// RFC 3339 time.
+func parseRetryAfter(h http.Header, now time.Time, resets bool) time.Duration {
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(secs * float64(time.Second))
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+	if !resets {
+		return 0
+	}
+
+	for _, key := range []string{
+		"x-ratelimit-reset",
+		"x-ratelimit-reset-requests",
+		"x-ratelimit-reset-tokens",
+		"anthropic-ratelimit-requests-reset",
+		"anthropic-ratelimit-tokens-reset",
+	} {
+		v := strings.TrimSpace(h.Get(key))
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			if t.After(now) {
+				return t.Sub(now)
+			}
+			continue
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			switch {
+			case n > 1e12:
+				return time.UnixMilli(int64(n)).Sub(now)
+			case n > 1e9:
+				return time.Unix(int64(n), 0).Sub(now)
+			default:
+				return time.Duration(n * float64(time.Second))
+			}
+		}
+	}
+
+	return 0
+}
+
+// RetryPolicy controls how a provider is retried before moving to the next
+// one. Zero values fall back to the defaults in retryPolicyFor.
+type RetryPolicy struct {
+	InitialDelayMs  int     `json:"initial_delay_ms,omitempty"`
+	MaxDelayMs      int     `json:"max_delay_ms,omitempty"`
+	Multiplier      float64 `json:"multiplier,omitempty"`
+	Jitter          float64 `json:"jitter,omitempty"`
+	MaxRetryAfterMs int     `json:"max_retry_after_ms,omitempty"`
+}
+
+func retryPolicyFor(providerName string) RetryPolicy {
+	policy := RetryPolicy{
+		InitialDelayMs:  providerConfig.RetryDelayMs,
+		MaxDelayMs:      30000,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxRetryAfterMs: 60000,
+	}
+	if policy.InitialDelayMs <= 0 {
+		policy.InitialDelayMs = 1000
+	}
+
+	config := providerConfig.Providers[providerName]
+	if custom := config.Retry; custom != nil {
+		if custom.InitialDelayMs > 0 {
+			policy.InitialDelayMs = custom.InitialDelayMs
+		}
+		if custom.MaxDelayMs > 0 {
+			policy.MaxDelayMs = custom.MaxDelayMs
+		}
+		if custom.Multiplier >= 1 {
+			policy.Multiplier = custom.Multiplier
+		}
+		if custom.Jitter > 0 && custom.Jitter <= 1 {
+			policy.Jitter = custom.Jitter
+		}
+		if custom.MaxRetryAfterMs > 0 {
+			policy.MaxRetryAfterMs = custom.MaxRetryAfterMs
+		}
+	}
+	return policy
+}
+
+// delay returns how long to wait before retry number attempt (1-based). A
+// server supplied Retry-After wins over the backoff; when it asks for longer
+// than MaxRetryAfterMs, ok is false and the caller should move on instead.
+func (p RetryPolicy) delay(attempt int, perr *ProviderError) (wait time.Duration, ok bool) {
+	if perr != nil && perr.RetryAfter > 0 {
+		if perr.RetryAfter > time.Duration(p.MaxRetryAfterMs)*time.Millisecond {
+			return perr.RetryAfter, false
+		}
+		return perr.RetryAfter, true
+	}
+
+	backoff := float64(p.InitialDelayMs) * math.Pow(p.Multiplier, float64(attempt-1))
+	if backoff > float64(p.MaxDelayMs) {
+		backoff = float64(p.MaxDelayMs)
+	}
+	// Spread retries by +/- Jitter so parallel clients do not retry in lockstep
+	backoff *= 1 - p.Jitter + rand.Float64()*2*p.Jitter
+
+	return time.Duration(backoff) * time.Millisecond, true
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{"none", nil, 0},
+		{"seconds", map[string]string{"Retry-After": "3"}, 3 * time.Second},
+		{"fractional seconds", map[string]string{"Retry-After": "1.5"}, 1500 * time.Millisecond},
+		{"http date", map[string]string{"Retry-After": now.Add(90 * time.Second).Format(http.TimeFormat)}, 90 * time.Second},
+		{"http date in the past", map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, 0},
+		{"garbage", map[string]string{"Retry-After": "soon"}, 0},
+		{"reset duration", map[string]string{"x-ratelimit-reset-requests": "6m0s"}, 6 * time.Minute},
+		{"reset seconds", map[string]string{"x-ratelimit-reset": "20"}, 20 * time.Second},
+		{"reset unix seconds", map[string]string{"x-ratelimit-reset": fmt.Sprint(now.Add(time.Minute).Unix())}, time.Minute},
+		{"reset unix millis", map[string]string{"x-ratelimit-reset": fmt.Sprint(now.Add(2 * time.Second).UnixMilli())}, 2 * time.Second},
+		{"reset rfc3339", map[string]string{"anthropic-ratelimit-tokens-reset": now.Add(30 * time.Second).Format(time.RFC3339)}, 30 * time.Second},
+		{"reset rfc3339 in the past", map[string]string{"anthropic-ratelimit-requests-reset": now.Add(-time.Hour).Format(time.RFC3339)}, 0},
+		{"retry-after wins", map[string]string{"Retry-After": "2", "x-ratelimit-reset": "50"}, 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			if got := parseRetryAfter(h, now, true); got != tt.want {
+				t.Errorf("parseRetryAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPErrorRetryAfter(t *testing.T) {
+	tests := []struct {
+		status int
+		header map[string]string
+		want   time.Duration
+	}{
+		{http.StatusTooManyRequests, map[string]string{"x-ratelimit-reset-requests": "6m0s"}, 6 * time.Minute},
+		{http.StatusTooManyRequests, map[string]string{"Retry-After": "3"}, 3 * time.Second},
+		{http.StatusServiceUnavailable, map[string]string{"Retry-After": "3"}, 3 * time.Second},
+		{http.StatusServiceUnavailable, map[string]string{"x-ratelimit-reset-requests": "6m0s"}, 0},
+		{http.StatusInternalServerError, map[string]string{"x-ratelimit-reset-requests": "6m0s", "Retry-After": "3"}, 0},
+		{http.StatusBadGateway, map[string]string{"x-ratelimit-reset-tokens": "6m0s"}, 0},
+	}
+
+	policy := RetryPolicy{InitialDelayMs: 100, MaxDelayMs: 1000, Multiplier: 2, MaxRetryAfterMs: 5000}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		for k, v := range tt.header {
+			resp.Header.Set(k, v)
+		}
+		perr := newHTTPError("p", resp, nil)
+		if perr.RetryAfter != tt.want {
+			t.Errorf("HTTP %d %v: RetryAfter = %v, want %v", tt.status, tt.header, perr.RetryAfter, tt.want)
+		}
+		// A server error with reset headers still gets the backoff retry
+		if tt.want == 0 {
+			if _, ok := policy.delay(1, perr); !ok {
+				t.Errorf("HTTP %d %v was not retried", tt.status, tt.header)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialDelayMs: 100, MaxDelayMs: 1000, Multiplier: 2, MaxRetryAfterMs: 5000}
+	tests := []struct {
+		name    string
+		attempt int
+		perr    *ProviderError
+		want    time.Duration
+		ok      bool
+	}{
+		{"first attempt", 1, nil, 100 * time.Millisecond, true},
+		{"backoff grows", 3, nil, 400 * time.Millisecond, true},
+		{"backoff capped", 10, nil, time.Second, true},
+		{"retry-after used", 1, &ProviderError{RetryAfter: 3 * time.Second}, 3 * time.Second, true},
+		{"retry-after too long", 1, &ProviderError{RetryAfter: time.Minute}, time.Minute, false},
+		{"negative retry-after ignored", 2, &ProviderError{RetryAfter: -time.Second}, 200 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := policy.delay(tt.attempt, tt.perr)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("delay = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := RetryPolicy{InitialDelayMs: 1000, MaxDelayMs: 1000, Multiplier: 2, Jitter: 0.2}
+	for i := 0; i < 200; i++ {
+		got, _ := policy.delay(1, nil)
+		if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("delay %v outside 1s +/- 20%%", got)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestToProviderError(t *testing.T) {
+	existing := &ProviderError{Provider: "other", Type: ErrTypeAuth}
+	tests := []struct {
+		name     string
+		err      error
+		response *Response
+		want     string // "" for no error
+	}{
+		{"success", nil, &Response{}, ""},
+		{"nil response", nil, nil, ""},
+		{"canceled", context.Canceled, nil, ErrTypeCanceled},
+		{"wrapped canceled", fmt.Errorf("read body: %w", context.Canceled), nil, ErrTypeCanceled},
+		{"deadline", context.DeadlineExceeded, nil, ErrTypeTimeout},
+		{"net timeout", timeoutError{}, nil, ErrTypeTimeout},
+		{"net error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, nil, ErrTypeNetwork},
+		{"other", errors.New("boom"), nil, ErrTypeUnknown},
+		{"already classified", fmt.Errorf("wrapped: %w", existing), nil, ErrTypeAuth},
+		{"body rate limit", nil, &Response{Error: &APIError{Message: "slow", Type: "rate_limit_error"}}, ErrTypeRateLimit},
+		{"body exhausted", nil, &Response{Error: &APIError{Message: "quota", Type: "RESOURCE_EXHAUSTED"}}, ErrTypeRateLimit},
+		{"body invalid", nil, &Response{Error: &APIError{Message: "bad", Type: "invalid_request_error"}}, ErrTypeBadRequest},
+		{"body auth", nil, &Response{Error: &APIError{Message: "key", Type: "authentication_error"}}, ErrTypeAuth},
+		{"body other", nil, &Response{Error: &APIError{Message: "oops", Type: "overloaded_error"}}, ErrTypeServerError},
+		{"body without message", nil, &Response{Error: &APIError{Type: "rate_limit_error"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perr := toProviderError("p", tt.err, tt.response)
+			switch {
+			case tt.want == "" && perr != nil:
+				t.Errorf("got %v, want no error", perr)
+			case tt.want != "" && perr == nil:
+				t.Errorf("got no error, want %s", tt.want)
+			case perr != nil && perr.Type != tt.want:
+				t.Errorf("type = %s, want %s", perr.Type, tt.want)
+			}
+		})
+	}
+
+	if perr := toProviderError("p", context.Canceled, nil); perr.Retryable() {
+		t.Error("a cancelled request must not be retried")
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	tests := map[int]string{
+		400: ErrTypeBadRequest,
+		401: ErrTypeAuth,
+		403: ErrTypeAuth,
+		404: ErrTypeNotFound,
+		408: ErrTypeTimeout,
+		422: ErrTypeBadRequest,
+		429: ErrTypeRateLimit,
+		500: ErrTypeServerError,
+		503: ErrTypeServerError,
+		504: ErrTypeTimeout,
+		529: ErrTypeServerError,
+	}
+	for status, want := range tests {
+		if got := classifyStatus(status); got != want {
+			t.Errorf("classifyStatus(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
+
+func TestListModelsHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"slow down"}}`)
+	}))
+	defer server.Close()
+
+	providers := map[string]Provider{
+		"openai":    newOpenAIProvider(AIProvider{Name: "openai", Endpoint: server.URL + "/v1/chat/completions"}),
+		"anthropic": newAnthropicProvider(AIProvider{Name: "anthropic", Endpoint: server.URL}),
+		"gemini":    newGeminiProvider(AIProvider{Name: "gemini", Endpoint: server.URL}),
+		"ollama":    newOllamaProvider(AIProvider{Name: "ollama", Endpoint: server.URL + "/api/chat"}),
+	}
+	for name, p := range providers {
+		_, err := p.ListModels(context.Background())
+		var perr *ProviderError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: err = %v, want a ProviderError", name, err)
+			continue
+		}
+		if perr.Provider != name || perr.Type != ErrTypeRateLimit || perr.RetryAfter != 7*time.Second || perr.Message != "slow down" {
+			t.Errorf("%s: got %+v", name, perr)
+		}
+	}
+}
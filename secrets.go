@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// API keys entered with `provider add` or POST /api/providers go to gopass
+// when USE_GOPASS is on, otherwise into secrets.json encrypted with the
+// SecurityManager key. Environment variables still take precedence.
+
+func getSecretsFile() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, configDir, "secrets.json")
+}
+
+// loadProviderSecrets maps provider names to encrypted API keys
+func loadProviderSecrets() map[string]string {
+	secrets := map[string]string{}
+	data, err := os.ReadFile(getSecretsFile())
+	if err != nil {
+		return secrets
+	}
+	json.Unmarshal(data, &secrets)
+	return secrets
+}
+
+func saveProviderSecrets(secrets map[string]string) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getSecretsFile(), data, 0600)
+}
+
+func storeProviderSecret(name string, config AIProviderConfig, apiKey string) error {
+	if useGopass && config.GopassKey != "" {
+		cmd := exec.Command("gopass", "insert", "-f", config.GopassKey)
+		cmd.Stdin = strings.NewReader(apiKey + "\n")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("gopass insert failed: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	if securityMgr == nil {
+		return fmt.Errorf("security manager not initialized")
+	}
+	encrypted, err := securityMgr.encrypt(apiKey)
+	if err != nil {
+		return err
+	}
+	secrets := loadProviderSecrets()
+	secrets[name] = encrypted
+	return saveProviderSecrets(secrets)
+}
+
+func storedProviderSecret(name string) string {
+	encrypted, ok := loadProviderSecrets()[name]
+	if !ok || securityMgr == nil {
+		return ""
+	}
+	apiKey, err := securityMgr.decrypt(encrypted)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to decrypt API key for %s: %v\n", name, err)
+		return ""
+	}
+	return apiKey
+}
+
+func deleteProviderSecret(name string) error {
+	secrets := loadProviderSecrets()
+	if _, ok := secrets[name]; !ok {
+		return nil
+	}
+	delete(secrets, name)
+	return saveProviderSecrets(secrets)
+}
@@ -12,6 +12,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -171,6 +172,9 @@ func (sm *SecurityManager) saveUsers() error {
 }
 
 func (sm *SecurityManager) CreateUser(username, password, role string) error {
+	if strings.HasPrefix(username, "@") {
+		return errors.New("usernames starting with @ are reserved")
+	}
 	if _, exists := sm.users[username]; exists {
 		return errors.New("user already exists")
 	}
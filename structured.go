@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultJSONRetries = 2
+	maxSchemaErrors    = 10
+	maxSchemaRefDepth  = 32 // stops a $ref that leads back to itself
+)
+
+// ResponseFormat asks for JSON output, in the OpenAI request shape:
+// {"type": "json_object"} or {"type": "json_schema", "json_schema": {...}}
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type JSONSchemaFormat struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict,omitempty"`
+}
+
+// cliResponseFormat and cliJSONRetries come from --json-schema and
+// --json-retries. The JSON reply goes to resultOut while everything else is
+// sent to stderr.
+var cliResponseFormat *ResponseFormat
+var cliJSONRetries = DefaultJSONRetries
+
+// StructuredOutputError is returned when no reply matched the schema
+type StructuredOutputError struct {
+	Errors []string
+	Reply  string
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("reply does not match the JSON schema: %s", strings.Join(e.Errors, "; "))
+}
+
+func (f *ResponseFormat) schema() json.RawMessage {
+	if f == nil || f.JSONSchema == nil {
+		return nil
+	}
+	return f.JSONSchema.Schema
+}
+
+func (f *ResponseFormat) validate() error {
+	switch f.Type {
+	case "json_object":
+		return nil
+	case "json_schema":
+		if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+			return fmt.Errorf("response_format json_schema needs a schema")
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(f.JSONSchema.Schema, &schema); err != nil {
+			return fmt.Errorf("schema must be a JSON object: %w", err)
+		}
+		if f.JSONSchema.Name == "" {
+			f.JSONSchema.Name = "response"
+		}
+		return nil
+	default:
+		return fmt.Errorf("response_format type must be json_object or json_schema")
+	}
+}
+
+// instruction tells providers without a native JSON mode what to return
+func (f *ResponseFormat) instruction() string {
+	if schema := f.schema(); schema != nil {
+		return "Reply with a single JSON value and nothing else (no prose, no code fences) that matches this JSON Schema:\n" + string(schema)
+	}
+	return "Reply with a single JSON object and nothing else (no prose, no code fences)."
+}
+
+var codeFencePattern = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+
+// extractJSON strips code fences and any text around the outermost JSON
+// object or array, which models add even when told not to.
+func extractJSON(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if match := codeFencePattern.FindStringSubmatch(reply); match != nil {
+		reply = match[1]
+	}
+	if json.Valid([]byte(reply)) {
+		return reply
+	}
+
+	start := strings.IndexAny(reply, "{[")
+	if start < 0 {
+		return reply
+	}
+	closer := "}"
+	if reply[start] == '[' {
+		closer = "]"
+	}
+	if end := strings.LastIndex(reply, closer); end > start {
+		return reply[start : end+1]
+	}
+	return reply
+}
+
+// checkStructuredReply returns the reply as clean indented JSON, or the
+// problems found in it.
+func checkStructuredReply(reply string, format *ResponseFormat) ([]byte, []string) {
+	data := []byte(extractJSON(reply))
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, []string{"reply is not valid JSON: " + err.Error()}
+	}
+	if format.Type == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return nil, []string{"reply must be a JSON object"}
+		}
+	}
+	if schema := format.schema(); schema != nil {
+		if errs := validateJSON(value, schema); len(errs) > 0 {
+			return nil, errs
+		}
+	}
+
+	var out bytes.Buffer
+	json.Indent(&out, data, "", "  ")
+	return out.Bytes(), nil
+}
+
+// structuredChat sends req with its response format and re-asks with the
+// validation errors until the reply matches, at most retries more times.
+func structuredChat(ctx context.Context, req Request, primaryProvider string, retries int) ([]byte, *Response, string, error) {
+	req.Messages = append([]Message{}, req.Messages...)
+
+	for attempt := 0; ; attempt++ {
+		response, actualProvider, err := runAgent(ctx, req, primaryProvider, nil, nil)
+		if err != nil {
+			return nil, response, actualProvider, err
+		}
+		if response.Error != nil {
+			return nil, response, actualProvider, fmt.Errorf("%s", response.Error.Message)
+		}
+		if len(response.Choices) == 0 {
+			return nil, response, actualProvider, fmt.Errorf("no response generated")
+		}
+
+		reply := response.Choices[0].Message.Content
+		data, errs := checkStructuredReply(reply, req.ResponseFormat)
+		if len(errs) == 0 {
+			return data, response, actualProvider, nil
+		}
+		if attempt >= retries {
+			return nil, response, actualProvider, &StructuredOutputError{Errors: errs, Reply: reply}
+		}
+
+		fmt.Fprintf(statusOut, "⚠️  Reply does not match the schema, asking again (%d/%d): %s\n", attempt+1, retries, errs[0])
+		req.Messages = append(req.Messages,
+			Message{Role: "assistant", Content: reply},
+			Message{Role: "user", Content: "Your reply was rejected:\n- " + strings.Join(errs, "\n- ") + "\n\n" + req.ResponseFormat.instruction()},
+		)
+	}
+}
+
+// validateJSON checks value against a JSON Schema. It covers the keywords
+// used for describing output shapes: type, enum, const, properties,
+// required, additionalProperties, items, length and range limits, pattern,
+// allOf/anyOf/oneOf and local $ref.
+func validateJSON(value interface{}, schema json.RawMessage) []string {
+	var root interface{}
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return []string{"invalid schema: " + err.Error()}
+	}
+	v := &schemaValidator{root: root}
+	v.check("$", value, root)
+	return v.errors
+}
+
+type schemaValidator struct {
+	root   interface{}
+	errors []string
+	// $refs followed without moving to a nested value
+	refPath  string
+	refDepth int
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	if len(v.errors) < maxSchemaErrors {
+		v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// resolve follows a local "#/..." reference
+func (v *schemaValidator) resolve(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	node := v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = obj[token]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func (v *schemaValidator) valid(path string, value, schema interface{}) bool {
+	sub := &schemaValidator{root: v.root, refPath: v.refPath, refDepth: v.refDepth}
+	sub.check(path, value, schema)
+	return len(sub.errors) == 0
+}
+
+func (v *schemaValidator) check(path string, value, schemaNode interface{}) {
+	switch s := schemaNode.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	case map[string]interface{}:
+		v.checkObjectSchema(path, value, s)
+	}
+}
+
+func (v *schemaValidator) checkObjectSchema(path string, value interface{}, s map[string]interface{}) {
+	if ref, ok := s["$ref"].(string); ok {
+		target, found := v.resolve(ref)
+		if !found {
+			v.fail(path, "cannot resolve $ref %s", ref)
+			return
+		}
+		if path != v.refPath {
+			v.refPath, v.refDepth = path, 0
+		}
+		if v.refDepth >= maxSchemaRefDepth {
+			v.fail(path, "$ref nested too deeply")
+			return
+		}
+		v.refDepth++
+		v.check(path, value, target)
+		v.refDepth--
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(value, t) {
+		v.fail(path, "expected %s, got %s", describeType(t), jsonTypeOf(value))
+		return
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options, _ := json.Marshal(enum)
+			v.fail(path, "must be one of %s", options)
+		}
+	}
+	if constant, ok := s["const"]; ok && !reflect.DeepEqual(constant, value) {
+		expected, _ := json.Marshal(constant)
+		v.fail(path, "must be %s", expected)
+	}
+
+	for _, sub := range schemaList(s["allOf"]) {
+		v.check(path, value, sub)
+	}
+	if options := schemaList(s["anyOf"]); len(options) > 0 {
+		matched := false
+		for _, sub := range options {
+			if v.valid(path, value, sub) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any of the allowed shapes")
+		}
+	}
+	if options := schemaList(s["oneOf"]); len(options) > 0 {
+		matches := 0
+		for _, sub := range options {
+			if v.valid(path, value, sub) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "must match exactly one allowed shape, matches %d", matches)
+		}
+	}
+
+	switch val := value.(type) {
+	case string:
+		length := len([]rune(val))
+		if min, ok := schemaNumber(s, "minLength"); ok && float64(length) < min {
+			v.fail(path, "must be at least %g characters", min)
+		}
+		if max, ok := schemaNumber(s, "maxLength"); ok && float64(length) > max {
+			v.fail(path, "must be at most %g characters", max)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(val) {
+				v.fail(path, "must match pattern %s", pattern)
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(s, "minimum"); ok && val < min {
+			v.fail(path, "must be >= %g", min)
+		}
+		if max, ok := schemaNumber(s, "maximum"); ok && val > max {
+			v.fail(path, "must be <= %g", max)
+		}
+		if min, ok := schemaNumber(s, "exclusiveMinimum"); ok && val <= min {
+			v.fail(path, "must be > %g", min)
+		}
+		if max, ok := schemaNumber(s, "exclusiveMaximum"); ok && val >= max {
+			v.fail(path, "must be < %g", max)
+		}
+	case []interface{}:
+		if min, ok := schemaNumber(s, "minItems"); ok && float64(len(val)) < min {
+			v.fail(path, "must have at least %g items", min)
+		}
+		if max, ok := schemaNumber(s, "maxItems"); ok && float64(len(val)) > max {
+			v.fail(path, "must have at most %g items", max)
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range val {
+				v.check(path+"["+strconv.Itoa(i)+"]", item, items)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schemaStrings(s["required"]) {
+			if _, ok := val[name]; !ok {
+				v.fail(path, "missing required property %q", name)
+			}
+		}
+
+		properties, _ := s["properties"].(map[string]interface{})
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := properties[name]; ok {
+				v.check(path+"."+name, val[name], sub)
+				continue
+			}
+			if additional, ok := s["additionalProperties"]; ok {
+				if allowed, isBool := additional.(bool); isBool && !allowed {
+					v.fail(path, "unexpected property %q", name)
+				} else {
+					v.check(path+"."+name, val[name], additional)
+				}
+			}
+		}
+	}
+}
+
+func matchesType(value interface{}, t interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		actual := jsonTypeOf(value)
+		return actual == t || (t == "number" && actual == "integer")
+	case []interface{}:
+		for _, option := range t {
+			if matchesType(value, option) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func describeType(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		var names []string
+		for _, option := range list {
+			names = append(names, fmt.Sprint(option))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func schemaNumber(s map[string]interface{}, key string) (float64, bool) {
+	n, ok := s[key].(float64)
+	return n, ok
+}
+
+func schemaList(node interface{}) []interface{} {
+	list, _ := node.([]interface{})
+	return list
+}
+
+func schemaStrings(node interface{}) []string {
+	var out []string
+	for _, item := range schemaList(node) {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// loadStructuredFlags removes --json-schema and --json-retries from os.Args.
+// It runs before anything is printed: in JSON mode stdout carries only the
+// answer and status messages go to statusOut, see loadOutputFlags.
+func loadStructuredFlags() {
+	var rest []string
+	schemaPath := ""
+
+	args := os.Args
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--json-schema" && name != "--json-retries" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "❌ %s needs a value\n", name)
+				os.Exit(1)
+			}
+			value = args[i+1]
+			i++
+		}
+
+		if name == "--json-schema" {
+			schemaPath = value
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "❌ invalid --json-retries %q\n", value)
+			os.Exit(1)
+		}
+		cliJSONRetries = n
+	}
+	os.Args = rest
+
+	if schemaPath == "" {
+		return
+	}
+	data, err := os.ReadFile(schemaPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to read schema: %v\n", err)
+		os.Exit(1)
+	}
+	format := &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &JSONSchemaFormat{Name: schemaName(schemaPath), Schema: json.RawMessage(data)},
+	}
+	if err := format.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s: %v\n", schemaPath, err)
+		os.Exit(1)
+	}
+	cliResponseFormat = format
+}
+
+// schemaName derives the json_schema name from the file name; OpenAI only
+// allows letters, digits, underscores and dashes.
+func schemaName(path string) string {
+	base := path[strings.LastIndex(path, "/")+1:]
+	base = strings.TrimSuffix(base, ".json")
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" {
+		return "response"
+	}
+	return name
+}
+
+// structuredChatCLI answers one prompt in JSON mode and exits non-zero when
+// no valid reply was produced.
+func structuredChatCLI(providerName string, provider AIProvider, message string, attachments []Attachment) {
+	ctx, stop := interruptibleContext()
+	defer stop()
+
+	model := provider.Model
+	if personaModel(providerName) != "" {
+		model = personaModel(providerName)
+	}
+
+	injected := ContextAssembler{Memory: true, Model: model}.assemble(ctx, message)
+	if len(injected.Sources) > 0 {
+		fmt.Fprintf(statusOut, "📚 Context: %s\n", injected)
+	}
+
+	data, _, _, err := structuredChat(ctx, Request{
+		Model:            model,
+		Messages:         withSystemMessage(systemPrompt(), injected.inject([]Message{{Role: "user", Content: message, Attachments: attachments}})),
+		Tools:            cliTools,
+		MaxSteps:         cliMaxSteps,
+		ResponseFormat:   cliResponseFormat,
+		GenerationParams: resolveParams(injected.Skills, nil, cliParams),
+	}, providerName, cliJSONRetries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		if structErr, ok := err.(*StructuredOutputError); ok {
+			fmt.Fprintf(os.Stderr, "Last reply:\n%s\n", structErr.Reply)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(resultOut, string(data))
+}
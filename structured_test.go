@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  string
+	}{
+		{`{"a":1}`, `{"a":1}`},
+		{"  [1,2]\n", `[1,2]`},
+		{"```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"```\n[true]\n```", `[true]`},
+		{`Here you go: {"a":{"b":2}} hope it helps`, `{"a":{"b":2}}`},
+		{`Result: [1, {"x": 2}] done`, `[1, {"x": 2}]`},
+		{`"just a string"`, `"just a string"`},
+		{`no json here`, `no json here`},
+		{`broken { only`, `broken { only`},
+	}
+
+	for _, tt := range tests {
+		if got := extractJSON(tt.reply); got != tt.want {
+			t.Errorf("extractJSON(%q) = %q, want %q", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	person := `{
+		"type": "object",
+		"required": ["name", "age"],
+		"properties": {
+			"name": {"type": "string", "minLength": 2, "pattern": "^[A-Z]"},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"role": {"enum": ["admin", "user"]}
+		},
+		"additionalProperties": false
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		errors []string // substrings, one per expected error
+	}{
+		{"valid", person, `{"name":"Ali","age":30,"tags":["a"],"role":"user"}`, nil},
+		{"missing required", person, `{"name":"Ali"}`, []string{`$: missing required property "age"`}},
+		{"wrong type", person, `{"name":"Ali","age":"30"}`, []string{"$.age: expected integer, got string"}},
+		{"integer as float", person, `{"name":"Ali","age":30.5}`, []string{"$.age: expected integer, got number"}},
+		{"limits", person, `{"name":"a","age":150}`, []string{"$.age: must be < 150", "$.name: must be at least 2", "$.name: must match pattern"}},
+		{"items", person, `{"name":"Ali","age":1,"tags":["a",2,"c"]}`, []string{"$.tags: must have at most 2 items", "$.tags[1]: expected string"}},
+		{"enum", person, `{"name":"Ali","age":1,"role":"root"}`, []string{`$.role: must be one of ["admin","user"]`}},
+		{"additional", person, `{"name":"Ali","age":1,"x":1}`, []string{`$: unexpected property "x"`}},
+		{"number accepts integer", `{"type":"number"}`, `3`, nil},
+		{"type list", `{"type":["string","null"]}`, `null`, nil},
+		{"type list mismatch", `{"type":["string","null"]}`, `1`, []string{"$: expected"}},
+		{"const", `{"const":"yes"}`, `"no"`, []string{`$: must be "yes"`}},
+		{"anyOf", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `true`, []string{"does not match any"}},
+		{"oneOf both", `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, `1`, []string{"must match exactly one allowed shape, matches 2"}},
+		{"allOf", `{"allOf":[{"type":"string"},{"maxLength":2}]}`, `"abc"`, []string{"at most 2 characters"}},
+		{"false schema", `{"properties":{"x":false}}`, `{"x":1}`, []string{"$.x: no value is allowed here"}},
+		{"ref", `{"$defs":{"id":{"type":"integer"}},"properties":{"id":{"$ref":"#/$defs/id"}}}`, `{"id":"a"}`, []string{"$.id: expected integer"}},
+		{"recursive ref", `{"type":"object","properties":{"child":{"$ref":"#"}},"required":["n"]}`, `{"n":1,"child":{"n":2,"child":{}}}`, []string{`$.child.child: missing required property "n"`}},
+		{"missing ref", `{"$ref":"#/nope"}`, `1`, []string{"cannot resolve $ref #/nope"}},
+		{"self ref", `{"$ref":"#"}`, `1`, []string{"$ref nested too deeply"}},
+		{"self ref in anyOf", `{"anyOf":[{"$ref":"#"}]}`, `1`, []string{"does not match any"}},
+		{"deep recursive data", `{"properties":{"c":{"$ref":"#"}}}`, strings.Repeat(`{"c":`, 100) + `1` + strings.Repeat(`}`, 100), nil},
+		{"bad schema", `{`, `1`, []string{"invalid schema"}},
+		{"unicode length", `{"maxLength":2}`, `"ää"`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			errs := validateJSON(value, json.RawMessage(tt.schema))
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("errors = %q, want %d matching %q", errs, len(tt.errors), tt.errors)
+			}
+			for i, want := range tt.errors {
+				if !strings.Contains(errs[i], want) {
+					t.Errorf("error %d = %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJSONCapsErrors(t *testing.T) {
+	var value interface{}
+	json.Unmarshal([]byte(`[1,2,3,4,5,6,7,8,9,10,11,12]`), &value)
+	errs := validateJSON(value, json.RawMessage(`{"items":{"type":"string"}}`))
+	if len(errs) != maxSchemaErrors {
+		t.Errorf("got %d errors, want %d", len(errs), maxSchemaErrors)
+	}
+}
+
+func TestCheckStructuredReply(t *testing.T) {
+	schema := &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{
+		Name:   "person",
+		Schema: json.RawMessage(`{"type":"object","required":["name"]}`),
+	}}
+
+	data, errs := checkStructuredReply("```json\n{\"name\":\"Ali\"}\n```", schema)
+	if errs != nil || string(data) != "{\n  \"name\": \"Ali\"\n}" {
+		t.Errorf("reply = %q, errors = %q", data, errs)
+	}
+	if _, errs := checkStructuredReply(`{"age":3}`, schema); len(errs) != 1 {
+		t.Errorf("schema errors = %q", errs)
+	}
+	if _, errs := checkStructuredReply(`not json`, schema); len(errs) != 1 || !strings.Contains(errs[0], "not valid JSON") {
+		t.Errorf("errors = %q", errs)
+	}
+	if _, errs := checkStructuredReply(`[1]`, &ResponseFormat{Type: "json_object"}); len(errs) != 1 {
+		t.Errorf("json_object accepted an array: %q", errs)
+	}
+}
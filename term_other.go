@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Without termios the REPL reads plain lines; a character device is still
+// treated as an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, fmt.Errorf("raw mode not supported")
+}
+
+func terminalWidth(f *os.File) int {
+	return 80
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// imageTokens is charged for every attached image. Providers bill images
+// by size and detail; this is a middle-of-the-road figure.
+const imageTokens = 1000
+
+// tokenizerProfile approximates how a model family's tokenizer splits text.
+// Counting is done on the same word/number/punctuation pieces a BPE
+// pre-tokenizer produces, so code and numbers come out closer than a flat
+// characters-per-token ratio.
+type tokenizerProfile struct {
+	Name string
+	// CharsPerToken is the average length of a token inside a Latin word
+	CharsPerToken float64
+	// DigitsPerToken is how many digits a number token holds
+	DigitsPerToken int
+	// MessageTokens is the framing overhead of one chat message
+	MessageTokens int
+}
+
+var (
+	gptTokenizer    = tokenizerProfile{Name: "gpt", CharsPerToken: 4.0, DigitsPerToken: 3, MessageTokens: 4}
+	claudeTokenizer = tokenizerProfile{Name: "claude", CharsPerToken: 3.5, DigitsPerToken: 3, MessageTokens: 5}
+	geminiTokenizer = tokenizerProfile{Name: "gemini", CharsPerToken: 4.0, DigitsPerToken: 1, MessageTokens: 4}
+	llamaTokenizer  = tokenizerProfile{Name: "llama", CharsPerToken: 3.7, DigitsPerToken: 3, MessageTokens: 5}
+)
+
+// tokenizerFor picks the profile by model name; unknown models count like GPT
+func tokenizerFor(model string) tokenizerProfile {
+	m := strings.ToLower(model)
+	switch {
+	case strings.Contains(m, "claude"):
+		return claudeTokenizer
+	case strings.Contains(m, "gemini"), strings.Contains(m, "gemma"):
+		return geminiTokenizer
+	case strings.Contains(m, "llama"), strings.Contains(m, "mistral"), strings.Contains(m, "mixtral"),
+		strings.Contains(m, "qwen"), strings.Contains(m, "deepseek"), strings.Contains(m, "phi"):
+		return llamaTokenizer
+	}
+	return gptTokenizer
+}
+
+type runeClass int
+
+const (
+	classSpace runeClass = iota
+	classLatin
+	classDigit
+	classScript // letters of other alphabets, e.g. Cyrillic or Arabic
+	classIdeograph
+	classSymbol
+)
+
+func classify(r rune) runeClass {
+	switch {
+	case unicode.IsSpace(r):
+		return classSpace
+	case unicode.IsDigit(r):
+		return classDigit
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai):
+		return classIdeograph
+	case unicode.IsLetter(r) && r < 0x250:
+		return classLatin
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return classScript
+	}
+	return classSymbol
+}
+
+// count estimates the tokens in text
+func (p tokenizerProfile) count(text string) int {
+	tokens := 0
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		class := classify(runes[i])
+		j := i + 1
+		for j < len(runes) && classify(runes[j]) == class {
+			j++
+		}
+		n := j - i
+
+		switch class {
+		case classSpace:
+			// A single space joins the next word; newlines and indentation
+			// cost a token per run
+			if n > 1 || runes[i] != ' ' {
+				tokens++
+			}
+		case classLatin:
+			tokens += ceilDiv(float64(n), p.CharsPerToken)
+		case classDigit:
+			tokens += ceilDiv(float64(n), float64(p.DigitsPerToken))
+		case classScript:
+			tokens += ceilDiv(float64(n), 2)
+		case classIdeograph:
+			tokens += n
+		case classSymbol:
+			// Repeated symbols such as "----" or "====" merge
+			if runes[i] == runes[j-1] && n > 2 {
+				tokens += ceilDiv(float64(n), 4)
+			} else {
+				tokens += n
+			}
+		}
+		i = j
+	}
+	return tokens
+}
+
+func ceilDiv(n, d float64) int {
+	t := int(n / d)
+	if float64(t)*d < n {
+		t++
+	}
+	return t
+}
+
+// estimateTokens counts text the way the model's tokenizer roughly would
+func estimateTokens(model, text string) int {
+	return tokenizerFor(model).count(text)
+}
+
+// estimateMessageTokens includes the per-message overhead and images
+func estimateMessageTokens(model string, message Message) int {
+	p := tokenizerFor(model)
+	return p.MessageTokens + p.count(message.Content) + len(message.Attachments)*imageTokens
+}
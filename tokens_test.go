@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestTokenizerFor(t *testing.T) {
+	tests := map[string]string{
+		"anthropic/claude-3.5-sonnet": "claude",
+		"gemini-1.5-pro":              "gemini",
+		"gemma2:9b":                   "gemini",
+		"llama3.1:8b":                 "llama",
+		"qwen2.5-coder":               "llama",
+		"gpt-4o-mini":                 "gpt",
+		"something-new":               "gpt",
+	}
+	for model, want := range tests {
+		if got := tokenizerFor(model).Name; got != want {
+			t.Errorf("tokenizerFor(%q) = %s, want %s", model, got, want)
+		}
+	}
+}
+
+func TestTokenizerCount(t *testing.T) {
+	tests := []struct {
+		profile tokenizerProfile
+		text    string
+		want    int
+	}{
+		{gptTokenizer, "", 0},
+		{gptTokenizer, "hello", 2},
+		{gptTokenizer, "hello world", 4},
+		{claudeTokenizer, "hello world", 4},
+		{gptTokenizer, "a\n\nb", 3},
+		{gptTokenizer, "    x", 2},
+		{gptTokenizer, "1234567", 3},
+		{geminiTokenizer, "1234567", 7},
+		{gptTokenizer, "f(x);", 5},
+		{gptTokenizer, "----------", 3},
+		{gptTokenizer, "привет", 3},
+		{gptTokenizer, "你好世界", 4},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.count(tt.text); got != tt.want {
+			t.Errorf("%s count(%q) = %d, want %d", tt.profile.Name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateMessageTokens(t *testing.T) {
+	msg := Message{Role: "user", Content: "hello", Attachments: []Attachment{{}, {}}}
+	if got := estimateMessageTokens("gpt-4o", msg); got != 4+2+2*imageTokens {
+		t.Errorf("estimateMessageTokens = %d", got)
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultMaxToolSteps = 5
+	MaxToolStepsLimit   = 20
+	MaxToolResultChars  = 8000
+)
+
+// Tool is an OpenAI-style function definition sent with a request
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is a function call requested by the model. Arguments is a JSON
+// encoded object, as in the OpenAI API.
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCallDelta is a piece of a tool call in an OpenAI stream. The id and
+// name come first, the arguments arrive in fragments under the same index.
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolRun records one executed tool call
+type ToolRun struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LocalTool is a built-in tool the model may call
+type LocalTool struct {
+	Name        string
+	Description string
+	Parameters  string
+	Run         func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+var builtinTools = []LocalTool{
+	{
+		Name:        "web_fetch",
+		Description: "Fetch a web page over http(s) and return its text content.",
+		Parameters:  `{"type":"object","properties":{"url":{"type":"string","description":"Absolute http or https URL"}},"required":["url"]}`,
+		Run:         runWebFetchTool,
+	},
+	{
+		Name:        "rag_search",
+		Description: "Search the user's indexed local documents and return the best matches.",
+		Parameters:  `{"type":"object","properties":{"query":{"type":"string","description":"Search keywords"}},"required":["query"]}`,
+		Run:         runRAGSearchTool,
+	},
+	{
+		Name:        "memory_recall",
+		Description: "Search the user's long-term memory for facts and preferences saved earlier.",
+		Parameters:  `{"type":"object","properties":{"query":{"type":"string","description":"What to look for"}},"required":["query"]}`,
+		Run:         runMemoryRecallTool,
+	},
+	{
+		Name:        "memory_save",
+		Description: "Save a fact or preference about the user to long-term memory.",
+		Parameters:  `{"type":"object","properties":{"content":{"type":"string","description":"The fact to remember"},"tags":{"type":"array","items":{"type":"string"}}},"required":["content"]}`,
+		Run:         runMemorySaveTool,
+	},
+}
+
+// ToolOptions lets web chat requests turn on tools by name
+type ToolOptions struct {
+	Tools    []string `json:"tools,omitempty"`
+	MaxSteps int      `json:"max_steps,omitempty"`
+}
+
+// definitions validates the options and returns the tools to send
+func (o ToolOptions) definitions() ([]Tool, error) {
+	if o.MaxSteps < 0 || o.MaxSteps > MaxToolStepsLimit {
+		return nil, fmt.Errorf("max_steps must be 0 for the default, or 1 to %d", MaxToolStepsLimit)
+	}
+	if len(o.Tools) == 0 {
+		return nil, nil
+	}
+	return toolDefinitions(o.Tools)
+}
+
+// cliTools and cliMaxSteps come from --tools and --max-steps
+var cliTools []Tool
+var cliMaxSteps int
+
+func findLocalTool(name string) *LocalTool {
+	for i := range builtinTools {
+		if builtinTools[i].Name == name {
+			return &builtinTools[i]
+		}
+	}
+	return nil
+}
+
+// toolDefinitions returns the definitions of the named tools, or of every
+// built-in tool when names is empty.
+func toolDefinitions(names []string) ([]Tool, error) {
+	if len(names) == 0 {
+		for _, tool := range builtinTools {
+			names = append(names, tool.Name)
+		}
+	}
+
+	var tools []Tool
+	for _, name := range names {
+		local := findLocalTool(name)
+		if local == nil {
+			return nil, fmt.Errorf("unknown tool: %s", name)
+		}
+		tools = append(tools, Tool{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        local.Name,
+				Description: local.Description,
+				Parameters:  json.RawMessage(local.Parameters),
+			},
+		})
+	}
+	return tools, nil
+}
+
+func toolNames(tools []Tool) string {
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+type localRunKey struct{}
+
+// withLocalRun marks a context as a command line run by the owner of the
+// machine. Only these runs may read unowned documents and memories and fetch
+// private addresses; the username in the usage scope is not enough, since
+// CLI sessions carry one too.
+func withLocalRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localRunKey{}, true)
+}
+
+func isLocalRun(ctx context.Context) bool {
+	local, _ := ctx.Value(localRunKey{}).(bool)
+	return local
+}
+
+// toolUser is the web user a tool runs for, or "" on the command line, whose
+// documents and memories are saved without an owner.
+func toolUser(ctx context.Context) string {
+	if isLocalRun(ctx) {
+		return ""
+	}
+	return usageScopeFrom(ctx).User
+}
+
+func runWebFetchTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+
+	target, err := url.Parse(in.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return "", fmt.Errorf("only http and https URLs can be fetched")
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(fetchCtx, "GET", target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("User-Agent", "terminal-ai")
+
+	client := publicOnlyClient
+	if isLocalRun(ctx) {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("%s returned status %d", target, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	text := string(body)
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		text = stripHTML(text)
+	}
+	return text, nil
+}
+
+// publicOnlyClient refuses to connect to loopback and private addresses, so
+// web users cannot reach the server's own network, including via redirects
+// or DNS names that resolve to internal hosts.
+var publicOnlyClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, _ := net.SplitHostPort(address)
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+					return fmt.Errorf("fetching private addresses is not allowed")
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+var (
+	htmlScriptPattern = regexp.MustCompile(`(?is)<(script|style|noscript)[^>]*>.*?</(script|style|noscript)>`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]+>`)
+	blankLinePattern  = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// stripHTML keeps the readable text of a page
+func stripHTML(page string) string {
+	page = htmlScriptPattern.ReplaceAllString(page, "")
+	page = htmlTagPattern.ReplaceAllString(page, "\n")
+	replacer := strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", "\"", "&#39;", "'")
+	page = replacer.Replace(page)
+	return strings.TrimSpace(blankLinePattern.ReplaceAllString(page, "\n\n"))
+}
+
+func runRAGSearchTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+
+	results := searchRAGWithFilters(in.Query, toolUser(ctx), "")
+	if len(results) == 0 {
+		return "No matching documents.", nil
+	}
+
+	var sb strings.Builder
+	for _, doc := range results {
+		sb.WriteString(fmt.Sprintf("## %s\n%s\n\n", doc.Path, truncate(doc.Content, 1500)))
+	}
+	return sb.String(), nil
+}
+
+func runMemoryRecallTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+
+	mgr := GetEncryptedMemoryManager()
+	if mgr == nil {
+		return "", fmt.Errorf("memory is not available")
+	}
+	results, err := mgr.SearchUserAndDecrypt(ctx, in.Query, toolUser(ctx), DefaultTopK)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, result := range results {
+		sb.WriteString(fmt.Sprintf("- %s (saved %s)\n", result.Memory.Content, result.Memory.CreatedAt.Format("2006-01-02")))
+	}
+	if sb.Len() == 0 {
+		return "No matching memories.", nil
+	}
+	return sb.String(), nil
+}
+
+func runMemorySaveTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Content string   `json:"content"`
+		Tags    []string `json:"tags"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(in.Content) == "" {
+		return "", fmt.Errorf("content is empty")
+	}
+
+	mgr := GetEncryptedMemoryManager()
+	if mgr == nil {
+		return "", fmt.Errorf("memory is not available")
+	}
+	memory, err := mgr.AddEncryptedMemory(ctx, in.Content, MemoryMetadata{
+		Source:    "tool",
+		SessionID: usageScopeFrom(ctx).SessionID,
+		User:      toolUser(ctx),
+		Tags:      in.Tags,
+	})
+	if err != nil {
+		return "", err
+	}
+	return "Saved memory " + memory.ID, nil
+}
+
+// executeToolCall runs a requested tool. Failures are reported to the model
+// as the tool result so it can correct itself.
+func executeToolCall(ctx context.Context, call ToolCall) ToolRun {
+	run := ToolRun{Name: call.Function.Name, Arguments: call.Function.Arguments}
+
+	local := findLocalTool(call.Function.Name)
+	if local == nil {
+		run.Error = "unknown tool " + call.Function.Name
+		return run
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+	result, err := local.Run(ctx, args)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	if len(result) > MaxToolResultChars {
+		result = result[:MaxToolResultChars] + "\n[truncated]"
+	}
+	run.Result = result
+	return run
+}
+
+func (r ToolRun) content() string {
+	if r.Error != "" {
+		return "Error: " + r.Error
+	}
+	return r.Result
+}
+
+// toolCallsOf returns the tool calls of a response, if any
+func toolCallsOf(response *Response) []ToolCall {
+	if response == nil || len(response.Choices) == 0 {
+		return nil
+	}
+	return response.Choices[0].Message.ToolCalls
+}
+
+// runAgent sends req and, for as long as the model asks for tools, runs them
+// and sends the results back. After req.MaxSteps rounds the model is told to
+// answer with what it has. onDelta streams every round when set, otherwise
+// the request is non-streaming. onTool hears about each tool call once it ran.
+// Without tools in req this is a single ordinary request.
+func runAgent(ctx context.Context, req Request, primaryProvider string, onDelta func(string), onTool func(ToolRun)) (*Response, string, error) {
+	send := func(req Request) (*Response, string, error) {
+		if onDelta != nil {
+			return makeStreamingRequestWithFallback(ctx, req, primaryProvider, onDelta)
+		}
+		if providerConfig.FallbackEnabled {
+			return makeRequestWithFallback(ctx, req, primaryProvider)
+		}
+		response, err := makeRequest(ctx, primaryProvider, req)
+		return response, primaryProvider, err
+	}
+
+	if len(req.Tools) == 0 {
+		return send(req)
+	}
+
+	maxSteps := req.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxToolSteps
+	}
+	req.Messages = append([]Message{}, req.Messages...)
+
+	var runs []ToolRun
+	for step := 0; ; step++ {
+		if step == maxSteps {
+			fmt.Fprintf(statusOut, "⚠️  Tool step limit (%d) reached, asking for a final answer\n", maxSteps)
+			req.ToolChoice = "none"
+		}
+
+		response, actualProvider, err := send(req)
+		if err != nil {
+			return response, actualProvider, err
+		}
+		calls := toolCallsOf(response)
+		if len(calls) == 0 || req.ToolChoice == "none" || response.Error != nil {
+			response.ToolRuns = runs
+			return response, actualProvider, nil
+		}
+
+		// Providers without call ids (Gemini, Ollama) get generated ones so
+		// results can be matched up on any provider
+		assistant := response.Choices[0].Message
+		assistant.Role = "assistant"
+		assistant.ToolCalls = append([]ToolCall{}, calls...)
+		for i := range assistant.ToolCalls {
+			if assistant.ToolCalls[i].ID == "" {
+				assistant.ToolCalls[i].ID = fmt.Sprintf("call_%d_%d", step, i)
+			}
+			assistant.ToolCalls[i].Type = "function"
+		}
+		req.Messages = append(req.Messages, assistant)
+
+		for _, call := range assistant.ToolCalls {
+			fmt.Fprintf(statusOut, "🔧 %s %s\n", call.Function.Name, truncate(call.Function.Arguments, 120))
+			run := executeToolCall(ctx, call)
+			if run.Error != "" {
+				fmt.Fprintf(statusOut, "   ⚠️  %s\n", run.Error)
+			} else {
+				fmt.Fprintf(statusOut, "   ↳ %d chars\n", len(run.Result))
+			}
+			runs = append(runs, run)
+			if onTool != nil {
+				onTool(run)
+			}
+
+			req.Messages = append(req.Messages, Message{
+				Role:       "tool",
+				Content:    run.content(),
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+			})
+		}
+
+		if ctx.Err() != nil {
+			return nil, actualProvider, ctx.Err()
+		}
+	}
+}
+
+// loadToolFlags removes --tools[=a,b] and --max-steps from os.Args. A bare
+// --tools enables every built-in tool.
+func loadToolFlags() {
+	var rest []string
+	var names []string
+	enabled := false
+
+	args := os.Args
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		switch name {
+		case "--tools":
+			enabled = true
+			if hasValue {
+				names = append(names, splitProviderList(value)...)
+			}
+		case "--max-steps":
+			if !hasValue {
+				if i+1 >= len(args) {
+					fmt.Fprintln(os.Stderr, "❌ --max-steps needs a value")
+					os.Exit(1)
+				}
+				value = args[i+1]
+				i++
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > MaxToolStepsLimit {
+				fmt.Fprintf(os.Stderr, "❌ --max-steps must be between 1 and %d\n", MaxToolStepsLimit)
+				os.Exit(1)
+			}
+			cliMaxSteps = n
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	os.Args = rest
+
+	if !enabled {
+		return
+	}
+	tools, err := toolDefinitions(names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	cliTools = tools
+}
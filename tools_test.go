@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergeToolCallDelta(t *testing.T) {
+	var calls []ToolCall
+	for _, delta := range []ToolCallDelta{
+		{Index: 0, ID: "call_1", Function: ToolCallFunction{Name: "rag_search"}},
+		{Index: 0, Function: ToolCallFunction{Arguments: `{"query":`}},
+		{Index: 1, ID: "call_2", Function: ToolCallFunction{Name: "web_fetch", Arguments: `{}`}},
+		{Index: 0, Function: ToolCallFunction{Arguments: `"go"}`}},
+	} {
+		calls = mergeToolCallDelta(calls, delta)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls", len(calls))
+	}
+	if calls[0].ID != "call_1" || calls[0].Type != "function" || calls[0].Function.Arguments != `{"query":"go"}` {
+		t.Errorf("first call = %+v", calls[0])
+	}
+	if calls[1].Function.Name != "web_fetch" {
+		t.Errorf("second call = %+v", calls[1])
+	}
+}
+
+func TestMergeToolCallDeltaRejectsBadIndexes(t *testing.T) {
+	calls := mergeToolCallDelta(nil, ToolCallDelta{Index: 0, Function: ToolCallFunction{Name: "a"}})
+
+	for _, index := range []int{-1, -1 << 30, len(calls) + maxToolCallIndexGap + 1, 1 << 30} {
+		got := mergeToolCallDelta(calls, ToolCallDelta{Index: index, Function: ToolCallFunction{Name: "b"}})
+		if len(got) != 1 || got[0].Function.Name != "a" {
+			t.Errorf("index %d changed the calls: %+v", index, got)
+		}
+	}
+
+	got := mergeToolCallDelta(calls, ToolCallDelta{Index: 3, Function: ToolCallFunction{Name: "c"}})
+	if len(got) != 4 || got[3].Function.Name != "c" {
+		t.Errorf("a small gap should be accepted: %+v", got)
+	}
+}
+
+func TestToolUser(t *testing.T) {
+	web := withUsageScope(context.Background(), "alice", "")
+	if got := toolUser(web); got != "alice" {
+		t.Errorf("web user = %q", got)
+	}
+
+	// CLI sessions carry the username "user", which must not make them a web user
+	cli := withUsageScope(withLocalRun(context.Background()), "user", "chat_1")
+	if got := toolUser(cli); got != "" {
+		t.Errorf("command line user = %q, want the owner", got)
+	}
+
+	ctx, stop := interruptibleContext()
+	defer stop()
+	if !isLocalRun(ctx) {
+		t.Error("interruptibleContext should mark a local run")
+	}
+	if isLocalRun(context.Background()) {
+		t.Error("an unmarked context must not be local")
+	}
+}
+
+func TestWebFetchPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><script>x()</script><p>internal page</p></html>")
+	}))
+	defer server.Close()
+	args, _ := json.Marshal(map[string]string{"url": server.URL})
+
+	text, err := runWebFetchTool(withLocalRun(context.Background()), args)
+	if err != nil || text != "internal page" {
+		t.Errorf("local fetch = %q, %v", text, err)
+	}
+
+	web := withUsageScope(context.Background(), "alice", "")
+	if _, err := runWebFetchTool(web, args); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("web user reached a loopback address: %v", err)
+	}
+
+	if _, err := runWebFetchTool(withLocalRun(context.Background()), []byte(`{"url":"file:///etc/passwd"}`)); err == nil {
+		t.Error("non-http URL was fetched")
+	}
+}
+
+func TestToolOptionsMaxSteps(t *testing.T) {
+	for _, steps := range []int{0, 1, MaxToolStepsLimit} {
+		if _, err := (ToolOptions{MaxSteps: steps}).definitions(); err != nil {
+			t.Errorf("max_steps %d: %v", steps, err)
+		}
+	}
+	for _, steps := range []int{-1, MaxToolStepsLimit + 1} {
+		_, err := (ToolOptions{MaxSteps: steps}).definitions()
+		if err == nil || !strings.Contains(err.Error(), "0 for the default") {
+			t.Errorf("max_steps %d: err = %v", steps, err)
+		}
+	}
+}
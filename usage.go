@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsageRecord is one line of the usage ledger
+type UsageRecord struct {
+	Timestamp        string  `json:"timestamp"`
+	User             string  `json:"user"`
+	SessionID        string  `json:"session_id,omitempty"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost,omitempty"`
+	Streaming        bool    `json:"streaming,omitempty"`
+}
+
+// UsageSummary aggregates ledger records for one group
+type UsageSummary struct {
+	Key              string  `json:"key"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageScope tells the ledger who a request is made for. It travels in the
+// request context so provider calls don't need extra parameters.
+type UsageScope struct {
+	User      string
+	SessionID string
+}
+
+type usageScopeKey struct{}
+
+var usageLedgerMu sync.Mutex
+
+func withUsageScope(ctx context.Context, user, sessionID string) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, UsageScope{User: user, SessionID: sessionID})
+}
+
+func usageScopeFrom(ctx context.Context) UsageScope {
+	scope, _ := ctx.Value(usageScopeKey{}).(UsageScope)
+	if scope.User == "" {
+		scope.User = "local"
+	}
+	return scope
+}
+
+func getUsageLedgerPath() string {
+	return filepath.Join(getDataDir(), "usage-ledger.jsonl")
+}
+
+// recordUsage appends a successful request to the ledger. Requests without a
+// usage block are still counted, with zero tokens.
+func recordUsage(ctx context.Context, provider, model string, usage *Usage, streaming bool) {
+	scope := usageScopeFrom(ctx)
+	record := UsageRecord{
+		Timestamp: time.Now().Format(time.RFC3339),
+		User:      scope.User,
+		SessionID: scope.SessionID,
+		Provider:  provider,
+		Model:     model,
+		Streaming: streaming,
+	}
+	if usage != nil {
+		record.PromptTokens = usage.PromptTokens
+		record.CompletionTokens = usage.CompletionTokens
+		record.TotalTokens = usage.TotalTokens
+		if record.TotalTokens == 0 {
+			record.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		}
+		record.Cost = usage.Cost
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	usageLedgerMu.Lock()
+	defer usageLedgerMu.Unlock()
+
+	os.MkdirAll(getDataDir(), 0755)
+	f, err := os.OpenFile(getUsageLedgerPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err == nil {
+		countQuotaUsage(record)
+	}
+}
+
+// loadUsageRecords reads the ledger, keeping records at or after since
+func loadUsageRecords(since time.Time) ([]UsageRecord, error) {
+	usageLedgerMu.Lock()
+	defer usageLedgerMu.Unlock()
+	return readUsageRecords(since)
+}
+
+// readUsageRecords must be called with usageLedgerMu held
+func readUsageRecords(since time.Time) ([]UsageRecord, error) {
+	f, err := os.Open(getUsageLedgerPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []UsageRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !since.IsZero() {
+			ts, err := time.Parse(time.RFC3339, record.Timestamp)
+			if err != nil || ts.Before(since) {
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// summarizeUsage groups records by provider, model, user or session and
+// sorts the groups by cost, then by tokens.
+func summarizeUsage(records []UsageRecord, by string) ([]UsageSummary, UsageSummary, error) {
+	keyOf := map[string]func(UsageRecord) string{
+		"provider": func(r UsageRecord) string { return r.Provider },
+		"model":    func(r UsageRecord) string { return r.Provider + "/" + r.Model },
+		"user":     func(r UsageRecord) string { return r.User },
+		"session":  func(r UsageRecord) string { return r.SessionID },
+	}[by]
+	if keyOf == nil {
+		return nil, UsageSummary{}, fmt.Errorf("unknown grouping %q, use provider, model, user or session", by)
+	}
+
+	groups := map[string]*UsageSummary{}
+	total := UsageSummary{Key: "total"}
+	for _, r := range records {
+		key := keyOf(r)
+		if key == "" {
+			key = "-"
+		}
+		g, exists := groups[key]
+		if !exists {
+			g = &UsageSummary{Key: key}
+			groups[key] = g
+		}
+		for _, s := range []*UsageSummary{g, &total} {
+			s.Requests++
+			s.PromptTokens += r.PromptTokens
+			s.CompletionTokens += r.CompletionTokens
+			s.TotalTokens += r.TotalTokens
+			s.Cost += r.Cost
+		}
+	}
+
+	rows := make([]UsageSummary, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, *g)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Cost != rows[j].Cost {
+			return rows[i].Cost > rows[j].Cost
+		}
+		if rows[i].TotalTokens != rows[j].TotalTokens {
+			return rows[i].TotalTokens > rows[j].TotalTokens
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows, total, nil
+}
+
+// parseSince accepts a relative window ("24h", "7d") or a date
+// ("2025-01-31" or RFC 3339). An empty string means no limit.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since value %q, use e.g. 24h, 7d or 2025-01-31", value)
+}
+
+func handleUsageCommand() {
+	since := ""
+	by := "provider"
+
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--since":
+			if i+1 < len(args) {
+				since = args[i+1]
+				i++
+			}
+		case "--by":
+			if i+1 < len(args) {
+				by = args[i+1]
+				i++
+			}
+		default:
+			fmt.Println("Usage: terminal-ai usage [--since 24h|7d|2025-01-31] [--by provider|model|user|session]")
+			os.Exit(1)
+		}
+	}
+
+	sinceTime, err := parseSince(since, time.Now())
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	records, err := loadUsageRecords(sinceTime)
+	if err != nil {
+		fmt.Printf("❌ Failed to read usage ledger: %v\n", err)
+		os.Exit(1)
+	}
+
+	rows, total, err := summarizeUsage(records, by)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("📭 No usage recorded yet")
+		return
+	}
+
+	if since != "" {
+		fmt.Printf("📊 Usage since %s, by %s:\n\n", sinceTime.Format("2006-01-02 15:04"), by)
+	} else {
+		fmt.Printf("📊 Usage by %s:\n\n", by)
+	}
+
+	fmt.Printf("%-40s %8s %12s %12s %12s %10s\n", strings.ToUpper(by), "REQUESTS", "PROMPT", "COMPLETION", "TOTAL", "COST")
+	for _, row := range append(rows, total) {
+		if row.Key == "total" {
+			fmt.Println(strings.Repeat("-", 99))
+		}
+		fmt.Printf("%-40s %8d %12d %12d %12d %10s\n",
+			truncate(row.Key, 40), row.Requests, row.PromptTokens, row.CompletionTokens, row.TotalTokens, formatCost(row.Cost))
+	}
+}
+
+func formatCost(cost float64) string {
+	if cost == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.4f", cost)
+}
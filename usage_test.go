@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"24h", now.Add(-24 * time.Hour), false},
+		{"90m", now.Add(-90 * time.Minute), false},
+		{"7d", now.AddDate(0, 0, -7), false},
+		{" 1d ", now.AddDate(0, 0, -1), false},
+		{"2026-01-31", time.Date(2026, 1, 31, 0, 0, 0, 0, time.Local), false},
+		{"2026-01-31T08:00:00Z", time.Date(2026, 1, 31, 8, 0, 0, 0, time.UTC), false},
+		{"xd", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSince(%q) error = %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeUsage(t *testing.T) {
+	records := []UsageRecord{
+		{User: "alice", Provider: "openrouter", Model: "a", TotalTokens: 100, Cost: 0.01},
+		{User: "bob", Provider: "anthropic", Model: "b", TotalTokens: 50, Cost: 0.05},
+		{User: "alice", Provider: "openrouter", Model: "c", PromptTokens: 10, CompletionTokens: 20, TotalTokens: 30},
+		{User: "", Provider: "ollama", Model: "d", TotalTokens: 500},
+	}
+
+	rows, total, err := summarizeUsage(records, "provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, row := range rows {
+		keys = append(keys, row.Key)
+	}
+	// cost first, then tokens
+	if got := keys; len(got) != 3 || got[0] != "anthropic" || got[1] != "openrouter" || got[2] != "ollama" {
+		t.Errorf("order = %v", got)
+	}
+	if rows[1].Requests != 2 || rows[1].TotalTokens != 130 || rows[1].PromptTokens != 10 {
+		t.Errorf("openrouter row = %+v", rows[1])
+	}
+	if total.Requests != 4 || total.TotalTokens != 680 || total.Cost < 0.0599 || total.Cost > 0.0601 {
+		t.Errorf("total = %+v", total)
+	}
+
+	rows, _, _ = summarizeUsage(records, "user")
+	found := false
+	for _, row := range rows {
+		if row.Key == "-" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("records without a user should be grouped as -: %+v", rows)
+	}
+
+	rows, _, _ = summarizeUsage(records, "model")
+	if rows[0].Key != "anthropic/b" {
+		t.Errorf("model key = %q", rows[0].Key)
+	}
+
+	if _, _, err := summarizeUsage(records, "day"); err == nil {
+		t.Error("unknown grouping should fail")
+	}
+}
+
+func TestUsageLedger(t *testing.T) {
+	isolateDataDir(t)
+
+	ctx := withUsageScope(context.Background(), "alice", "chat_1")
+	recordUsage(ctx, "openrouter", "m", &Usage{PromptTokens: 3, CompletionTokens: 4}, true)
+	recordUsage(context.Background(), "ollama", "m", nil, false)
+
+	records, err := loadUsageRecords(time.Time{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("records = %+v, err = %v", records, err)
+	}
+	if r := records[0]; r.User != "alice" || r.SessionID != "chat_1" || r.TotalTokens != 7 || !r.Streaming {
+		t.Errorf("first record = %+v", r)
+	}
+	if records[1].User != "local" {
+		t.Errorf("a request without a scope should be local, got %q", records[1].User)
+	}
+
+	records, _ = loadUsageRecords(time.Now().Add(time.Hour))
+	if len(records) != 0 {
+		t.Errorf("since in the future kept %d records", len(records))
+	}
+}
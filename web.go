@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -20,22 +20,53 @@ type ChatRequest struct {
 	Provider  string    `json:"provider"`
 	History   []Message `json:"history"`
 	SessionID string    `json:"session_id,omitempty"`
+	System    string    `json:"system,omitempty"`
+	NoCache   bool      `json:"no_cache,omitempty"`
+	// ResponseFormat asks for JSON output validated against a schema,
+	// only on /api/chat
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	GenerationParams
+	ToolOptions
+	ImageOptions
+}
+
+type CompareRequest struct {
+	Message   string   `json:"message"`
+	Providers []string `json:"providers"`
+	System    string   `json:"system,omitempty"`
+	Save      bool     `json:"save,omitempty"`
+	GenerationParams
 }
 
 type ChatResponse struct {
-	Response  string `json:"response"`
-	Timestamp string `json:"timestamp"`
-	SessionID string `json:"session_id,omitempty"`
+	Response  string    `json:"response"`
+	Timestamp string    `json:"timestamp"`
+	SessionID string    `json:"session_id,omitempty"`
+	Cached    bool      `json:"cached,omitempty"`
+	Tools     []ToolRun `json:"tools,omitempty"`
+	// Context lists the skills, documents and memories that were injected
+	Context []ContextSource `json:"context,omitempty"`
+	// JSON is the validated reply when response_format was set
+	JSON json.RawMessage `json:"json,omitempty"`
 }
 
+// HistoryCreateRequest system prompt and parameters become the session defaults
 type HistoryCreateRequest struct {
 	Message  string `json:"message"`
 	Provider string `json:"provider"`
+	System   string `json:"system,omitempty"`
+	GenerationParams
 }
 
+// HistoryUpdateRequest parameters apply to this message only, a system
+// prompt replaces the session's one
 type HistoryUpdateRequest struct {
 	Message  string `json:"message"`
 	Provider string `json:"provider"`
+	System   string `json:"system,omitempty"`
+	GenerationParams
+	ToolOptions
+	ImageOptions
 }
 
 type LoginRequest struct {
@@ -75,6 +106,10 @@ func sendJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 func startWebServer() {
 	router := mux.NewRouter()
 
+	if err := loadQuotaConfig(); err != nil {
+		fmt.Printf("⚠️  Quotas disabled: %v\n", err)
+	}
+
 	port := os.Getenv("WEB_PORT")
 	if port == "" {
 		port = "8080"
@@ -91,6 +126,7 @@ func startWebServer() {
 	router.HandleFunc("/api/chat", authenticate(handleChat)).Methods("POST")
 	router.HandleFunc("/api/chat/stream", authenticate(handleChatStream)).Methods("POST")
 	router.HandleFunc("/api/chat/public", handlePublicChat).Methods("POST")
+	router.HandleFunc("/api/compare", authenticate(handleCompare)).Methods("POST")
 	router.HandleFunc("/api/rag/index", authenticate(handleRAGIndex)).Methods("POST")
 	router.HandleFunc("/api/rag/search", authenticate(handleRAGSearch)).Methods("POST")
 	router.HandleFunc("/api/rag/search/public", handlePublicRAGSearch).Methods("POST")
@@ -108,12 +144,15 @@ func startWebServer() {
 	router.HandleFunc("/api/providers/{name}/priority", authenticate(handleSetProviderPriority)).Methods("PUT")
 	router.HandleFunc("/api/providers/{name}/default", authenticate(handleSetDefaultProvider)).Methods("POST")
 	router.HandleFunc("/api/providers/{name}/test", authenticate(handleTestProvider)).Methods("POST")
+	router.HandleFunc("/api/providers/{name}/models", authenticate(handleListModels)).Methods("GET")
 	router.HandleFunc("/api/providers", authenticate(handleAddProvider)).Methods("POST")
 	router.HandleFunc("/api/providers/{name}", authenticate(handleDeleteProvider)).Methods("DELETE")
 	// OpenRouter BYOK endpoints
 	router.HandleFunc("/api/providers/openrouter/byok", authenticate(handleGetBYOKConfig)).Methods("GET")
 	router.HandleFunc("/api/providers/openrouter/byok", authenticate(handleUpdateBYOKConfig)).Methods("PUT")
 	router.HandleFunc("/api/providers/openrouter/byok/test", authenticate(handleTestBYOK)).Methods("POST")
+	router.HandleFunc("/api/usage", authenticate(handleUsage)).Methods("GET")
+	router.HandleFunc("/api/quota", authenticate(handleQuota)).Methods("GET")
 	router.HandleFunc("/health", handleHealth).Methods("GET")
 
 	corsMiddleware := func(next http.Handler) http.Handler {
@@ -204,10 +243,27 @@ func authenticate(next http.HandlerFunc) http.HandlerFunc {
 
 func handleChat(w http.ResponseWriter, r *http.Request) {
 	var req ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendJSONError(w, http.StatusBadRequest, "Invalid request")
+	attachments, err := decodeWithUploads(w, r, &req, &req.ImageOptions)
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := req.GenerationParams.validate(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tools, err := req.ToolOptions.definitions()
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if req.ResponseFormat != nil {
+		if err := req.ResponseFormat.validate(); err != nil {
+			sendJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	providerName := req.Provider
 	if providerName == "" {
@@ -220,53 +276,40 @@ func handleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		sendJSONError(w, http.StatusInternalServerError, "API key not configured")
 		return
 	}
 
-	messages := req.History
-	if len(messages) == 0 {
-		messages = []Message{{Role: "user", Content: req.Message}}
-	} else {
-		messages = append(messages, Message{Role: "user", Content: req.Message})
+	username := r.Header.Get("X-Username")
+	if !enforceQuota(w, username) {
+		return
 	}
 
-	username := r.Header.Get("X-Username")
-	results := searchRAGWithFilters(req.Message, username, "")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		messages[len(messages)-1].Content += context
+	messages := append(req.History, Message{Role: "user", Content: req.Message, Attachments: attachments})
+	injected := ContextAssembler{User: username, Model: provider.Model}.assemble(r.Context(), req.Message)
+
+	ctx := withUsageScope(r.Context(), username, req.SessionID)
+	if req.NoCache {
+		ctx = withoutCache(ctx)
 	}
 
-	var response *Response
-	var actualProvider string
-	var err error
+	aiReq := Request{
+		Model:            provider.Model,
+		Messages:         withSystemMessage(req.System, injected.inject(messages)),
+		Tools:            tools,
+		MaxSteps:         req.MaxSteps,
+		GenerationParams: resolveParams(injected.Skills, nil, req.GenerationParams),
+	}
 
-	if providerConfig.FallbackEnabled {
-		response, actualProvider, err = makeRequestWithFallback(
-			provider.Endpoint, provider.APIKey, Request{
-				Model:    provider.Model,
-				Messages: messages,
-				Stream:   false,
-			}, providerName,
-		)
-	} else {
-		response, err = makeRequest(provider.Endpoint, provider.APIKey, Request{
-			Model:    provider.Model,
-			Messages: messages,
-			Stream:   false,
-		}, provider.Name)
-		actualProvider = providerName
+	if req.ResponseFormat != nil {
+		aiReq.ResponseFormat = req.ResponseFormat
+		handleStructuredChat(ctx, w, aiReq, providerName, injected.Sources)
+		return
 	}
 
+	response, actualProvider, err := runAgent(ctx, aiReq, providerName, nil, nil)
+
 	if err != nil {
 		sendJSONError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -287,6 +330,9 @@ func handleChat(w http.ResponseWriter, r *http.Request) {
 	resp := ChatResponse{
 		Response:  content,
 		Timestamp: time.Now().Format(time.RFC3339),
+		Cached:    response.Cached,
+		Tools:     response.ToolRuns,
+		Context:   injected.Sources,
 	}
 
 	if actualProvider != req.Provider && req.Provider != "" {
@@ -297,13 +343,57 @@ func handleChat(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleStructuredChat answers with validated JSON, or 422 with the
+// validation errors when the model never produced a matching reply.
+func handleStructuredChat(ctx context.Context, w http.ResponseWriter, req Request, providerName string, sources []ContextSource) {
+	data, response, _, err := structuredChat(ctx, req, providerName, DefaultJSONRetries)
+	if err != nil {
+		var structErr *StructuredOutputError
+		if errors.As(err, &structErr) {
+			sendJSONResponse(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"error":    "reply does not match the JSON schema",
+				"errors":   structErr.Errors,
+				"response": structErr.Reply,
+			})
+			return
+		}
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, ChatResponse{
+		Response:  string(data),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Cached:    response.Cached,
+		Tools:     response.ToolRuns,
+		Context:   sources,
+		JSON:      json.RawMessage(data),
+	})
+}
+
 func handleChatStream(w http.ResponseWriter, r *http.Request) {
 	var req ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	attachments, err := decodeWithUploads(w, r, &req, &req.ImageOptions)
+	if err != nil {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
-		fmt.Fprintf(w, "data: {\"error\": \"Invalid request\"}\n\n")
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		return
+	}
+
+	if err := req.GenerationParams.validate(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tools, err := req.ToolOptions.definitions()
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.ResponseFormat != nil {
+		sendJSONError(w, http.StatusBadRequest, "response_format is only supported on /api/chat")
 		return
 	}
 
@@ -321,7 +411,7 @@ func handleChatStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
@@ -329,27 +419,15 @@ func handleChatStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages := req.History
-	if len(messages) == 0 {
-		messages = []Message{{Role: "user", Content: req.Message}}
-	} else {
-		messages = append(messages, Message{Role: "user", Content: req.Message})
-	}
-
 	username := r.Header.Get("X-Username")
-	results := searchRAGWithFilters(req.Message, username, "")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		messages[len(messages)-1].Content += context
+	// Checked before the SSE headers so clients get a real 429
+	if !enforceQuota(w, username) {
+		return
 	}
 
+	messages := append(req.History, Message{Role: "user", Content: req.Message, Attachments: attachments})
+	injected := ContextAssembler{User: username, Model: provider.Model}.assemble(r.Context(), req.Message)
+
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -364,141 +442,108 @@ func handleChatStream(w http.ResponseWriter, r *http.Request) {
 
 	// Build request
 	aiReq := Request{
-		Model:    provider.Model,
-		Messages: messages,
-		Stream:   true,
-	}
-
-	var reqBody []byte
-	var err error
-
-	// Check if OpenRouter with BYOK enabled
-	if providerName == "openrouter" {
-		if config, exists := providerConfig.Providers["openrouter"]; exists && config.BYOKConfig != nil && config.BYOKConfig.Enabled {
-			openRouterReq := OpenRouterRequest{
-				Model:    aiReq.Model,
-				Messages: aiReq.Messages,
-				Stream:   true,
-				Provider: &OpenRouterProvider{
-					AllowFallbacks: config.BYOKConfig.AllowFallbackToShared,
-					Order:          config.BYOKConfig.ProviderOrder,
-				},
-			}
-			reqBody, err = json.Marshal(openRouterReq)
-		} else {
-			reqBody, err = json.Marshal(aiReq)
-		}
-	} else {
-		reqBody, err = json.Marshal(aiReq)
-	}
-
-	if err != nil {
-		fmt.Fprintf(w, "data: {\"error\": \"Failed to marshal request\"}\n\n")
-		flusher.Flush()
-		return
+		Model:            provider.Model,
+		Messages:         withSystemMessage(req.System, injected.inject(messages)),
+		Stream:           true,
+		Tools:            tools,
+		MaxSteps:         req.MaxSteps,
+		GenerationParams: resolveParams(injected.Skills, nil, req.GenerationParams),
 	}
 
-	// Make HTTP request with extended timeout for streaming
-	// Using 300 seconds (5 minutes) to handle long articles
-	client := &http.Client{Timeout: 300 * time.Second}
-	httpReq, err := http.NewRequest("POST", provider.Endpoint, strings.NewReader(string(reqBody)))
-	if err != nil {
-		fmt.Fprintf(w, "data: {\"error\": \"%s\"}\n\n", err.Error())
+	if len(injected.Sources) > 0 {
+		data, _ := json.Marshal(map[string][]ContextSource{"context": injected.Sources})
+		fmt.Fprintf(w, "data: %s\n\n", data)
 		flusher.Flush()
-		return
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	if providerName == "openrouter" {
-		httpReq.Header.Set("Authorization", "Bearer "+provider.APIKey)
-		httpReq.Header.Set("HTTP-Referer", "https://terminal-ai.local")
-		httpReq.Header.Set("X-Title", "Terminal AI CLI")
-	} else if providerName == "gemini" {
-		httpReq.Header.Set("x-goog-api-key", provider.APIKey)
-	} else {
-		httpReq.Header.Set("Authorization", "Bearer "+provider.APIKey)
 	}
 
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		fmt.Fprintf(w, "data: {\"error\": \"%s\"}\n\n", err.Error())
-		flusher.Flush()
-		return
-	}
-	defer resp.Body.Close()
-
 	// Stream response with heartbeat for long streams
-	reader := bufio.NewReader(resp.Body)
 	lastHeartbeat := time.Now()
 
-	for {
+	ctx := withUsageScope(r.Context(), username, req.SessionID)
+	if req.NoCache {
+		ctx = withoutCache(ctx)
+	}
+
+	_, _, err = runAgent(ctx, aiReq, providerName, func(content string) {
 		// Check if we should send a heartbeat (every 30 seconds)
 		if time.Since(lastHeartbeat) > 30*time.Second {
 			fmt.Fprintf(w, ": heartbeat\n\n")
-			flusher.Flush()
 			lastHeartbeat = time.Now()
 		}
 
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			fmt.Fprintf(w, "data: {\"error\": \"%s\"}\n\n", err.Error())
-			flusher.Flush()
-			return
+		data, _ := json.Marshal(map[string]string{"content": content})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}, func(run ToolRun) {
+		data, _ := json.Marshal(map[string]ToolRun{"tool": run})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	})
+	if err != nil {
+		var interrupted *StreamInterruptedError
+		if errors.As(err, &interrupted) {
+			// The client already has the partial text, tell it the answer can be resumed
+			data, _ := json.Marshal(map[string]interface{}{
+				"error":     err.Error(),
+				"partial":   interrupted.Partial,
+				"resumable": true,
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		} else {
+			writeSSEError(w, err.Error())
 		}
+		flusher.Flush()
+		return
+	}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	// Send final DONE message
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
 
-		// Check for SSE data prefix
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	var req CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
 
-		data := strings.TrimPrefix(line, "data: ")
+	if req.Message == "" || len(req.Providers) < 2 {
+		sendJSONError(w, http.StatusBadRequest, "message and at least two providers are required")
+		return
+	}
 
-		// Check for stream end
-		if data == "[DONE]" {
-			fmt.Fprintf(w, "data: [DONE]\n\n")
-			flusher.Flush()
-			break
-		}
+	if err := req.GenerationParams.validate(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-		// Parse the streaming response
-		var streamResp StreamingResponse
-		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-			// Some providers might send different formats, skip unparseable lines
-			continue
-		}
+	// every provider is a separate request
+	username := r.Header.Get("X-Username")
+	if !enforceQuotaFor(w, username, len(req.Providers)) {
+		return
+	}
 
-		// Check for API errors in stream
-		if streamResp.Error != nil {
-			fmt.Fprintf(w, "data: {\"error\": \"%s\"}\n\n", streamResp.Error.Message)
-			flusher.Flush()
-			return
-		}
+	results := compareProviders(withUsageScope(r.Context(), username, ""), req.Providers, Request{
+		Messages:         withSystemMessage(req.System, []Message{{Role: "user", Content: req.Message}}),
+		GenerationParams: req.GenerationParams,
+	})
 
-		// Extract content
-		if len(streamResp.Choices) > 0 {
-			content := streamResp.Choices[0].Delta.Content
-			if content != "" {
-				// Escape quotes in content for JSON
-				content = strings.ReplaceAll(content, "\\", "\\\\")
-				content = strings.ReplaceAll(content, "\"", "\\\"")
-				fmt.Fprintf(w, "data: {\"content\": \"%s\"}\n\n", content)
-				flusher.Flush()
-			}
-		}
+	response := map[string]interface{}{
+		"results":   results,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	if req.Save {
+		session := saveCompareSession(username, req.Message, req.System, req.GenerationParams, results)
+		response["session_id"] = session.ID
 	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
 
-	// Send final DONE message
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+// writeSSEError sends an error event to a streaming client
+func writeSSEError(w http.ResponseWriter, message string) {
+	data, _ := json.Marshal(map[string]string{"error": message})
+	fmt.Fprintf(w, "data: %s\n\n", data)
 }
 
 func handleListSkills(w http.ResponseWriter, r *http.Request) {
@@ -546,6 +591,65 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleUsage summarizes the usage ledger. Admins see everyone, other users
+// only their own requests. Query parameters: since (24h, 7d, 2025-01-31) and
+// by (provider, model, user, session).
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	username := r.Header.Get("X-Username")
+
+	since, err := parseSince(r.URL.Query().Get("since"), time.Now())
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "provider"
+	}
+
+	records, err := loadUsageRecords(since)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if user, exists := securityMgr.users[username]; !exists || user.Role != "admin" {
+		var own []UsageRecord
+		for _, record := range records {
+			if record.User == username {
+				own = append(own, record)
+			}
+		}
+		records = own
+	}
+
+	rows, total, err := summarizeUsage(records, by)
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"by":    by,
+		"rows":  rows,
+		"total": total,
+	}
+	if !since.IsZero() {
+		response["since"] = since.Format(time.RFC3339)
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+	status, err := checkQuota(r.Header.Get("X-Username"))
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, status)
+}
+
 func handleListHistory(w http.ResponseWriter, r *http.Request) {
 	username := r.Header.Get("X-Username")
 	sessions := listSessions()
@@ -588,7 +692,16 @@ func handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := req.GenerationParams.validate(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	username := r.Header.Get("X-Username")
+	if !enforceQuota(w, username) {
+		return
+	}
+
 	providerName := req.Provider
 	if providerName == "" {
 		providerName = providerConfig.DefaultProvider
@@ -600,46 +713,38 @@ func handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		sendJSONError(w, http.StatusInternalServerError, "API key not configured")
 		return
 	}
 
 	session := createSession(req.Message, providerName, username)
+	session.System = req.System
+	session.Params = req.GenerationParams.record()
+	saveSessionSettings(session)
 	updateSession(session.ID, "user", req.Message)
 
-	messages := []Message{{Role: "user", Content: req.Message}}
-
-	results := searchRAGWithFilters(req.Message, username, "")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		messages[0].Content += context
-	}
+	injected := ContextAssembler{User: username, Model: provider.Model}.assemble(r.Context(), req.Message)
+	messages := injected.inject([]Message{{Role: "user", Content: req.Message}})
+	params := resolveParams(injected.Skills, nil, req.GenerationParams)
 
 	var response *Response
+	var actualProvider string
 	var aiErr error
 
 	if providerConfig.FallbackEnabled {
-		response, _, aiErr = makeRequestWithFallback(
-			provider.Endpoint, provider.APIKey, Request{
-				Model:    provider.Model,
-				Messages: messages,
-				Stream:   false,
-			}, providerName,
-		)
+		response, actualProvider, aiErr = makeRequestWithFallback(withUsageScope(r.Context(), username, session.ID), Request{
+			Model:            provider.Model,
+			Messages:         withSystemMessage(session.System, messages),
+			GenerationParams: params,
+		}, providerName)
 	} else {
-		response, aiErr = makeRequest(provider.Endpoint, provider.APIKey, Request{
-			Model:    provider.Model,
-			Messages: messages,
-			Stream:   false,
-		}, provider.Name)
+		response, aiErr = makeRequest(withUsageScope(r.Context(), username, session.ID), providerName, Request{
+			Model:            provider.Model,
+			Messages:         withSystemMessage(session.System, messages),
+			GenerationParams: params,
+		})
+		actualProvider = providerName
 	}
 
 	if aiErr != nil {
@@ -655,16 +760,26 @@ func handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	var content string
 	if len(response.Choices) > 0 {
 		content = response.Choices[0].Message.Content
-		updateSession(session.ID, "assistant", content)
+		appendSessionMessage(session.ID, ChatMessage{
+			Role:     "assistant",
+			Content:  content,
+			Provider: actualProvider,
+			Params:   providerParams(actualProvider).merge(params).record(),
+			Context:  injected.Sources,
+		})
 	} else {
 		content = "No response generated"
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	result := map[string]interface{}{
 		"session_id": session.ID,
 		"response":   content,
-	})
+	}
+	if len(injected.Sources) > 0 {
+		result["context"] = injected.Sources
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
 func handleUpdateSession(w http.ResponseWriter, r *http.Request) {
@@ -684,8 +799,24 @@ func handleUpdateSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req HistoryUpdateRequest
-	if decodeErr := json.NewDecoder(r.Body).Decode(&req); decodeErr != nil {
-		sendJSONError(w, http.StatusBadRequest, "Invalid request")
+	attachments, decodeErr := decodeWithUploads(w, r, &req, &req.ImageOptions)
+	if decodeErr != nil {
+		sendJSONError(w, http.StatusBadRequest, decodeErr.Error())
+		return
+	}
+
+	if err := req.GenerationParams.validate(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	params := resolveParams(nil, session, req.GenerationParams)
+	tools, toolErr := req.ToolOptions.definitions()
+	if toolErr != nil {
+		sendJSONError(w, http.StatusBadRequest, toolErr.Error())
+		return
+	}
+
+	if !enforceQuota(w, username) {
 		return
 	}
 
@@ -700,51 +831,45 @@ func handleUpdateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		sendJSONError(w, http.StatusInternalServerError, "API key not configured")
 		return
 	}
 
-	updateSession(sessionID, "user", req.Message)
-
-	messages := []Message{{Role: "user", Content: req.Message}}
-	for _, msg := range session.Messages {
-		if msg.Role == "user" || msg.Role == "assistant" {
-			messages = append(messages, Message{Role: msg.Role, Content: msg.Content})
-		}
+	// only a request that will be answered changes the session
+	if req.System != "" {
+		session.System = req.System
+		saveSessionSettings(session)
 	}
 
-	results := searchRAGWithFilters(req.Message, username, "")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		messages[len(messages)-1].Content += context
+	if err := appendSessionMessage(sessionID, ChatMessage{Role: "user", Content: req.Message, Attachments: attachments}); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	var response *Response
-	var aiErr error
+	model := provider.Model
+	if session.Model != "" && providerName == session.Provider {
+		model = session.Model
+	}
 
-	if providerConfig.FallbackEnabled {
-		response, _, aiErr = makeRequestWithFallback(
-			provider.Endpoint, provider.APIKey, Request{
-				Model:    provider.Model,
-				Messages: messages,
-				Stream:   false,
-			}, providerName,
-		)
-	} else {
-		response, aiErr = makeRequest(provider.Endpoint, provider.APIKey, Request{
-			Model:    provider.Model,
-			Messages: messages,
-			Stream:   false,
-		}, provider.Name)
+	ctx := withUsageScope(r.Context(), username, sessionID)
+	injected := ContextAssembler{User: username, Model: model}.assemble(ctx, req.Message)
+	params = resolveParams(injected.Skills, session, req.GenerationParams)
+	plan := buildContext(ctx, session, providerName, model, params, injected)
+	if plan.BudgetClamped {
+		log.Printf("session %s: max_tokens leaves almost none of %s's context window, prompt budget clamped to %d", sessionID, model, plan.Budget)
 	}
+	if plan.SummaryErr != nil {
+		log.Printf("session %s: could not summarize older messages: %v", sessionID, plan.SummaryErr)
+	}
+
+	response, actualProvider, aiErr := runAgent(ctx, Request{
+		Model:            model,
+		Messages:         plan.Messages,
+		Tools:            tools,
+		MaxSteps:         req.MaxSteps,
+		GenerationParams: params,
+	}, providerName, nil, nil)
 
 	if aiErr != nil {
 		sendJSONError(w, http.StatusInternalServerError, aiErr.Error())
@@ -759,16 +884,30 @@ func handleUpdateSession(w http.ResponseWriter, r *http.Request) {
 	var content string
 	if len(response.Choices) > 0 {
 		content = response.Choices[0].Message.Content
-		updateSession(sessionID, "assistant", content)
+		appendSessionMessage(sessionID, ChatMessage{
+			Role:     "assistant",
+			Content:  content,
+			Provider: actualProvider,
+			Params:   providerParams(actualProvider).merge(params).record(),
+			Context:  injected.Sources,
+		})
 	} else {
 		content = "No response generated"
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	result := map[string]interface{}{
 		"response":  content,
 		"timestamp": time.Now().Format(time.RFC3339),
-	})
+	}
+	if len(response.ToolRuns) > 0 {
+		result["tools"] = response.ToolRuns
+	}
+	if len(injected.Sources) > 0 {
+		result["context"] = injected.Sources
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
 func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
@@ -907,27 +1046,10 @@ func handleTestBYOK(w http.ResponseWriter, r *http.Request) {
 		Stream: false,
 	}
 
-	// Build OpenRouter request with BYOK
-	openRouterReq := OpenRouterRequest{
-		Model:    req.Model,
-		Messages: req.Messages,
-		Stream:   req.Stream,
-		Provider: &OpenRouterProvider{
-			AllowFallbacks: openrouterConfig.BYOKConfig.AllowFallbackToShared,
-			Order:          openrouterConfig.BYOKConfig.ProviderOrder,
-		},
-	}
-
-	reqBody, _ := json.Marshal(openRouterReq)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	httpReq, _ := http.NewRequest("POST", provider.Endpoint, strings.NewReader(string(reqBody)))
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+provider.APIKey)
-	httpReq.Header.Set("HTTP-Referer", "https://terminal-ai.local")
-	httpReq.Header.Set("X-Title", "Terminal AI CLI")
-
-	resp, err := client.Do(httpReq)
+	response, err := newOpenRouterProvider(provider).Chat(ctx, req)
 	if err != nil {
 		results = append(results, TestResult{
 			Provider: "OpenRouter",
@@ -941,12 +1063,6 @@ func handleTestBYOK(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var response Response
-	json.Unmarshal(body, &response)
 
 	if response.Error != nil {
 		results = append(results, TestResult{
@@ -1063,6 +1179,11 @@ func handlePublicChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := req.GenerationParams.validate(); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	providerName := req.Provider
 	if providerName == "" {
 		providerName = providerConfig.DefaultProvider
@@ -1074,7 +1195,7 @@ func handlePublicChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		sendJSONError(w, http.StatusInternalServerError, "API key not configured")
 		return
 	}
@@ -1086,37 +1207,30 @@ func handlePublicChat(w http.ResponseWriter, r *http.Request) {
 		messages = append(messages, Message{Role: "user", Content: req.Message})
 	}
 
-	results := searchRAGWithFilters(req.Message, "", "public")
-	if len(results) > 0 {
-		context := "\n\nRelevant documents:\n"
-		for _, doc := range results {
-			contentLen := len(doc.Content)
-			if contentLen > 200 {
-				contentLen = 200
-			}
-			context += fmt.Sprintf("- %s: %s\n", doc.Path, doc.Content[:contentLen])
-		}
-		messages[len(messages)-1].Content += context
+	if !enforceQuota(w, PublicUser) {
+		return
 	}
 
+	injected := ContextAssembler{Visibility: "public", Model: provider.Model}.assemble(r.Context(), req.Message)
+	messages = injected.inject(messages)
+	params := resolveParams(injected.Skills, nil, req.GenerationParams)
+
 	var response *Response
 	var actualProvider string
 	var err error
 
 	if providerConfig.FallbackEnabled {
-		response, actualProvider, err = makeRequestWithFallback(
-			provider.Endpoint, provider.APIKey, Request{
-				Model:    provider.Model,
-				Messages: messages,
-				Stream:   false,
-			}, providerName,
-		)
+		response, actualProvider, err = makeRequestWithFallback(withUsageScope(r.Context(), PublicUser, ""), Request{
+			Model:            provider.Model,
+			Messages:         withSystemMessage(req.System, messages),
+			GenerationParams: params,
+		}, providerName)
 	} else {
-		response, err = makeRequest(provider.Endpoint, provider.APIKey, Request{
-			Model:    provider.Model,
-			Messages: messages,
-			Stream:   false,
-		}, provider.Name)
+		response, err = makeRequest(withUsageScope(r.Context(), PublicUser, ""), providerName, Request{
+			Model:            provider.Model,
+			Messages:         withSystemMessage(req.System, messages),
+			GenerationParams: params,
+		})
 		actualProvider = providerName
 	}
 
@@ -1140,6 +1254,7 @@ func handlePublicChat(w http.ResponseWriter, r *http.Request) {
 	resp := ChatResponse{
 		Response:  content,
 		Timestamp: time.Now().Format(time.RFC3339),
+		Context:   injected.Sources,
 	}
 
 	if actualProvider != req.Provider && req.Provider != "" {
@@ -1151,20 +1266,23 @@ func handlePublicChat(w http.ResponseWriter, r *http.Request) {
 }
 
 type ProviderInfo struct {
-	Name        string `json:"name"`
-	Priority    int    `json:"priority"`
-	Enabled     bool   `json:"enabled"`
-	MaxRetries  int    `json:"max_retries"`
-	Endpoint    string `json:"endpoint"`
-	Model       string `json:"model"`
-	BYOK        bool   `json:"byok"`
-	IsDefault   bool   `json:"is_default"`
-	APIKey      string `json:"api_key"`
-	Description string `json:"description"`
+	Name        string           `json:"name"`
+	Type        string           `json:"type"`
+	Priority    int              `json:"priority"`
+	Enabled     bool             `json:"enabled"`
+	MaxRetries  int              `json:"max_retries"`
+	Endpoint    string           `json:"endpoint"`
+	Model       string           `json:"model"`
+	BYOK        bool             `json:"byok"`
+	IsDefault   bool             `json:"is_default"`
+	APIKey      string           `json:"api_key"`
+	Description string           `json:"description"`
+	Health      []ProviderHealth `json:"health,omitempty"`
 }
 
 type AddProviderRequest struct {
 	Name     string `json:"name"`
+	Type     string `json:"type"`
 	Priority int    `json:"priority"`
 	Endpoint string `json:"endpoint"`
 	Model    string `json:"model"`
@@ -1186,6 +1304,7 @@ func handleListProviders(w http.ResponseWriter, r *http.Request) {
 
 		info := ProviderInfo{
 			Name:        providerName,
+			Type:        provider.Type,
 			Priority:    config.Priority,
 			Enabled:     config.Enabled,
 			MaxRetries:  config.MaxRetries,
@@ -1194,6 +1313,7 @@ func handleListProviders(w http.ResponseWriter, r *http.Request) {
 			BYOK:        config.BYOK,
 			IsDefault:   providerName == providerConfig.DefaultProvider,
 			Description: config.Description,
+			Health:      getProviderHealth(providerName),
 		}
 
 		if provider.APIKey != "" {
@@ -1221,6 +1341,7 @@ func handleGetProvider(w http.ResponseWriter, r *http.Request) {
 
 	info := ProviderInfo{
 		Name:        providerName,
+		Type:        provider.Type,
 		Priority:    config.Priority,
 		Enabled:     config.Enabled,
 		MaxRetries:  config.MaxRetries,
@@ -1353,7 +1474,7 @@ func handleTestProvider(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider.APIKey == "" {
+	if !providerReady(provider) {
 		sendJSONError(w, http.StatusInternalServerError, "API key not configured")
 		return
 	}
@@ -1366,7 +1487,7 @@ func handleTestProvider(w http.ResponseWriter, r *http.Request) {
 		Stream: false,
 	}
 
-	response, err := makeRequest(provider.Endpoint, provider.APIKey, req, provider.Name)
+	response, err := makeRequest(withUsageScope(r.Context(), r.Header.Get("X-Username"), ""), providerName, req)
 
 	if err != nil {
 		sendJSONError(w, http.StatusInternalServerError, err.Error())
@@ -1409,28 +1530,33 @@ func handleAddProvider(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	config := AIProviderConfig{
-		Priority:    req.Priority,
-		Enabled:     true,
-		MaxRetries:  2,
-		EnvKey:      strings.ToUpper(req.Name) + "_API_KEY",
-		EndpointKey: strings.ToUpper(req.Name) + "_ENDPOINT",
-		ModelKey:    strings.ToUpper(req.Name) + "_MODEL",
-		BYOK:        true,
-		Description: "Custom BYOK provider",
-		GopassKey:   "terminal-ai/" + req.Name + "_api_key",
+	if req.Type != "" {
+		if _, ok := providerFactories[req.Type]; !ok {
+			sendJSONError(w, http.StatusBadRequest, "Unknown provider type")
+			return
+		}
 	}
 
-	providerConfig.Providers[req.Name] = config
+	config := customProviderConfig(req.Name, req.Type, req.Priority, req.Endpoint, req.Model)
 
-	providers[req.Name] = AIProvider{
-		Name:     req.Name,
-		APIKey:   req.APIKey,
-		Endpoint: req.Endpoint,
-		Model:    req.Model,
+	provider := buildProvider(req.Name, config)
+	if req.APIKey != "" {
+		provider.APIKey = req.APIKey
 	}
+	providers[req.Name] = provider
 
-	if err := saveProviderConfig(); err != nil {
+	if err := validateModel(r.Context(), req.Name, req.Model); err != nil {
+		var unknown *UnknownModelError
+		if errors.As(err, &unknown) {
+			delete(providers, req.Name)
+			sendJSONError(w, http.StatusBadRequest, unknown.Error())
+			return
+		}
+		log.Printf("Could not verify model %q for %s: %v", req.Model, req.Name, err)
+	}
+
+	if err := saveCustomProvider(req.Name, config, req.APIKey); err != nil {
+		delete(providers, req.Name)
 		sendJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -1460,6 +1586,7 @@ func handleDeleteProvider(w http.ResponseWriter, r *http.Request) {
 		sendJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	deleteProviderSecret(providerName)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})